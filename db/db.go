@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -15,13 +16,27 @@ type Category struct {
 	Description string
 }
 
+// Role is a user role; a higher rank includes everything a lower one may do
+type Role struct {
+	Name        string
+	Rank        int
+	Description string
+	Permissions []string
+}
+
+// Permission is something a role can be allowed to do
+type Permission struct {
+	Name        string
+	Description string
+}
+
 // global variable for database connection
 var DB *sql.DB
 
-// Initialize initializes the database connection and applies the schema
-func Initialize() error {
+// Initialize opens the database at path and applies the schema
+func Initialize(path string) error {
 	var err error
-	DB, err = sql.Open("sqlite3", "./forum.db")
+	DB, err = sql.Open("sqlite3", withForeignKeys(path))
 	if err != nil {
 		return fmt.Errorf("failed to open database: %v", err)
 	}
@@ -38,16 +53,37 @@ func Initialize() error {
 		return fmt.Errorf("failed to apply schema: %v", err)
 	}
 
+	// Bring tables created by older versions up to date
+	err = applyMigrations()
+	if err != nil {
+		return fmt.Errorf("failed to apply migrations: %v", err)
+	}
+
 	// Ensure default categories exist
 	err = EnsureDefaultCategories()
 	if err != nil {
 		return fmt.Errorf("failed to ensure default categories: %v", err)
 	}
 
+	// Ensure the built-in roles and their permissions exist
+	err = EnsureDefaultRoles()
+	if err != nil {
+		return fmt.Errorf("failed to ensure default roles: %v", err)
+	}
+
 	log.Println("Database initialized successfully")
 	return nil
 }
 
+// withForeignKeys adds the option that makes SQLite enforce foreign keys,
+// which it otherwise leaves off, on every connection
+func withForeignKeys(path string) string {
+	if strings.Contains(path, "?") {
+		return path + "&_foreign_keys=on"
+	}
+	return path + "?_foreign_keys=on"
+}
+
 // applySchema applies the SQL schema from the schema.sql file
 func applySchema() error {
 	schemaContent, err := os.ReadFile("./db/schema.sql")
@@ -105,3 +141,44 @@ func EnsureDefaultCategories() error {
 
 	return nil
 }
+
+// EnsureDefaultRoles inserts the built-in roles and permissions and grants
+// each role its default permissions. Grants added by hand are left alone.
+func EnsureDefaultRoles() error {
+	permissions := []Permission{
+		{Name: "moderate_posts", Description: "Edit or delete any post"},
+		{Name: "moderate_comments", Description: "Delete any comment"},
+		{Name: "manage_users", Description: "Change other users' roles"},
+	}
+	roles := []Role{
+		{Name: "member", Rank: 0, Description: "Can post, comment and react"},
+		{Name: "moderator", Rank: 10, Description: "Can also moderate posts and comments",
+			Permissions: []string{"moderate_posts", "moderate_comments"}},
+		{Name: "admin", Rank: 20, Description: "Can do everything, including managing users",
+			Permissions: []string{"moderate_posts", "moderate_comments", "manage_users"}},
+	}
+
+	for _, permission := range permissions {
+		_, err := DB.Exec(`INSERT OR IGNORE INTO permissions (name, description) VALUES (?, ?)`,
+			permission.Name, permission.Description)
+		if err != nil {
+			return fmt.Errorf("failed to ensure permission '%s': %v", permission.Name, err)
+		}
+	}
+	for _, role := range roles {
+		_, err := DB.Exec(`INSERT OR IGNORE INTO roles (name, rank, description) VALUES (?, ?, ?)`,
+			role.Name, role.Rank, role.Description)
+		if err != nil {
+			return fmt.Errorf("failed to ensure role '%s': %v", role.Name, err)
+		}
+		for _, permission := range role.Permissions {
+			_, err := DB.Exec(`INSERT OR IGNORE INTO role_permissions (role, permission) VALUES (?, ?)`,
+				role.Name, permission)
+			if err != nil {
+				return fmt.Errorf("failed to grant '%s' to role '%s': %v", permission, role.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// columnMigration adds a column that was introduced after its table was first
+// created. CREATE TABLE IF NOT EXISTS in schema.sql leaves existing tables
+// untouched, so databases created by earlier versions pick the column up here.
+type columnMigration struct {
+	Table      string
+	Column     string
+	Definition string
+	// Backfill optionally runs once, right after the column is added
+	Backfill string
+}
+
+var columnMigrations = []columnMigration{
+	{Table: "sessions", Column: "user_agent", Definition: "TEXT NOT NULL DEFAULT ''"},
+	// Accounts from before verification existed are treated as verified
+	{Table: "users", Column: "email_verified", Definition: "INTEGER NOT NULL DEFAULT 0", Backfill: "UPDATE users SET email_verified = 1"},
+	// Sessions from before CSRF protection get a token of their own
+	{Table: "sessions", Column: "csrf_token", Definition: "TEXT NOT NULL DEFAULT ''", Backfill: "UPDATE sessions SET csrf_token = lower(hex(randomblob(32)))"},
+	{Table: "login_challenges", Column: "remember", Definition: "INTEGER NOT NULL DEFAULT 0"},
+	{Table: "users", Column: "role", Definition: "TEXT NOT NULL DEFAULT 'member'"},
+	{Table: "login_challenges", Column: "redirect", Definition: "TEXT NOT NULL DEFAULT ''"},
+	{Table: "posts", Column: "updated_at", Definition: "DATETIME DEFAULT NULL"},
+	{Table: "posts", Column: "updated_by", Definition: "INTEGER DEFAULT NULL"},
+	{Table: "posts", Column: "deleted_at", Definition: "DATETIME DEFAULT NULL"},
+	{Table: "posts", Column: "deleted_by", Definition: "INTEGER DEFAULT NULL"},
+	{Table: "comments", Column: "deleted_at", Definition: "DATETIME DEFAULT NULL"},
+	{Table: "comments", Column: "deleted_by", Definition: "INTEGER DEFAULT NULL"},
+	{Table: "posts", Column: "publish_at", Definition: "DATETIME DEFAULT NULL"},
+	{Table: "drafts", Column: "image", Definition: "TEXT DEFAULT NULL"},
+}
+
+// cascadeTables hold rows that belong to a post or a comment. Their foreign
+// keys on posts(id) and comments(id) delete them along with their parent, in
+// the order listed; tables created before the keys cascaded are rebuilt.
+var cascadeTables = []string{"comments", "comment_reactions", "post_categories", "post_reactions", "post_revisions"}
+
+var (
+	parentReference = regexp.MustCompile(`(?i)REFERENCES\s+(posts|comments)\s*\(\s*id\s*\)(\s+ON\s+DELETE\s+(SET\s+NULL|SET\s+DEFAULT|NO\s+ACTION|RESTRICT|CASCADE))?`)
+	tableHeader     = regexp.MustCompile(`^CREATE TABLE\s+("[^"]+"|\S+)\s*\(`)
+)
+
+// applyMigrations brings tables created by older schema versions up to date
+func applyMigrations() error {
+	for _, table := range cascadeTables {
+		if err := ensureCascade(table); err != nil {
+			return err
+		}
+	}
+	for _, m := range columnMigrations {
+		added, err := ensureColumn(m.Table, m.Column, m.Definition)
+		if err != nil {
+			return err
+		}
+		if added && m.Backfill != "" {
+			if _, err := DB.Exec(m.Backfill); err != nil {
+				return fmt.Errorf("failed to backfill %s.%s: %v", m.Table, m.Column, err)
+			}
+		}
+		if added {
+			log.Printf("Added column %s.%s", m.Table, m.Column)
+		}
+	}
+	return nil
+}
+
+// ensureColumn adds the column to the table unless it already exists and
+// reports whether it was added
+func ensureColumn(table, column, definition string) (bool, error) {
+	exists, err := columnExists(table, column)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("failed to add column %s.%s: %v", table, column, err)
+	}
+	return true, nil
+}
+
+// columnExists checks the table definition for the named column
+func columnExists(table, column string) (bool, error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect table %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			name, ctype  string
+			notNull, pk  int
+			defaultValue interface{}
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &defaultValue, &pk); err != nil {
+			return false, fmt.Errorf("failed to scan column info: %v", err)
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// foreignKey is one of a table's foreign keys on posts or comments
+type foreignKey struct {
+	Column   string
+	Parent   string
+	OnDelete string
+}
+
+// ensureCascade rebuilds the table if any of its keys on posts or comments
+// doesn't cascade yet. SQLite can't change a foreign key in place, so the
+// rows are copied into a new table, following SQLite's own procedure. Rows
+// whose post or comment is already gone are dropped on the way, as the
+// cascade would have done.
+func ensureCascade(table string) error {
+	keys, err := parentKeys(table)
+	if err != nil {
+		return err
+	}
+	cascades := true
+	for _, key := range keys {
+		if key.OnDelete != "CASCADE" {
+			cascades = false
+		}
+	}
+	if cascades {
+		return nil
+	}
+
+	var definition string
+	if err := DB.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&definition); err != nil {
+		return fmt.Errorf("failed to read definition of %s: %v", table, err)
+	}
+	rebuilt := table + "_rebuilt"
+	definition = tableHeader.ReplaceAllString(definition, "CREATE TABLE "+rebuilt+" (")
+	definition = parentReference.ReplaceAllStringFunc(definition, func(reference string) string {
+		parent := parentReference.FindStringSubmatch(reference)[1]
+		return "REFERENCES " + strings.ToLower(parent) + "(id) ON DELETE CASCADE"
+	})
+
+	// Foreign keys can only be switched off outside a transaction, and only
+	// for one connection, so the rebuild holds on to a single one
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		definition,
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", rebuilt, table),
+		fmt.Sprintf("DROP TABLE %s", table),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", rebuilt, table),
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to rebuild %s: %v", table, err)
+		}
+	}
+
+	// Repeat until nothing is left to drop: removing a comment can orphan
+	// the replies to it
+	var removed int64
+	for {
+		var affected int64
+		for _, key := range keys {
+			result, err := tx.ExecContext(ctx, fmt.Sprintf(
+				"DELETE FROM %s WHERE %s IS NOT NULL AND %s NOT IN (SELECT id FROM %s)",
+				table, key.Column, key.Column, key.Parent,
+			))
+			if err != nil {
+				return fmt.Errorf("failed to remove orphaned rows from %s: %v", table, err)
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			affected += n
+		}
+		if affected == 0 {
+			break
+		}
+		removed += affected
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to rebuild %s: %v", table, err)
+	}
+	log.Printf("Rebuilt %s with cascading foreign keys, removing %d orphaned rows", table, removed)
+	return nil
+}
+
+// parentKeys lists the table's foreign keys on posts and comments
+func parentKeys(table string) ([]foreignKey, error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA foreign_key_list(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect foreign keys of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	var keys []foreignKey
+	for rows.Next() {
+		var (
+			id, seq                   int
+			parent, from              string
+			to                        sql.NullString
+			onUpdate, onDelete, match string
+		)
+		if err := rows.Scan(&id, &seq, &parent, &from, &to, &onUpdate, &onDelete, &match); err != nil {
+			return nil, fmt.Errorf("failed to scan foreign key info: %v", err)
+		}
+		if parent == "posts" || parent == "comments" {
+			keys = append(keys, foreignKey{Column: from, Parent: parent, OnDelete: strings.ToUpper(onDelete)})
+		}
+	}
+	return keys, rows.Err()
+}
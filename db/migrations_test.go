@@ -0,0 +1,99 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// TestEnsureCascade upgrades tables written before their keys cascaded
+func TestEnsureCascade(t *testing.T) {
+	var err error
+	DB, err = sql.Open("sqlite3", withForeignKeys(filepath.Join(t.TempDir(), "forum.db")))
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer DB.Close()
+
+	_, err = DB.Exec(`
+		PRAGMA foreign_keys = OFF;
+		CREATE TABLE posts (id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT NOT NULL);
+		CREATE TABLE comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			post_id INTEGER NOT NULL,
+			parent_id INTEGER DEFAULT NULL,
+			content TEXT NOT NULL,
+			FOREIGN KEY (post_id) REFERENCES posts(id),
+			FOREIGN KEY (parent_id) REFERENCES comments(id)
+		);
+		CREATE TABLE comment_reactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			comment_id INTEGER NOT NULL,
+			FOREIGN KEY (comment_id) REFERENCES comments(id)
+		);
+		CREATE TABLE post_categories (post_id INTEGER NOT NULL, category_id INTEGER NOT NULL, FOREIGN KEY (post_id) REFERENCES posts(id));
+		CREATE TABLE post_reactions (post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE NO ACTION);
+		CREATE TABLE post_revisions (post_id INTEGER NOT NULL, FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE);
+		ALTER TABLE comments ADD COLUMN edited INTEGER NOT NULL DEFAULT 0;
+
+		INSERT INTO posts (id, title) VALUES (1, 'Kept'), (2, 'Deleted later');
+		-- Comment 3 belongs to a post deleted before foreign keys were enforced,
+		-- and comment 4 replies to it
+		INSERT INTO comments (id, post_id, parent_id, content) VALUES
+			(1, 1, NULL, 'On the kept post'), (2, 2, NULL, 'On the other post'),
+			(3, 9, NULL, 'Orphan'), (4, 9, 3, 'Reply to the orphan'), (5, 2, 2, 'Reply');
+		INSERT INTO comment_reactions (comment_id) VALUES (1), (4);
+		INSERT INTO post_categories (post_id, category_id) VALUES (1, 1), (2, 1), (9, 1);
+		INSERT INTO post_reactions (post_id) VALUES (2);
+		INSERT INTO post_revisions (post_id) VALUES (2);
+		PRAGMA foreign_keys = ON;
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create old tables: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		for _, table := range cascadeTables {
+			if err := ensureCascade(table); err != nil {
+				t.Fatalf("ensureCascade(%s) returned error: %v", table, err)
+			}
+		}
+	}
+	for _, table := range cascadeTables {
+		keys, err := parentKeys(table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			if key.OnDelete != "CASCADE" {
+				t.Errorf("%s.%s still does %s on delete", table, key.Column, key.OnDelete)
+			}
+		}
+	}
+	if exists, _ := columnExists("comments", "edited"); !exists {
+		t.Error("Rebuilding comments lost a column added by a migration")
+	}
+
+	// Orphans are gone, and deleting a post takes everything under it along
+	if _, err := DB.Exec("DELETE FROM posts WHERE id = 2"); err != nil {
+		t.Fatalf("Failed to delete post: %v", err)
+	}
+	counts := map[string]int{
+		"SELECT COUNT(*) FROM comments":          1,
+		"SELECT COUNT(*) FROM comment_reactions": 1,
+		"SELECT COUNT(*) FROM post_categories":   1,
+		"SELECT COUNT(*) FROM post_reactions":    0,
+		"SELECT COUNT(*) FROM post_revisions":    0,
+	}
+	for query, want := range counts {
+		var got int
+		if err := DB.QueryRow(query).Scan(&got); err != nil || got != want {
+			t.Errorf("%s: expected %d, got %d (%v)", query, want, got, err)
+		}
+	}
+
+	// And new rows must belong to a post that exists
+	if _, err := DB.Exec("INSERT INTO post_categories (post_id, category_id) VALUES (42, 1)"); err == nil {
+		t.Error("Expected foreign keys to be enforced")
+	}
+}
@@ -4,10 +4,19 @@ CREATE TABLE IF NOT EXISTS users (
     email TEXT UNIQUE NOT NULL,                
     username TEXT UNIQUE NOT NULL,             
     password TEXT NOT NULL,              
+    email_verified INTEGER NOT NULL DEFAULT 0,
+    role TEXT NOT NULL DEFAULT 'member',
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP 
 );
 
--- POSTS Table
+-- Logins look users up by email or username regardless of case
+CREATE INDEX IF NOT EXISTS idx_users_email_nocase ON users(email COLLATE NOCASE);
+CREATE INDEX IF NOT EXISTS idx_users_username_nocase ON users(username COLLATE NOCASE);
+
+-- POSTS Table (deleted_at is set while a post is in the trash; it is
+-- erased for good once the trash retention period has passed). publish_at
+-- is set while a post waits to be published; only its author can see it
+-- until then.
 CREATE TABLE IF NOT EXISTS posts (
     id INTEGER PRIMARY KEY AUTOINCREMENT,     
     user_id INTEGER NOT NULL,                 
@@ -15,10 +24,18 @@ CREATE TABLE IF NOT EXISTS posts (
     content TEXT NOT NULL, 
     image TEXT DEFAULT NULL,                   
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT NULL,
+    updated_by INTEGER DEFAULT NULL,
+    deleted_at DATETIME DEFAULT NULL,
+    deleted_by INTEGER DEFAULT NULL,
+    publish_at DATETIME DEFAULT NULL,
     FOREIGN KEY (user_id) REFERENCES users(id) 
 );
 
--- COMMENTS Table
+-- COMMENTS Table (deleting a post deletes its comments, and deleting a
+-- comment deletes its replies and reactions). Comments in the trash have
+-- deleted_at set; ones purged while replies still hang off them keep their
+-- row with the content blanked.
 CREATE TABLE IF NOT EXISTS comments (
     id INTEGER PRIMARY KEY AUTOINCREMENT,     
     post_id INTEGER NOT NULL,                 
@@ -26,9 +43,11 @@ CREATE TABLE IF NOT EXISTS comments (
     parent_id INTEGER DEFAULT NULL,                
     content TEXT NOT NULL,                    
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (post_id) REFERENCES posts(id),
+    deleted_at DATETIME DEFAULT NULL,
+    deleted_by INTEGER DEFAULT NULL,
+    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
     FOREIGN KEY (user_id) REFERENCES users(id)
-    FOREIGN KEY (parent_id) REFERENCES comments(id)
+    FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE
 );
 
 -- CATEGORIES Table
@@ -43,7 +62,7 @@ CREATE TABLE IF NOT EXISTS post_categories (
     post_id INTEGER NOT NULL,                  
     category_id INTEGER NOT NULL,              
     PRIMARY KEY (post_id, category_id),
-    FOREIGN KEY (post_id) REFERENCES posts(id),
+    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
     FOREIGN KEY (category_id) REFERENCES categories(id)
 );
 
@@ -53,7 +72,7 @@ CREATE TABLE IF NOT EXISTS post_reactions (
     post_id INTEGER NOT NULL,                 
     user_id INTEGER NOT NULL,                 
     reaction_type TEXT CHECK (reaction_type IN ('LIKE', 'DISLIKE')) NOT NULL,
-    FOREIGN KEY (post_id) REFERENCES posts(id),
+    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
     FOREIGN KEY (user_id) REFERENCES users(id),
     UNIQUE (post_id, user_id) 
 );
@@ -64,15 +83,225 @@ CREATE TABLE IF NOT EXISTS comment_reactions (
     comment_id INTEGER NOT NULL,              
     user_id INTEGER NOT NULL,                 
     reaction_type TEXT CHECK (reaction_type IN ('LIKE', 'DISLIKE')) NOT NULL,
-    FOREIGN KEY (comment_id) REFERENCES comments(id),
+    FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
     FOREIGN KEY (user_id) REFERENCES users(id) 
     UNIQUE (comment_id, user_id)
 );
 
 -- SESSIONS Table
--- CREATE TABLE IF NOT EXISTS sessions (
---     uuid TEXT PRIMARY KEY,                    
---     user_id INTEGER NOT NULL,                 
---     expires_at DATETIME NOT NULL,             
---     FOREIGN KEY (user_id) REFERENCES users(id)
--- );
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    ip_address TEXT,
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    last_activity DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    csrf_token TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+
+-- AUTH_TOKENS Table (single-use tokens such as password resets, stored hashed)
+CREATE TABLE IF NOT EXISTS auth_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    purpose TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME DEFAULT NULL,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_tokens_user ON auth_tokens(user_id, purpose);
+
+-- USER_TOTP Table (authenticator app secrets; enabled once the user confirms a code)
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    enabled INTEGER NOT NULL DEFAULT 0,
+    last_used_step INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- RECOVERY_CODES Table (one-time 2FA backup codes, stored hashed)
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at DATETIME DEFAULT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);
+
+-- LOGIN_CHALLENGES Table (password checked, waiting for the second factor)
+CREATE TABLE IF NOT EXISTS login_challenges (
+    id_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    remember INTEGER NOT NULL DEFAULT 0,
+    redirect TEXT NOT NULL DEFAULT '',
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- "Remember me" tokens. The cookie holds selector:validator; only a hash of
+-- the validator is kept. previous_hash stays valid briefly after each
+-- rotation so parallel requests from the same browser don't trip theft detection.
+CREATE TABLE IF NOT EXISTS remember_tokens (
+    selector TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    session_id TEXT NOT NULL DEFAULT '',
+    validator_hash TEXT NOT NULL,
+    previous_hash TEXT NOT NULL DEFAULT '',
+    rotated_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_remember_tokens_user ON remember_tokens(user_id);
+
+-- LOGIN_ATTEMPTS Table (recent failed logins, counted per account and per IP)
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    scope TEXT NOT NULL,
+    key TEXT NOT NULL,
+    attempted_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_key ON login_attempts(scope, key, attempted_at);
+
+-- LOGIN_LOCKOUTS Table (record of every temporary lockout, kept for admins)
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    scope TEXT NOT NULL,
+    key TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    locked_at DATETIME NOT NULL,
+    locked_until DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_lockouts_key ON login_lockouts(scope, key, locked_until);
+
+-- USER_IDENTITIES Table (external logins linked to an account, one per provider)
+CREATE TABLE IF NOT EXISTS user_identities (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- API_TOKENS Table (personal access tokens for scripts, stored hashed;
+-- scopes is a space-separated list)
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    scopes TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    last_used_at DATETIME DEFAULT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id);
+
+-- ROLES, PERMISSIONS and ROLE_PERMISSIONS Tables. users.role names a role;
+-- a role's rank orders it against the others for RequireRole.
+CREATE TABLE IF NOT EXISTS roles (
+    name TEXT PRIMARY KEY,
+    rank INTEGER UNIQUE NOT NULL,
+    description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    name TEXT PRIMARY KEY,
+    description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role TEXT NOT NULL,
+    permission TEXT NOT NULL,
+    PRIMARY KEY (role, permission),
+    FOREIGN KEY (role) REFERENCES roles(name),
+    FOREIGN KEY (permission) REFERENCES permissions(name)
+);
+
+-- INVITES Table (invite codes for registration.mode "invite", stored hashed;
+-- a code works until it has been used max_uses times or expires)
+CREATE TABLE IF NOT EXISTS invites (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code_hash TEXT UNIQUE NOT NULL,
+    created_by INTEGER NOT NULL,
+    max_uses INTEGER NOT NULL,
+    uses INTEGER NOT NULL DEFAULT 0,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+-- AUTH_EVENTS Table (audit log of signups, logins, failures, logouts,
+-- password changes and session revocations; user_id is NULL for failed
+-- logins that named no account)
+CREATE TABLE IF NOT EXISTS auth_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    event TEXT NOT NULL,
+    method TEXT NOT NULL DEFAULT '',
+    provider TEXT NOT NULL DEFAULT '',
+    identifier TEXT NOT NULL DEFAULT '',
+    detail TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_events_user ON auth_events(user_id);
+
+-- Earlier versions of edited posts. Each edit saves the version it replaces,
+-- numbered from 1 for each post; the posts row always holds the latest.
+-- categories is a JSON array of category names.
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL,
+    revision INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    content TEXT NOT NULL,
+    image TEXT DEFAULT NULL,
+    categories TEXT NOT NULL DEFAULT '[]',
+    edited_by INTEGER NOT NULL,
+    created_at DATETIME NOT NULL,
+    UNIQUE (post_id, revision),
+    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    FOREIGN KEY (edited_by) REFERENCES users(id)
+);
+
+-- Unpublished posts, saved as the author writes so nothing is lost on
+-- navigation. Drafts are free-form until published, when they go through
+-- the same checks as a new post. categories is a JSON array of category ids.
+-- Only drafts made from a cancelled scheduled post have an image.
+CREATE TABLE IF NOT EXISTS drafts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    content TEXT NOT NULL DEFAULT '',
+    image TEXT DEFAULT NULL,
+    categories TEXT NOT NULL DEFAULT '[]',
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_drafts_user ON drafts(user_id);
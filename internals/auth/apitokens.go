@@ -0,0 +1,358 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/db"
+	"forum/internals/fails"
+)
+
+// Scopes an API token can be given. A read token can only make GET requests;
+// a write token can also post, comment and react.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+const (
+	// apiTokenPrefix marks the forum's tokens so they are easy to recognise
+	// in scripts and secret scanners
+	apiTokenPrefix = "forum_"
+	// maxAPITokens is how many tokens one user can hold at a time
+	maxAPITokens = 20
+	// apiTokenTouchInterval is how stale last_used_at may get before a
+	// request updates it, so busy scripts don't write on every call
+	apiTokenTouchInterval = time.Minute
+)
+
+var errTooManyAPITokens = errors.New("too many API tokens")
+
+// APIToken is a personal access token as listed to its owner. Only a hash of
+// the token itself is stored, so it can't be shown again after creation.
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// HasScope reports whether the token was granted the scope. Write implies read.
+func (token *APIToken) HasScope(scope string) bool {
+	for _, s := range token.Scopes {
+		if s == scope || (s == ScopeWrite && scope == ScopeRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScopes checks the requested scopes and returns them without duplicates
+func parseScopes(requested []string) ([]string, bool) {
+	var scopes []string
+	seen := map[string]bool{}
+	for _, scope := range requested {
+		scope = strings.TrimSpace(scope)
+		if scope != ScopeRead && scope != ScopeWrite {
+			return nil, false
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, len(scopes) > 0
+}
+
+// createAPIToken stores a new token for the user and returns it along with
+// the secret to hand out
+func createAPIToken(userID int, name string, scopes []string) (*APIToken, string, error) {
+	secret, _, err := generateToken()
+	if err != nil {
+		return nil, "", err
+	}
+	secret = apiTokenPrefix + secret
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM api_tokens WHERE user_id = ?", userID).Scan(&count); err != nil {
+		return nil, "", err
+	}
+	if count >= maxAPITokens {
+		return nil, "", errTooManyAPITokens
+	}
+
+	token := &APIToken{UserID: userID, Name: name, Scopes: scopes, CreatedAt: time.Now().UTC()}
+	result, err := tx.Exec(
+		"INSERT INTO api_tokens (user_id, name, token_hash, scopes, created_at) VALUES (?, ?, ?, ?, ?)",
+		userID, name, hashToken(secret), strings.Join(scopes, " "), token.CreatedAt,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, "", err
+	}
+	token.ID = int(id)
+
+	return token, secret, tx.Commit()
+}
+
+// listAPITokens returns the user's tokens, newest first
+func listAPITokens(userID int) ([]APIToken, error) {
+	rows, err := db.DB.Query(
+		"SELECT id, user_id, name, scopes, created_at, last_used_at FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC, id DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var token APIToken
+		var scopes string
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&token.ID, &token.UserID, &token.Name, &scopes, &token.CreatedAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		token.Scopes = strings.Fields(scopes)
+		if lastUsed.Valid {
+			token.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// revokeAPIToken deletes one of the user's tokens and reports whether it existed
+func revokeAPIToken(userID, tokenID int) (bool, error) {
+	result, err := db.DB.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", tokenID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// lookupAPIToken finds the token for a secret sent by a client and records
+// that it was used
+func lookupAPIToken(secret string) (*APIToken, error) {
+	if !strings.HasPrefix(secret, apiTokenPrefix) {
+		return nil, errInvalidToken
+	}
+
+	var token APIToken
+	var scopes string
+	var lastUsed sql.NullTime
+	err := db.DB.QueryRow(
+		"SELECT id, user_id, name, scopes, created_at, last_used_at FROM api_tokens WHERE token_hash = ?",
+		hashToken(secret),
+	).Scan(&token.ID, &token.UserID, &token.Name, &scopes, &token.CreatedAt, &lastUsed)
+	if err == sql.ErrNoRows {
+		return nil, errInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Fields(scopes)
+
+	now := time.Now().UTC()
+	if !lastUsed.Valid || now.Sub(lastUsed.Time) >= apiTokenTouchInterval {
+		if _, err := db.DB.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, token.ID); err != nil {
+			log.Printf("Error recording API token use: %v", err)
+		}
+		lastUsed = sql.NullTime{Time: now, Valid: true}
+	}
+	token.LastUsedAt = &lastUsed.Time
+	return &token, nil
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// tokenSession authenticates a request carrying an API token. The session it
+// returns only lives for the request; handlers read it from the context just
+// like a browser session.
+func tokenSession(w http.ResponseWriter, r *http.Request, secret string) (*Session, bool) {
+	token, err := lookupAPIToken(secret)
+	if err != nil {
+		if err != errInvalidToken {
+			log.Printf("Error looking up API token: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to check API token")
+			return nil, false
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		fails.JSONError(w, http.StatusUnauthorized, "Invalid API token")
+		return nil, false
+	}
+
+	user, err := userRepo.GetByID(token.UserID)
+	if err != nil {
+		log.Printf("Error loading user for API token: %v", err)
+		fails.JSONError(w, http.StatusUnauthorized, "Invalid API token")
+		return nil, false
+	}
+
+	scope := ScopeWrite
+	if csrfSafeMethod(r.Method) {
+		scope = ScopeRead
+	}
+	if !token.HasScope(scope) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+		fails.JSONError(w, http.StatusForbidden, "This API token lacks the "+scope+" scope")
+		return nil, false
+	}
+
+	return &Session{
+		UserID:       user.ID,
+		UserName:     user.UserName,
+		Role:         user.Role,
+		CreatedAt:    token.CreatedAt,
+		IPAddress:    clientIP(r),
+		UserAgent:    r.UserAgent(),
+		LastActivity: *token.LastUsedAt,
+		APIToken:     token,
+	}, true
+}
+
+// RequireBrowserSession keeps API tokens away from routes that manage the
+// account itself, such as creating more tokens or turning off 2FA. It must
+// be wrapped by Middleware.
+func RequireBrowserSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := r.Context().Value(UserSessionKey).(*Session)
+		if ok && session != nil && session.APIToken != nil {
+			fails.JSONError(w, http.StatusForbidden, "API tokens can't be used for this")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ListAPITokens returns the signed in user's API tokens as JSON
+func ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	tokens, err := listAPITokens(session.UserID)
+	if err != nil {
+		log.Printf("Error listing API tokens: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to list API tokens")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateAPIToken issues a named token with the requested scopes. The token is
+// in the response and is never shown again.
+func CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		fails.JSONError(w, http.StatusBadRequest, "Invalid form data")
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len(name) > 64 {
+		fails.JSONError(w, http.StatusBadRequest, "Give the token a name of up to 64 characters")
+		return
+	}
+	scopes, valid := parseScopes(r.Form["scopes"])
+	if !valid {
+		fails.JSONError(w, http.StatusBadRequest, "Choose the read or write scope")
+		return
+	}
+
+	token, secret, err := createAPIToken(session.UserID, name, scopes)
+	if err == errTooManyAPITokens {
+		fails.JSONError(w, http.StatusConflict, "You have too many API tokens. Revoke one you no longer use first.")
+		return
+	} else if err != nil {
+		log.Printf("Error creating API token: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to create API token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":     secret,
+		"api_token": token,
+	})
+}
+
+// RevokeAPIToken deletes one of the user's API tokens. Scripts using it are
+// refused from their next request.
+func RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	tokenID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		fails.JSONError(w, http.StatusBadRequest, "Missing token id")
+		return
+	}
+
+	found, err := revokeAPIToken(session.UserID, tokenID)
+	if err != nil {
+		log.Printf("Error revoking API token: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to revoke API token")
+		return
+	}
+	if !found {
+		fails.JSONError(w, http.StatusNotFound, "API token not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "revoked"})
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPITokenMiddleware(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+
+	_, readSecret, err := createAPIToken(1, "reader", []string{ScopeRead})
+	if err != nil {
+		t.Fatalf("createAPIToken returned error: %v", err)
+	}
+	_, writeSecret, err := createAPIToken(1, "writer", []string{ScopeWrite})
+	if err != nil {
+		t.Fatalf("createAPIToken returned error: %v", err)
+	}
+	if !strings.HasPrefix(readSecret, apiTokenPrefix) {
+		t.Errorf("Expected token to start with %q, got %q", apiTokenPrefix, readSecret)
+	}
+
+	var stored int
+	testDB.QueryRow(`SELECT COUNT(*) FROM api_tokens WHERE token_hash = ?`, readSecret).Scan(&stored)
+	if stored != 0 {
+		t.Error("Token should be stored hashed")
+	}
+
+	var seen *Session
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value(UserSessionKey).(*Session)
+		if CheckIfLoggedIn(w, r) != seen {
+			t.Error("CheckIfLoggedIn should return the context session")
+		}
+	}))
+
+	tests := []struct {
+		name     string
+		method   string
+		header   string
+		wantCode int
+	}{
+		{"Read token can GET", "GET", "Bearer " + readSecret, http.StatusOK},
+		{"Read token can't POST", "POST", "Bearer " + readSecret, http.StatusForbidden},
+		{"Write token can POST without CSRF token", "POST", "Bearer " + writeSecret, http.StatusOK},
+		{"Write token can GET", "GET", "bearer " + writeSecret, http.StatusOK},
+		{"Unknown token", "GET", "Bearer forum_nope", http.StatusUnauthorized},
+		{"No token redirects to login", "GET", "", http.StatusFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			req := httptest.NewRequest(tt.method, "/create-post", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("Expected %d, got %d", tt.wantCode, rec.Code)
+			}
+			if tt.wantCode == http.StatusOK && (seen == nil || seen.UserID != 1 || seen.UserName != "testuser") {
+				t.Errorf("Expected the token owner's session in the context, got %+v", seen)
+			}
+		})
+	}
+
+	tokens, err := listAPITokens(1)
+	if err != nil {
+		t.Fatalf("listAPITokens returned error: %v", err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("Expected 2 tokens, got %d", len(tokens))
+	}
+	for _, token := range tokens {
+		if token.LastUsedAt == nil {
+			t.Errorf("Expected last use of %q to be recorded", token.Name)
+		}
+	}
+}
+
+func TestRevokeAPIToken(t *testing.T) {
+	setupAuthTestDB(t)
+	token, secret, err := createAPIToken(1, "script", []string{ScopeRead, ScopeWrite, ScopeRead})
+	if err != nil {
+		t.Fatalf("createAPIToken returned error: %v", err)
+	}
+
+	// Another user can't revoke it
+	if found, _ := revokeAPIToken(2, token.ID); found {
+		t.Error("Another user's token should not be revocable")
+	}
+	if found, err := revokeAPIToken(1, token.ID); err != nil || !found {
+		t.Fatalf("Expected token to be revoked, got %v, %v", found, err)
+	}
+	if _, err := lookupAPIToken(secret); err != errInvalidToken {
+		t.Errorf("Expected revoked token to be rejected, got %v", err)
+	}
+}
+
+func TestRequireBrowserSession(t *testing.T) {
+	setupAuthTestDB(t)
+	_, secret, err := createAPIToken(1, "script", []string{ScopeWrite})
+	if err != nil {
+		t.Fatalf("createAPIToken returned error: %v", err)
+	}
+	session := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+
+	reached := false
+	handler := Middleware(RequireBrowserSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	})))
+
+	req := httptest.NewRequest("POST", "/account/tokens/create", strings.NewReader("name=more&scopes=write"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+secret)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if reached || rec.Code != http.StatusForbidden {
+		t.Errorf("API token should be refused, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/account/tokens", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: session.ID.String()})
+	handler(httptest.NewRecorder(), req)
+	if !reached {
+		t.Error("Browser session should be let through")
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	if scopes, ok := parseScopes([]string{"read", "write", "read"}); !ok || len(scopes) != 2 {
+		t.Errorf("Expected read and write, got %v, %v", scopes, ok)
+	}
+	if _, ok := parseScopes([]string{"admin"}); ok {
+		t.Error("Unknown scope should be rejected")
+	}
+	if _, ok := parseScopes(nil); ok {
+		t.Error("At least one scope should be required")
+	}
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/db"
+	"forum/internals/fails"
+)
+
+// Events recorded in the auth_events audit log
+const (
+	eventSignup            = "signup"
+	eventLogin             = "login"
+	eventLoginFailed       = "login_failed"
+	eventLogout            = "logout"
+	eventPasswordChanged   = "password_changed"
+	eventSessionRevoked    = "session_revoked"
+	eventTwoFactorEnabled  = "2fa_enabled"
+	eventTwoFactorDisabled = "2fa_disabled"
+)
+
+// How a user signed up or logged in. OAuth events also name the provider.
+const (
+	methodPassword   = "password"
+	methodMagicLink  = "magic_link"
+	methodRememberMe = "remember_me"
+	methodTwoFactor  = "2fa"
+	methodOAuth      = "oauth"
+)
+
+const (
+	// maxAuditIdentifier keeps what strangers type into the login form from
+	// filling the log
+	maxAuditIdentifier = 100
+	// recentActivityLimit is how many events the security page shows
+	recentActivityLimit = 20
+	// maxAuthEventsPage caps how many events one admin query returns
+	maxAuthEventsPage = 500
+)
+
+// AuthEvent is one entry in the audit log. UserID is zero for failed logins
+// that named no account; Identifier then holds what was typed.
+type AuthEvent struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id,omitempty"`
+	Event      string    `json:"event"`
+	Method     string    `json:"method,omitempty"`
+	Provider   string    `json:"provider,omitempty"`
+	Identifier string    `json:"identifier,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// recordAuthEvent adds an event for the request to the audit log. A failure
+// is logged but never stops the request.
+func recordAuthEvent(r *http.Request, event AuthEvent) {
+	if len(event.Identifier) > maxAuditIdentifier {
+		event.Identifier = event.Identifier[:maxAuditIdentifier]
+	}
+	userID := sql.NullInt64{Int64: int64(event.UserID), Valid: event.UserID != 0}
+	_, err := db.DB.Exec(
+		`INSERT INTO auth_events (user_id, event, method, provider, identifier, detail, ip_address, user_agent, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, event.Event, event.Method, event.Provider, event.Identifier, event.Detail,
+		clientIP(r), r.UserAgent(), time.Now().UTC(),
+	)
+	if err != nil {
+		log.Printf("Error recording %s auth event: %v", event.Event, err)
+	}
+}
+
+// userIDOf is the user's id, or zero when no account matched
+func userIDOf(user *User) int {
+	if user == nil {
+		return 0
+	}
+	return user.ID
+}
+
+// Description is a short sentence for the security page
+func (e AuthEvent) Description() string {
+	how := ""
+	switch e.Method {
+	case methodPassword:
+		how = " with a password"
+	case methodMagicLink:
+		how = " with an emailed link"
+	case methodRememberMe:
+		how = " on a remembered device"
+	case methodTwoFactor:
+		how = " with a two-factor code"
+	case methodOAuth:
+		how = " with " + providerDisplayName(e.Provider)
+	}
+
+	switch e.Event {
+	case eventSignup:
+		return "Signed up" + how
+	case eventLogin:
+		return "Logged in" + how
+	case eventLoginFailed:
+		return "Failed login attempt" + how
+	case eventLogout:
+		return "Logged out"
+	case eventPasswordChanged:
+		return "Password changed"
+	case eventSessionRevoked:
+		switch e.Detail {
+		case "all_devices":
+			return "Signed out every device"
+		case "remember_me_reused":
+			return "Signed out every device after a copied login cookie was used"
+		}
+		return "Signed out a device"
+	case eventTwoFactorEnabled:
+		return "Turned on two-factor authentication"
+	case eventTwoFactorDisabled:
+		return "Turned off two-factor authentication"
+	}
+	return e.Event
+}
+
+// Device is the browser and OS the event came from
+func (e AuthEvent) Device() string {
+	return describeUserAgent(e.UserAgent)
+}
+
+// providerDisplayName names a provider the way its login button does
+func providerDisplayName(name string) string {
+	if provider, ok := lookupProvider(name); ok {
+		return provider.DisplayName()
+	}
+	return name
+}
+
+// authEventFilter narrows an audit log query. Zero fields match everything;
+// BeforeID pages back from the oldest event already seen.
+type authEventFilter struct {
+	UserID   int
+	Event    string
+	Provider string
+	IP       string
+	Since    time.Time
+	Until    time.Time
+	BeforeID int
+	Limit    int
+}
+
+// queryAuthEvents returns the events matching the filter, newest first
+func queryAuthEvents(filter authEventFilter) ([]AuthEvent, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if filter.UserID != 0 {
+		add("user_id = ?", filter.UserID)
+	}
+	if filter.Event != "" {
+		add("event = ?", filter.Event)
+	}
+	if filter.Provider != "" {
+		add("provider = ?", filter.Provider)
+	}
+	if filter.IP != "" {
+		add("ip_address = ?", filter.IP)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= ?", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < ?", filter.Until.UTC())
+	}
+	if filter.BeforeID != 0 {
+		add("id < ?", filter.BeforeID)
+	}
+
+	query := `SELECT id, user_id, event, method, provider, identifier, detail, ip_address, user_agent, created_at
+		FROM auth_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuthEvent{}
+	for rows.Next() {
+		var event AuthEvent
+		var userID sql.NullInt64
+		if err := rows.Scan(&event.ID, &userID, &event.Event, &event.Method, &event.Provider, &event.Identifier,
+			&event.Detail, &event.IPAddress, &event.UserAgent, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.UserID = int(userID.Int64)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// recentAuthEvents returns the user's latest security activity
+func recentAuthEvents(userID int) ([]AuthEvent, error) {
+	return queryAuthEvents(authEventFilter{UserID: userID, Limit: recentActivityLimit})
+}
+
+// parseAuthEventFilter reads a filter from the query string. Times are
+// RFC 3339, such as 2024-05-01T00:00:00Z.
+func parseAuthEventFilter(query url.Values) (authEventFilter, error) {
+	get := func(name string) string { return strings.TrimSpace(query.Get(name)) }
+	filter := authEventFilter{
+		Event:    get("event"),
+		Provider: get("provider"),
+		IP:       get("ip"),
+		Limit:    100,
+	}
+
+	if username := get("user"); username != "" {
+		user, err := userRepo.GetByUsername(username)
+		if err == sql.ErrNoRows {
+			return filter, fmt.Errorf("unknown user %q", username)
+		} else if err != nil {
+			return filter, err
+		}
+		filter.UserID = user.ID
+	}
+
+	ints := map[string]*int{"user_id": &filter.UserID, "before": &filter.BeforeID, "limit": &filter.Limit}
+	for name, field := range ints {
+		if value := get(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				return filter, fmt.Errorf("%s must be a positive number", name)
+			}
+			*field = parsed
+		}
+	}
+	if filter.Limit > maxAuthEventsPage {
+		filter.Limit = maxAuthEventsPage
+	}
+
+	times := map[string]*time.Time{"since": &filter.Since, "until": &filter.Until}
+	for name, field := range times {
+		if value := get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time such as 2024-05-01T00:00:00Z", name)
+			}
+			*field = parsed
+		}
+	}
+	return filter, nil
+}
+
+// ListAuthEvents returns audit log entries as JSON for admins, filtered by
+// user (a username), user_id, event, provider, ip, since and until. Results
+// are newest first; pass the last id as before to get the next page.
+func ListAuthEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	filter, err := parseAuthEventFilter(r.URL.Query())
+	if err != nil {
+		fails.JSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	events, err := queryAuthEvents(filter)
+	if err != nil {
+		log.Printf("Error querying auth events: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to query auth events")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
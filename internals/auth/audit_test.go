@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoginAuditEvents(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	hasher := Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1}
+	usePasswordHasher(t, hasher)
+	hashed, _ := hasher.Hash("password123")
+	testDB.Exec(`UPDATE users SET password = ? WHERE id = 1`, hashed)
+
+	// Login renders templates relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir("internals/auth")
+
+	postForm(Login, "/login", url.Values{"identifier": {"nobody"}, "password": {"password123"}})
+	postForm(Login, "/login", url.Values{"identifier": {"testuser"}, "password": {"wrong"}})
+	postForm(Login, "/login", url.Values{"identifier": {"test1@example.com"}, "password": {"password123"}})
+
+	events, err := queryAuthEvents(authEventFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("queryAuthEvents returned error: %v", err)
+	}
+	want := []AuthEvent{
+		{UserID: 1, Event: eventLogin, Method: methodPassword},
+		{UserID: 1, Event: eventLoginFailed, Method: methodPassword, Identifier: "testuser", Detail: "wrong_password"},
+		{UserID: 0, Event: eventLoginFailed, Method: methodPassword, Identifier: "nobody", Detail: "unknown_account"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Expected %d events, got %d: %+v", len(want), len(events), events)
+	}
+	for i, w := range want {
+		got := events[i]
+		if got.UserID != w.UserID || got.Event != w.Event || got.Method != w.Method || got.Identifier != w.Identifier || got.Detail != w.Detail {
+			t.Errorf("Event %d: expected %+v, got %+v", i, w, got)
+		}
+		if got.IPAddress == "" || got.CreatedAt.IsZero() {
+			t.Errorf("Event %d is missing its IP address or time: %+v", i, got)
+		}
+	}
+}
+
+func TestQueryAuthEvents(t *testing.T) {
+	setupAuthTestDB(t)
+
+	request := func(ip string) *http.Request {
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.RemoteAddr = ip + ":1234"
+		return req
+	}
+	recordAuthEvent(request("10.0.0.1"), AuthEvent{UserID: 1, Event: eventLogin, Method: methodOAuth, Provider: "github"})
+	recordAuthEvent(request("10.0.0.2"), AuthEvent{UserID: 2, Event: eventLogin, Method: methodPassword})
+	recordAuthEvent(request("10.0.0.2"), AuthEvent{UserID: 1, Event: eventLogout})
+
+	tests := []struct {
+		name   string
+		query  string
+		wantN  int
+		wantOK bool
+	}{
+		{"Everything", "", 3, true},
+		{"By username", "user=testuser", 2, true},
+		{"By user and event", "user_id=1&event=login", 1, true},
+		{"By provider", "provider=github", 1, true},
+		{"By IP", "ip=10.0.0.2", 2, true},
+		{"Paging", "before=3&limit=1", 1, true},
+		{"Future", "since=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)), 0, true},
+		{"Unknown user", "user=nobody", 0, false},
+		{"Bad time", "since=yesterday", 0, false},
+		{"Bad limit", "limit=-1", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ListAuthEvents(rec, httptest.NewRequest("GET", "/admin/auth-events?"+tt.query, nil))
+			if !tt.wantOK {
+				if rec.Code != http.StatusBadRequest {
+					t.Errorf("Expected status 400, got %d", rec.Code)
+				}
+				return
+			}
+
+			var events []AuthEvent
+			if err := json.NewDecoder(rec.Body).Decode(&events); err != nil {
+				t.Fatalf("Expected a JSON list, got %d: %v", rec.Code, err)
+			}
+			if len(events) != tt.wantN {
+				t.Errorf("Expected %d events, got %d", tt.wantN, len(events))
+			}
+		})
+	}
+}
+
+func TestAuthEventDescription(t *testing.T) {
+	tests := []struct {
+		event AuthEvent
+		want  string
+	}{
+		{AuthEvent{Event: eventLogin, Method: methodPassword}, "Logged in with a password"},
+		{AuthEvent{Event: eventSignup, Method: methodOAuth, Provider: "acme"}, "Signed up with acme"},
+		{AuthEvent{Event: eventLoginFailed, Method: methodTwoFactor}, "Failed login attempt with a two-factor code"},
+		{AuthEvent{Event: eventSessionRevoked, Detail: "device: Firefox on Linux"}, "Signed out a device"},
+		{AuthEvent{Event: eventSessionRevoked, Detail: "all_devices"}, "Signed out every device"},
+		{AuthEvent{Event: "something_new"}, "something_new"},
+	}
+	for _, tt := range tests {
+		if got := tt.event.Description(); got != tt.want {
+			t.Errorf("Description() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -2,10 +2,12 @@ package auth
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,93 +23,127 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	// Check if user is already logged in
 	session := CheckIfLoggedIn(w, r)
 	if session != nil {
-		http.Redirect(w, r, "/", http.StatusFound)
+		http.Redirect(w, r, afterLogin(r), http.StatusFound)
 		return
 	}
 
 	if r.Method == http.MethodPost {
 		identifier := r.FormValue("identifier") // can either be username or email
 		password := r.FormValue("password")
-		ipAddress := r.RemoteAddr
-		users := ReadfromDb()
+		remember := rememberEnabled() && r.FormValue("remember") != ""
+		redirect := afterLogin(r)
 
-		if users == nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to read users"})
+		foundUser, err := userRepo.GetByIdentifier(identifier)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Error looking up user: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to log in")
 			return
 		}
 
-		// First check if the identifier exists
-		var foundUser *User
-		isEmail := strings.Contains(identifier, "@")
-
-		for _, user := range users {
-			if isEmail {
-				if user.Email == identifier {
-					foundUser = &user
-					break
-				}
-			} else {
-				if user.UserName == identifier {
-					foundUser = &user
-					break
-				}
-			}
+		ip := clientIP(r)
+		accountKey := accountLockoutKey(foundUser, identifier)
+
+		until, locked, err := loginLockedUntil(accountKey, ip)
+		if err != nil {
+			log.Printf("Error checking login lockout: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to log in")
+			return
+		}
+		if locked {
+			recordAuthEvent(r, AuthEvent{UserID: userIDOf(foundUser), Event: eventLoginFailed, Method: methodPassword, Identifier: identifier, Detail: "locked_out"})
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Too many failed login attempts. Please try again later."})
+			return
 		}
 
-		// If user not found, pop specific error
-		if foundUser == nil {
+		// Unknown users and wrong passwords get the same answer, and a password
+		// hash check either way, so neither the message nor the timing tells them apart
+		hashedPassword := dummyPasswordHash()
+		if foundUser != nil {
+			hashedPassword = foundUser.Password
+		}
+		match, rehash := checkPassword(hashedPassword, password)
+		if !match || foundUser == nil {
+			if err := recordFailedLogin(accountKey, ip); err != nil {
+				log.Printf("Error recording failed login: %v", err)
+			}
+			detail := "wrong_password"
+			if foundUser == nil {
+				detail = "unknown_account"
+			}
+			recordAuthEvent(r, AuthEvent{UserID: userIDOf(foundUser), Event: eventLoginFailed, Method: methodPassword, Identifier: identifier, Detail: detail})
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
-			if isEmail {
-				json.NewEncoder(w).Encode(map[string]string{"error": "Email not found"})
-			} else {
-				json.NewEncoder(w).Encode(map[string]string{"error": "Username not found"})
-			}
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid username/email or password"})
 			return
 		}
 
-		// Check password if user is found
-		if !decryptPassword(foundUser.Password, password) {
+		// The password is only known now, so this is the moment to move a hash
+		// made by an older scheme or with weaker parameters to the current one
+		if rehash {
+			if err := rehashPassword(foundUser, password); err != nil {
+				log.Printf("Error upgrading password hash: %v", err)
+			}
+		}
+
+		// Accounts with 2FA get a short-lived challenge instead of a session.
+		// Their failed login count is only cleared once the code is accepted.
+		twoFactor, err := isTwoFactorEnabled(foundUser.ID)
+		if err != nil {
+			log.Printf("Error checking 2FA status: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to log in")
+			return
+		}
+		if twoFactor {
+			if err := startLoginChallenge(w, foundUser.ID, remember, redirect); err != nil {
+				log.Printf("Error starting login challenge: %v", err)
+				fails.JSONError(w, http.StatusInternalServerError, "Failed to log in")
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Incorrect password"})
+			json.NewEncoder(w).Encode(map[string]string{"status": "2fa_required"})
 			return
 		}
 
 		// At this point, both identifier and password are correct
-		if oldsession, ok := store.GetSessionByUserId(foundUser.ID); ok {
-			store.DeleteSession(oldsession.ID)
+		if err := clearFailedLogins(accountKey); err != nil {
+			log.Printf("Error clearing failed logins: %v", err)
 		}
-
-		session := store.CreateSession(foundUser.ID, foundUser.UserName, ipAddress)
+		session := startSession(w, r, foundUser.ID, foundUser.UserName, remember)
 		if session == nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create session"})
 			return
 		}
-
-		// Set session cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "session",
-			Value:    session.ID.String(),
-			Path:     "/",
-			HttpOnly: true,
-			MaxAge:   86400, // 24 hours
-		})
+		recordAuthEvent(r, AuthEvent{UserID: foundUser.ID, Event: eventLogin, Method: methodPassword})
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{
 			"status":   "success",
 			"username": foundUser.UserName,
+			"redirect": redirect,
 		})
 		return
 
 	} else if r.Method == http.MethodGet {
-		if err := tmpl.ExecuteTemplate(w, "login.html", nil); err != nil {
+		data := struct {
+			Providers  loginProviders
+			Notice     string
+			RememberMe bool
+			MagicLink  bool
+			Next       string
+		}{
+			Providers:  enabledProviders(),
+			Notice:     loginNotices[r.URL.Query().Get("notice")],
+			RememberMe: rememberEnabled(),
+			MagicLink:  magicLinkSettings.Enabled,
+			Next:       localRedirect(r.URL.Query().Get("next")),
+		}
+		if err := tmpl.ExecuteTemplate(w, "login.html", data); err != nil {
 			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
 			return
 		}
@@ -117,17 +153,88 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// startSession creates a new session for the user alongside any sessions they
+// already have on other devices, and sets the session cookie. With remember
+// set, the browser also gets a long-lived token that can start new sessions.
+func startSession(w http.ResponseWriter, r *http.Request, userID int, username string, remember bool) *Session {
+	// Never carry on a session ID the browser already had, in case it was
+	// planted there before login
+	if cookie, err := r.Cookie("session"); err == nil {
+		if oldID, err := uuid.FromString(cookie.Value); err == nil {
+			store.DeleteSession(oldID)
+		}
+	}
+
+	session := store.CreateSession(userID, username, clientIP(r), r.UserAgent())
+	if session == nil {
+		return nil
+	}
+
+	setSessionCookie(w, session)
+	if remember {
+		if err := issueRememberToken(w, userID, session.ID); err != nil {
+			log.Printf("Error issuing remember-me token: %v", err)
+		}
+	}
+	return session
+}
+
+// setSessionCookie sets the session cookie. It lasts until the browser is
+// closed; the server enforces the idle timeout and absolute lifetime.
+func setSessionCookie(w http.ResponseWriter, session *Session) {
+	http.SetCookie(w, newCookie("session", session.ID.String(), "/", 0))
+}
+
+// rotateSession gives the current session a new ID and CSRF token after a
+// privilege change. The new CSRF token is sent in the X-CSRF-Token response
+// header so the page can keep using it.
+func rotateSession(w http.ResponseWriter, session *Session) *Session {
+	rotated, err := store.RotateSession(session)
+	if err != nil {
+		log.Printf("Error rotating session: %v", err)
+		return session
+	}
+	setSessionCookie(w, rotated)
+	w.Header().Set(csrfHeader, rotated.CSRFToken)
+	return rotated
+}
+
+// clearSessionCookie removes the session cookie from the browser
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, newCookie("session", "", "/", -1))
+}
+
+// Middleware lets only signed in users through, adding their session to the
+// request context. Scripts can sign in with an "Authorization: Bearer" API
+// token instead of a session cookie.
 func Middleware(next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if secret, ok := bearerToken(r); ok {
+			// No cookie is involved, so there is no CSRF token to check
+			session, ok := tokenSession(w, r, secret)
+			if !ok {
+				return
+			}
+			ctx := context.WithValue(r.Context(), UserSessionKey, session)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		session := CheckIfLoggedIn(w, r)
 
 		if session == nil {
-			http.Redirect(w, r, "/login", http.StatusFound)
+			http.Redirect(w, r, loginRedirect(r), http.StatusFound)
+			return
+		}
+
+		// Anything that changes state must carry the session's CSRF token
+		if !csrfSafeMethod(r.Method) && !validCSRFToken(r, session) {
+			rejectCSRF(w, r)
 			return
 		}
 
 		// Extend session
-		store.ExtendSession(session.ID)
+		store.ExtendSession(session)
 
 		// Add session data to the request context
 		ctx := context.WithValue(r.Context(), UserSessionKey, session)
@@ -135,7 +242,28 @@ func Middleware(next http.Handler) http.HandlerFunc {
 	})
 }
 
+// loginRedirect is the login page for a request that needs a session. Pages
+// the user opened come back to the same URL after logging in; a script's
+// fetch or a form post can't be repeated, so those start from the home page.
+func loginRedirect(r *http.Request) string {
+	if r.Method != http.MethodGet || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return "/login"
+	}
+	return loginURL(r.URL.RequestURI())
+}
+
+// Logout ends the current session. It only accepts POST, behind Middleware,
+// so that other sites can't log users out.
 func Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if session, ok := r.Context().Value(UserSessionKey).(*Session); ok && session != nil {
+		recordAuthEvent(r, AuthEvent{UserID: session.UserID, Event: eventLogout})
+	}
+
 	// Get session ID from cookie
 	cookie, err := r.Cookie("session")
 	if err == nil {
@@ -145,14 +273,9 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Clear the session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1,
-		HttpOnly: true,
-	})
+	// Clear the session cookie and stop the browser being remembered
+	clearSessionCookie(w)
+	forgetRememberToken(w, r)
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -160,7 +283,18 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 func Signup(w http.ResponseWriter, r *http.Request) {
 	tmpl := template.Must(template.ParseGlob("templates/*.html"))
 	if r.Method == http.MethodGet {
-		err := tmpl.ExecuteTemplate(w, "signup.html", nil)
+		data := struct {
+			Providers      loginProviders
+			Mode           string
+			AllowedDomains []string
+			Invite         string
+		}{
+			Providers:      enabledProviders(),
+			Mode:           registrationSettings.Mode,
+			AllowedDomains: registrationSettings.AllowedDomains,
+			Invite:         r.URL.Query().Get("invite"),
+		}
+		err := tmpl.ExecuteTemplate(w, "signup.html", data)
 		if err != nil {
 			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
 			return
@@ -182,10 +316,8 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 			errors = append(errors, "Username must be 3-30 characters long and contain only letters, numbers, underscores, or hyphens")
 		}
 
-		// Validate password (example requirements)
-		if len(password) < 8 {
-			errors = append(errors, "Password must be at least 8 characters long")
-		}
+		// Validate password against the password policy
+		errors = append(errors, policy.Check(password, name, email)...)
 
 		// If there are any validation errors, return them
 		if len(errors) > 0 {
@@ -199,7 +331,7 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		hashedPassword, err := encryptPassword(password)
+		hashedPassword, err := hashPassword(password)
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
@@ -208,12 +340,41 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		var user User
-		user.Email = email
-		user.Password = string(hashedPassword)
-		user.UserName = name
+		taken, err := userRepo.Exists(email, name)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+			return
+		}
 
-		err = SaveUserToDb(user)
+		user := User{
+			Email:    email,
+			Password: string(hashedPassword),
+			UserName: name,
+		}
+		if taken {
+			err = errUserExists
+		} else {
+			// Checked last, so an invalid form doesn't use up an invite
+			var inviteID int
+			inviteID, err = admitRegistration(email, strings.TrimSpace(r.FormValue("invite")))
+			if message, refused := registrationErrors[err]; refused {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{"error": message})
+				return
+			} else if err != nil {
+				log.Printf("Error checking registration: %v", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+				return
+			}
+			if err = userRepo.Create(&user); err != nil {
+				releaseInvite(inviteID)
+			}
+		}
 		if err != nil {
 			// If there's an error (likely user already exists)
 			w.Header().Set("Content-Type", "application/json")
@@ -222,12 +383,20 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		recordAuthEvent(r, AuthEvent{UserID: user.ID, Event: eventSignup, Method: methodPassword})
+
+		// The account works right away, but posting waits until the email is confirmed
+		if err := sendVerificationEmail(&user); err != nil {
+			log.Printf("Error sending verification email: %v", err)
+		}
+
 		// Success case
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{
 			"status":   "success",
 			"username": user.UserName,
+			"message":  "Account created. Check your email for a link to verify your address.",
 		})
 	}
 }
@@ -276,20 +445,28 @@ func SaveUserToDb(user User) error {
 	return nil
 }
 
+// CheckIfLoggedIn returns the request's session. A browser whose session has
+// ended but that was remembered is signed back in with a new one. Behind
+// Middleware the session is already in the context, which is the only place
+// a request authenticated with an API token has one.
 func CheckIfLoggedIn(w http.ResponseWriter, r *http.Request) *Session {
+	if session, ok := r.Context().Value(UserSessionKey).(*Session); ok && session != nil {
+		return session
+	}
+
 	cookie, err := r.Cookie("session")
 	if err != nil {
-		return nil
+		return resumeRememberedSession(w, r)
 	}
 
 	sessionID, err := uuid.FromString(cookie.Value)
 	if err != nil {
-		return nil
+		return resumeRememberedSession(w, r)
 	}
 
 	session, valid := store.GetSession(sessionID)
 	if !valid {
-		return nil
+		return resumeRememberedSession(w, r)
 	}
 
 	return session
@@ -2,6 +2,11 @@ package auth
 
 import (
 	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"testing"
 	"time"
 
@@ -148,3 +153,83 @@ func TestSaveUserToDb(t *testing.T) {
 		t.Error("Expected error when saving duplicate email, got nil")
 	}
 }
+
+func TestMiddlewareLoginRedirect(t *testing.T) {
+	setupAuthTestDB(t)
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		accept string
+		want   string
+	}{
+		{"Page", "GET", "/create-post-form?category=2", "text/html,application/xhtml+xml", "/login?next=%2Fcreate-post-form%3Fcategory%3D2"},
+		{"Script fetch", "GET", "/userfilter", "*/*", "/login"},
+		{"Form post", "POST", "/post/react", "text/html", "/login"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if got := rec.Header().Get("Location"); rec.Code != http.StatusFound || got != tt.want {
+				t.Errorf("Expected a redirect to %q, got %d %q", tt.want, rec.Code, got)
+			}
+		})
+	}
+}
+
+func TestLoginRedirect(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	hasher := Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1}
+	usePasswordHasher(t, hasher)
+	hashed, _ := hasher.Hash("password123")
+	testDB.Exec(`UPDATE users SET password = ? WHERE id IN (1, 2)`, hashed)
+	secret := enableTestTOTP(t, 2)
+
+	// Login renders templates relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir("internals/auth")
+
+	redirectOf := func(rec *httptest.ResponseRecorder) string {
+		var data map[string]string
+		json.NewDecoder(rec.Body).Decode(&data)
+		return data["redirect"]
+	}
+
+	tests := []struct {
+		name string
+		next string
+		want string
+	}{
+		{"Local page", "/view-post?id=4", "/view-post?id=4"},
+		{"No page", "", "/"},
+		{"Other site", "https://evil.example/", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postForm(Login, "/login", url.Values{"identifier": {"testuser"}, "password": {"password123"}, "next": {tt.next}})
+			if got := redirectOf(rec); rec.Code != http.StatusOK || got != tt.want {
+				t.Errorf("Expected password login to redirect to %q, got %d %q", tt.want, rec.Code, got)
+			}
+
+			// With 2FA the target waits in the challenge for the code step
+			rec = postForm(Login, "/login", url.Values{"identifier": {"testuser2"}, "password": {"password123"}, "next": {tt.next}})
+			cookie := responseCookie(rec, loginChallengeCookie)
+			if cookie == nil {
+				t.Fatalf("Expected a login challenge, got %d: %s", rec.Code, rec.Body.String())
+			}
+			rec = postTwoFactorCode(cookie, currentTOTPCode(secret))
+			if got := redirectOf(rec); rec.Code != http.StatusOK || got != tt.want {
+				t.Errorf("Expected the code step to redirect to %q, got %d %q", tt.want, rec.Code, got)
+			}
+			// Each code works once, so let the next case use it again
+			testDB.Exec(`UPDATE user_totp SET last_used_step = 0`)
+		})
+	}
+}
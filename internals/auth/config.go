@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+
+	"forum/internals/config"
+)
+
+// cookieSettings are the attributes shared by every cookie the auth package sets
+var cookieSettings = config.Default().Cookie
+
+// sessionSettings control session lifetimes and the remember-me option
+var sessionSettings = config.Default().Session
+
+// magicLinkSettings control logging in with an emailed link
+var magicLinkSettings = config.Default().MagicLink
+
+// registrationSettings decide who can create an account
+var registrationSettings = config.Default().Registration
+
+// loginProviders says which OAuth buttons the login and signup pages show.
+// The built-in providers have their own buttons; the rest are in Others.
+type loginProviders struct {
+	Google   bool
+	GitHub   bool
+	Facebook bool
+	Others   []providerButton
+}
+
+type providerButton struct {
+	Name        string
+	DisplayName string
+}
+
+// Configure applies the server configuration to the auth package. It must be
+// called before the server starts handling requests.
+func Configure(cfg *config.Config) {
+	baseURL = cfg.BaseURL
+	cookieSettings = cfg.Cookie
+	sessionSettings = cfg.Session
+	magicLinkSettings = cfg.MagicLink
+	registrationSettings = cfg.Registration
+	SetVerificationPolicy(VerificationPolicy{
+		RequireForPosts:    cfg.Verification.RequireForPosts,
+		RequireForComments: cfg.Verification.RequireForComments,
+	})
+	configurePasswords(cfg.Password)
+
+	resetProviders()
+	if p := cfg.OAuth.Google; p.Enabled() {
+		RegisterProvider(newGoogleProvider(p))
+	}
+	if p := cfg.OAuth.GitHub; p.Enabled() {
+		RegisterProvider(newGitHubProvider(p))
+	}
+	if p := cfg.OAuth.Facebook; p.Enabled() {
+		RegisterProvider(newFacebookProvider(p))
+	}
+	for _, p := range cfg.OAuth.Providers {
+		if !p.Enabled() {
+			continue
+		}
+		// An unreachable issuer shouldn't keep the forum from starting; its
+		// button is left out until the next restart
+		provider, err := newConfiguredProvider(p)
+		if err != nil {
+			log.Printf("Disabling OAuth provider %s: %v", p.Name, err)
+			continue
+		}
+		RegisterProvider(provider)
+	}
+}
+
+// enabledProviders reports which OAuth providers are registered
+func enabledProviders() loginProviders {
+	var enabled loginProviders
+	for _, p := range registeredProviders() {
+		switch p.Name() {
+		case "google":
+			enabled.Google = true
+		case "github":
+			enabled.GitHub = true
+		case "facebook":
+			enabled.Facebook = true
+		default:
+			enabled.Others = append(enabled.Others, providerButton{Name: p.Name(), DisplayName: p.DisplayName()})
+		}
+	}
+	return enabled
+}
+
+// newCookie builds a cookie with the configured Secure, SameSite and Domain
+// attributes. A negative maxAge deletes the cookie.
+func newCookie(name, value, path string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   cookieSettings.Domain,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   cookieSettings.Secure,
+		SameSite: cookieSettings.SameSiteMode(),
+	}
+}
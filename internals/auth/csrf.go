@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"mime"
+	"net/http"
+
+	"forum/internals/fails"
+)
+
+// Every session has its own CSRF token. Pages put it in a
+// <meta name="csrf-token"> tag, static/js/csrf.js sends it with fetch calls
+// in the X-CSRF-Token header, and plain HTML forms send it as a hidden
+// csrf_token field.
+const (
+	csrfHeader = "X-CSRF-Token"
+	csrfField  = "csrf_token"
+)
+
+func generateCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// csrfSafeMethod reports whether requests with the method only read data
+func csrfSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// isFormPost reports whether the request body is a urlencoded HTML form
+func isFormPost(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded"
+}
+
+// validCSRFToken checks the token sent with the request against the
+// session's. The form field is only read from urlencoded bodies so that
+// multipart uploads are left for the handler to parse with its own limits.
+func validCSRFToken(r *http.Request, session *Session) bool {
+	token := r.Header.Get(csrfHeader)
+	if token == "" && isFormPost(r) {
+		token = r.PostFormValue(csrfField)
+	}
+	if token == "" || session.CSRFToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1
+}
+
+// rejectCSRF answers a request without a valid token: HTML forms get the
+// error page, fetch calls get a JSON error they can show
+func rejectCSRF(w http.ResponseWriter, r *http.Request) {
+	if isFormPost(r) && r.Header.Get(csrfHeader) == "" {
+		fails.ErrorPageHandler(w, r, http.StatusForbidden)
+		return
+	}
+	fails.JSONError(w, http.StatusForbidden, "Your session has changed. Refresh the page and try again")
+}
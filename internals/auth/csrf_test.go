@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareCSRF(t *testing.T) {
+	setupAuthTestDB(t)
+	session := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+	if session.CSRFToken == "" {
+		t.Fatal("New sessions should have a CSRF token")
+	}
+	if stored, _ := store.GetSession(session.ID); stored.CSRFToken != session.CSRFToken {
+		t.Fatal("CSRF token was not stored with the session")
+	}
+
+	reached := false
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		header      string
+		body        string
+		wantReached bool
+		wantJSON    bool
+	}{
+		{"GET needs no token", "GET", "", "", "", true, false},
+		{"Header token", "POST", "application/json", session.CSRFToken, `{}`, true, false},
+		{"Form field token", "POST", "application/x-www-form-urlencoded", "", "csrf_token=" + session.CSRFToken, true, false},
+		{"Missing token from fetch", "POST", "application/json", "", `{}`, false, true},
+		{"Wrong header token", "POST", "application/x-www-form-urlencoded", "wrong", "", false, true},
+		{"Missing token from form", "POST", "application/x-www-form-urlencoded", "", "title=hi", false, false},
+		{"Multipart body field is ignored", "POST", "multipart/form-data; boundary=x", "", "--x\r\nContent-Disposition: form-data; name=\"csrf_token\"\r\n\r\n" + session.CSRFToken + "\r\n--x--\r\n", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = false
+			req := httptest.NewRequest(tt.method, "/create-post", strings.NewReader(tt.body))
+			req.AddCookie(&http.Cookie{Name: "session", Value: session.ID.String()})
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.header != "" {
+				req.Header.Set(csrfHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if reached != tt.wantReached {
+				t.Fatalf("Handler reached = %v, want %v", reached, tt.wantReached)
+			}
+			if tt.wantReached {
+				return
+			}
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("Expected 403, got %d", rec.Code)
+			}
+			isJSON := strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json")
+			if isJSON != tt.wantJSON {
+				t.Errorf("JSON response = %v, want %v", isJSON, tt.wantJSON)
+			}
+		})
+	}
+}
+
+func TestLogoutRequiresPOST(t *testing.T) {
+	setupAuthTestDB(t)
+	session := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+	handler := Middleware(http.HandlerFunc(Logout))
+
+	logout := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/logout", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "session", Value: session.ID.String()})
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := logout("GET", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET /logout to be refused, got %d", rec.Code)
+	}
+	if rec := logout("POST", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a POST without a token to be refused, got %d", rec.Code)
+	}
+	if _, ok := store.GetSession(session.ID); !ok {
+		t.Fatal("Session should survive refused logouts")
+	}
+
+	if rec := logout("POST", "csrf_token="+session.CSRFToken); rec.Code != http.StatusSeeOther {
+		t.Errorf("Expected a redirect after logout, got %d", rec.Code)
+	}
+	if _, ok := store.GetSession(session.ID); ok {
+		t.Error("Session should be deleted after logout")
+	}
+}
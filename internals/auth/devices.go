@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/internals/fails"
+)
+
+// sessionInfo is how a session is shown on the devices page and its JSON endpoint
+type sessionInfo struct {
+	ID           string    `json:"id"`
+	Device       string    `json:"device"`
+	IPAddress    string    `json:"ip_address"`
+	UserAgent    string    `json:"user_agent"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActivity time.Time `json:"last_activity"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Current      bool      `json:"current"`
+}
+
+// userSessionInfo lists the signed in user's sessions, marking the current one
+func userSessionInfo(current *Session) ([]sessionInfo, error) {
+	sessions, err := store.ListUserSessions(current.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]sessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, sessionInfo{
+			ID:           session.Handle(),
+			Device:       describeUserAgent(session.UserAgent),
+			IPAddress:    session.IPAddress,
+			UserAgent:    session.UserAgent,
+			CreatedAt:    session.CreatedAt,
+			LastActivity: session.LastActivity,
+			ExpiresAt:    session.ExpiresAt,
+			Current:      session.ID == current.ID,
+		})
+	}
+	return infos, nil
+}
+
+// ServeDevices renders the "my devices" page
+func ServeDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.ErrorPageHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := userSessionInfo(session)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		PageData PageData
+		Sessions []sessionInfo
+	}{
+		PageData: PageData{IsLoggedIn: true, UserName: session.UserName, CSRFToken: session.CSRFToken, Role: session.Role},
+		Sessions: sessions,
+	}
+
+	tmpl, err := template.ParseFiles("templates/devices.html")
+	if err != nil {
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Println("Template execution error:", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// ListSessions returns the signed in user's sessions as JSON
+func ListSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	sessions, err := userSessionInfo(session)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to list sessions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession signs out one of the user's sessions, identified by its handle
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	var input struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.ID == "" {
+		fails.JSONError(w, http.StatusBadRequest, "Missing session id")
+		return
+	}
+
+	sessions, err := store.ListUserSessions(session.UserID)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	// Only sessions owned by the current user can be matched
+	var target *Session
+	for i := range sessions {
+		if sessions[i].Handle() == input.ID {
+			target = &sessions[i]
+			break
+		}
+	}
+	if target == nil {
+		fails.JSONError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	store.DeleteSession(target.ID)
+	if err := forgetSessionRememberTokens(target.ID); err != nil {
+		log.Printf("Error deleting remember-me token: %v", err)
+	}
+	recordAuthEvent(r, AuthEvent{UserID: session.UserID, Event: eventSessionRevoked, Detail: "device: " + describeUserAgent(target.UserAgent)})
+	current := target.ID == session.ID
+	if current {
+		clearSessionCookie(w)
+		clearRememberCookie(w)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "revoked",
+		"current": current,
+	})
+}
+
+// RevokeAllSessions signs the user out on every device, including this one
+func RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	if err := store.DeleteUserSessions(session.UserID); err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+	recordAuthEvent(r, AuthEvent{UserID: session.UserID, Event: eventSessionRevoked, Detail: "all_devices"})
+	clearSessionCookie(w)
+	clearRememberCookie(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "revoked"})
+}
+
+// describeUserAgent turns a User-Agent header into a short "Browser on OS" label
+func describeUserAgent(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	case strings.Contains(userAgent, "curl/"):
+		browser = "curl"
+	}
+
+	os := ""
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		os = "Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		os = "iOS"
+	case strings.Contains(userAgent, "Windows"):
+		os = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		os = "Linux"
+	}
+
+	if os == "" {
+		return browser
+	}
+	return browser + " on " + os
+}
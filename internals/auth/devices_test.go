@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRevokeSession(t *testing.T) {
+	setupAuthTestDB(t)
+
+	current := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+	phone := store.CreateSession(1, "testuser", "127.0.0.3", "test-agent")
+	other := store.CreateSession(2, "testuser2", "127.0.0.2", "test-agent")
+	if current == nil || phone == nil || other == nil {
+		t.Fatal("Expected sessions to be created")
+	}
+
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+		revoked        *Session
+	}{
+		{"Revoke another device", phone.Handle(), http.StatusOK, phone},
+		{"Cannot revoke another user's session", other.Handle(), http.StatusNotFound, nil},
+		{"Unknown session", "doesnotexist", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"id": tt.id})
+			req := httptest.NewRequest(http.MethodPost, "/account/devices/revoke", bytes.NewReader(body))
+			req = req.WithContext(context.WithValue(req.Context(), UserSessionKey, current))
+			rec := httptest.NewRecorder()
+
+			RevokeSession(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if tt.revoked != nil {
+				if _, ok := store.GetSession(tt.revoked.ID); ok {
+					t.Error("Expected session to be revoked")
+				}
+			}
+		})
+	}
+
+	if _, ok := store.GetSession(other.ID); !ok {
+		t.Error("Another user's session should survive")
+	}
+	if _, ok := store.GetSession(current.ID); !ok {
+		t.Error("Current session should survive revoking another device")
+	}
+}
+
+func TestDescribeUserAgent(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36", "Chrome on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Version/17.0 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0", "Firefox on Linux"},
+		{"", "Unknown device"},
+	}
+
+	for _, tt := range tests {
+		if got := describeUserAgent(tt.userAgent); got != tt.want {
+			t.Errorf("describeUserAgent(%q) = %q, want %q", tt.userAgent, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"forum/db"
+	"forum/internals/fails"
+)
+
+// Identity is an external login linked to a forum account
+type Identity struct {
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
+
+var (
+	// errIdentityTaken is returned when the external account already belongs
+	// to another forum account
+	errIdentityTaken = errors.New("identity is linked to another account")
+	// errProviderLinked is returned when the account already has a login
+	// from the same provider
+	errProviderLinked = errors.New("account already has a login from this provider")
+	// errLastLoginMethod is returned when unlinking would leave the account
+	// with no way to log in
+	errLastLoginMethod = errors.New("cannot remove the only way to log in")
+	// errLinkRequired is returned when an OAuth login matches an existing
+	// account by email but it isn't safe to merge them automatically
+	errLinkRequired = errors.New("account must be linked from its settings")
+)
+
+// findIdentityUser returns the id of the account the external login is
+// linked to, or sql.ErrNoRows
+func findIdentityUser(provider, subject string) (int, error) {
+	var userID int
+	err := db.DB.QueryRow(
+		"SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?",
+		provider, subject,
+	).Scan(&userID)
+	return userID, err
+}
+
+// linkIdentity attaches the external login to the account
+func linkIdentity(userID int, provider string, external *ExternalUser) error {
+	owner, err := findIdentityUser(provider, external.Subject)
+	if err == nil {
+		if owner == userID {
+			return nil
+		}
+		return errIdentityTaken
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	var linked bool
+	if err := db.DB.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM user_identities WHERE user_id = ? AND provider = ?)",
+		userID, provider,
+	).Scan(&linked); err != nil {
+		return err
+	}
+	if linked {
+		return errProviderLinked
+	}
+
+	_, err = db.DB.Exec(
+		"INSERT INTO user_identities (user_id, provider, subject, email, created_at) VALUES (?, ?, ?, ?, ?)",
+		userID, provider, external.Subject, external.Email, time.Now().UTC(),
+	)
+	return err
+}
+
+// userIdentities lists the external logins linked to the account
+func userIdentities(userID int) ([]Identity, error) {
+	rows, err := db.DB.Query(
+		"SELECT provider, subject, email, created_at FROM user_identities WHERE user_id = ? ORDER BY created_at",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []Identity
+	for rows.Next() {
+		var identity Identity
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// unlinkIdentity removes the provider's login from the account, as long as
+// the account keeps a password or another linked login
+func unlinkIdentity(userID int, provider string) error {
+	user, err := userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	var others int
+	if err := db.DB.QueryRow(
+		"SELECT COUNT(*) FROM user_identities WHERE user_id = ? AND provider != ?",
+		userID, provider,
+	).Scan(&others); err != nil {
+		return err
+	}
+	if user.Password == "" && others == 0 {
+		return errLastLoginMethod
+	}
+
+	result, err := db.DB.Exec("DELETE FROM user_identities WHERE user_id = ? AND provider = ?", userID, provider)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// loginExternalUser finds the forum account for the provider's user. A
+// linked identity always wins. Otherwise the account with the same email is
+// linked automatically, but only when both sides have verified the address
+// and the account doesn't use two-factor authentication, which an OAuth
+// login would skip. With no matching account, a new one is created if the
+// registration mode allows it; invite is the code the signup page passed on.
+// created reports whether a new account was made.
+func loginExternalUser(provider string, external *ExternalUser, invite string) (*User, bool, error) {
+	userID, err := findIdentityUser(provider, external.Subject)
+	if err == nil {
+		user, err := userRepo.GetByID(userID)
+		return user, false, err
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
+
+	if external.Email == "" {
+		return nil, false, errors.New("provider did not return an email address")
+	}
+	if !external.EmailVerified {
+		return nil, false, errUnverifiedExternalEmail
+	}
+
+	created := false
+	user, err := userRepo.GetByEmail(external.Email)
+	switch {
+	case err == sql.ErrNoRows:
+		username, err := availableUsername(usernameFromProfile(external))
+		if err != nil {
+			return nil, false, err
+		}
+		inviteID, err := admitRegistration(external.Email, invite)
+		if err != nil {
+			return nil, false, err
+		}
+		user = &User{
+			Email:    external.Email,
+			UserName: username,
+			Password: "", // provider-authenticated users don't need a password
+		}
+		if err := userRepo.Create(user); err != nil {
+			releaseInvite(inviteID)
+			return nil, false, err
+		}
+		created = true
+		// The provider has already confirmed the address
+		if err := markEmailVerified(user.ID); err != nil {
+			log.Printf("Error marking email verified: %v", err)
+		}
+
+	case err != nil:
+		return nil, false, err
+
+	default:
+		twoFactor, err := isTwoFactorEnabled(user.ID)
+		if err != nil {
+			return nil, false, err
+		}
+		if !IsEmailVerified(user.ID) || twoFactor {
+			return nil, false, errLinkRequired
+		}
+	}
+
+	if err := linkIdentity(user.ID, provider, external); err != nil {
+		if errors.Is(err, errProviderLinked) {
+			// The account already has another login from this provider
+			return nil, false, errLinkRequired
+		}
+		return nil, false, err
+	}
+	return user, created, nil
+}
+
+// finishLink attaches the provider's account to the logged-in user who
+// started the flow from the security page
+func finishLink(w http.ResponseWriter, r *http.Request, provider Provider, linkUserID int, external *ExternalUser) {
+	session := CheckIfLoggedIn(w, r)
+	if session == nil || session.UserID != linkUserID {
+		fails.ErrorPageHandler(w, r, http.StatusForbidden)
+		return
+	}
+
+	result := "linked"
+	if err := linkIdentity(session.UserID, provider.Name(), external); err != nil {
+		switch {
+		case errors.Is(err, errIdentityTaken):
+			result = "taken"
+		case errors.Is(err, errProviderLinked):
+			result = "already-linked"
+		default:
+			log.Printf("Error linking %s login: %v", provider.Name(), err)
+			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+	http.Redirect(w, r, "/account/security?identity="+result, http.StatusSeeOther)
+}
+
+// identityNotices are the messages the security page shows after linking
+var identityNotices = map[string]struct{ Text, Type string }{
+	"linked":         {"Login linked. You can now use it to sign in.", "success"},
+	"taken":          {"That login is already linked to another forum account.", "error"},
+	"already-linked": {"Your account already has a login from that provider. Unlink it first.", "error"},
+}
+
+// loginNotices are the messages the login page shows when an OAuth login
+// is sent back to it
+var loginNotices = map[string]string{
+	"link-required":       "An account with this email already exists. Log in with your password, then link this login from the Security page.",
+	"registration-closed": "There is no account for this login, and new accounts can't be created right now.",
+	"invite-required":     "There is no account for this login. Open the signup page from your invite link to create one.",
+	"domain-not-allowed":  "There is no account for this login, and new accounts need an email address from an allowed domain.",
+}
+
+// identityRow is one provider on the security page
+type identityRow struct {
+	Provider    string
+	DisplayName string
+	Email       string
+	Linked      bool
+}
+
+// identityRows lists every configured provider with the user's linked login,
+// plus linked logins for providers that are no longer configured
+func identityRows(userID int) ([]identityRow, error) {
+	identities, err := userIdentities(userID)
+	if err != nil {
+		return nil, err
+	}
+	linked := make(map[string]Identity, len(identities))
+	for _, identity := range identities {
+		linked[identity.Provider] = identity
+	}
+
+	var rows []identityRow
+	for _, p := range registeredProviders() {
+		identity, ok := linked[p.Name()]
+		rows = append(rows, identityRow{Provider: p.Name(), DisplayName: p.DisplayName(), Email: identity.Email, Linked: ok})
+		delete(linked, p.Name())
+	}
+	for _, identity := range identities {
+		if _, ok := linked[identity.Provider]; ok {
+			rows = append(rows, identityRow{Provider: identity.Provider, DisplayName: identity.Provider, Email: identity.Email, Linked: true})
+		}
+	}
+	return rows, nil
+}
+
+// LinkIdentity starts an OAuth flow that links the provider's account to
+// the logged-in user instead of logging in
+func LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.ErrorPageHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	provider, ok := lookupProvider(r.FormValue("provider"))
+	if !ok {
+		fails.ErrorPageHandler(w, r, http.StatusNotFound)
+		return
+	}
+
+	consentURL, err := beginOAuthFlow(w, r, provider, oauthState{LinkUserID: session.UserID})
+	if err != nil {
+		log.Printf("Error starting %s link: %v", provider.Name(), err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, consentURL, http.StatusSeeOther)
+}
+
+// UnlinkIdentity removes a linked login from the current account
+func UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	err := unlinkIdentity(session.UserID, r.FormValue("provider"))
+	switch {
+	case err == sql.ErrNoRows:
+		fails.JSONError(w, http.StatusNotFound, "That login is not linked to your account")
+		return
+	case errors.Is(err, errLastLoginMethod):
+		fails.JSONError(w, http.StatusConflict, "This is the only way to log in to your account. Set a password with \"Forgot password\" or link another login first")
+		return
+	case err != nil:
+		log.Printf("Error unlinking login: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to unlink login")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"forum/db"
+	"forum/internals/config"
+)
+
+// registerFakeProvider registers an OpenID Connect provider named "acme"
+// backed by a fake authorization server
+func registerFakeProvider(t *testing.T) *fakeAuthServer {
+	fake := newFakeAuthServer(t)
+	provider, err := newConfiguredProvider(config.Provider{Name: "acme", Issuer: fake.URL, ClientID: "client", ClientSecret: "secret"})
+	if err != nil {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+	registerTestProvider(t, provider)
+	return fake
+}
+
+func TestLoginExternalUserMerging(t *testing.T) {
+	setupAuthTestDB(t)
+	external := func(subject, email string) *ExternalUser {
+		return &ExternalUser{Subject: subject, Email: email, EmailVerified: true}
+	}
+
+	// An unverified account could have been registered by someone who
+	// doesn't own the address
+	if _, _, err := loginExternalUser("acme", external("sub-1", "test1@example.com"), ""); err != errLinkRequired {
+		t.Errorf("Expected errLinkRequired for an unverified account, got %v", err)
+	}
+
+	markEmailVerified(1)
+	user, _, err := loginExternalUser("acme", external("sub-1", "test1@example.com"), "")
+	if err != nil || user.ID != 1 {
+		t.Fatalf("Expected the verified account to be linked, got %+v, %v", user, err)
+	}
+	if owner, err := findIdentityUser("acme", "sub-1"); err != nil || owner != 1 {
+		t.Errorf("Expected an identity for user 1, got %d, %v", owner, err)
+	}
+
+	// A linked identity wins over the email the provider reports now
+	if user, _, err := loginExternalUser("acme", external("sub-1", "test2@example.com"), ""); err != nil || user.ID != 1 {
+		t.Errorf("Expected the linked account, got %+v, %v", user, err)
+	}
+
+	// A second account from the same provider can't take over the first
+	if _, _, err := loginExternalUser("acme", external("sub-other", "test1@example.com"), ""); err != errLinkRequired {
+		t.Errorf("Expected errLinkRequired for a second identity, got %v", err)
+	}
+
+	// Accounts with 2FA must be linked by their owner
+	markEmailVerified(2)
+	enableTestTOTP(t, 2)
+	if _, _, err := loginExternalUser("acme", external("sub-2", "test2@example.com"), ""); err != errLinkRequired {
+		t.Errorf("Expected errLinkRequired for an account with 2FA, got %v", err)
+	}
+}
+
+func TestLinkIdentityFlow(t *testing.T) {
+	setupAuthTestDB(t)
+	fake := registerFakeProvider(t)
+	fake.userInfo = map[string]any{"sub": "gh-42", "email": "elsewhere@example.com", "email_verified": true}
+
+	link := func(userID int, username string) *httptest.ResponseRecorder {
+		session := store.CreateSession(userID, username, "127.0.0.1", "test-agent")
+
+		req := httptest.NewRequest("POST", "/account/identities/link", strings.NewReader("provider=acme"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithValue(req.Context(), UserSessionKey, session))
+		rec := httptest.NewRecorder()
+		LinkIdentity(rec, req)
+		location, _ := url.Parse(rec.Header().Get("Location"))
+
+		req = httptest.NewRequest("GET", "/auth/acme/callback?code=good-code&state="+url.QueryEscape(location.Query().Get("state")), nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: session.ID.String()})
+		for _, cookie := range rec.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		rec = httptest.NewRecorder()
+		HandleOAuth(rec, req)
+		return rec
+	}
+
+	rec := link(1, "testuser")
+	if rec.Header().Get("Location") != "/account/security?identity=linked" {
+		t.Fatalf("Expected a successful link, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if hasSessionCookie(rec) {
+		t.Error("Linking should not start a new session")
+	}
+
+	// The provider's email differs, but the identity now logs in as user 1
+	if user, _, err := loginExternalUser("acme", &ExternalUser{Subject: "gh-42"}, ""); err != nil || user.ID != 1 {
+		t.Errorf("Expected the linked account, got %+v, %v", user, err)
+	}
+
+	if rec := link(2, "testuser2"); rec.Header().Get("Location") != "/account/security?identity=taken" {
+		t.Errorf("Expected the identity to be taken, got %q", rec.Header().Get("Location"))
+	}
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	setupAuthTestDB(t)
+	linkIdentity(1, "acme", &ExternalUser{Subject: "a"})
+
+	if err := unlinkIdentity(1, "acme"); err != nil {
+		t.Errorf("A user with a password should be able to unlink, got %v", err)
+	}
+	if err := unlinkIdentity(1, "acme"); err == nil {
+		t.Error("Expected an error unlinking a login that isn't linked")
+	}
+
+	// Without a password the last linked login has to stay
+	db.DB.Exec("UPDATE users SET password = '' WHERE id = 2")
+	linkIdentity(2, "acme", &ExternalUser{Subject: "b"})
+	linkIdentity(2, "other", &ExternalUser{Subject: "c"})
+	if err := unlinkIdentity(2, "acme"); err != nil {
+		t.Errorf("Expected to unlink while another login remains, got %v", err)
+	}
+	if err := unlinkIdentity(2, "other"); err != errLastLoginMethod {
+		t.Errorf("Expected errLastLoginMethod, got %v", err)
+	}
+}
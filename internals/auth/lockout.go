@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"forum/db"
+)
+
+// Scopes failed logins are counted under
+const (
+	lockoutScopeAccount = "account"
+	lockoutScopeIP      = "ip"
+)
+
+// loginThrottle decides when repeated failed logins lock a key out. Each
+// lockout within escalationWindow doubles the next one, up to MaxLockout.
+type loginThrottle struct {
+	Threshold   int
+	Window      time.Duration
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+}
+
+// escalationWindow is how far back earlier lockouts count towards a longer one
+const escalationWindow = 24 * time.Hour
+
+var loginThrottles = map[string]loginThrottle{
+	lockoutScopeAccount: {Threshold: 5, Window: 15 * time.Minute, BaseLockout: time.Minute, MaxLockout: time.Hour},
+	// Higher, since many people can share an address behind NAT
+	lockoutScopeIP: {Threshold: 20, Window: 15 * time.Minute, BaseLockout: time.Minute, MaxLockout: time.Hour},
+}
+
+// Lockout is a recorded temporary lockout
+type Lockout struct {
+	ID          int       `json:"id"`
+	Scope       string    `json:"scope"`
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LockedAt    time.Time `json:"locked_at"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// accountLockoutKey identifies the account a login is for. Known users are
+// keyed by id so logging in by username or email shares one counter; unknown
+// identifiers get their own so they behave the same as real accounts.
+func accountLockoutKey(user *User, identifier string) string {
+	if user != nil {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+	return "name:" + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+// lockedUntil returns when the current lockout for the key ends, if there is one
+func lockedUntil(scope, key string) (time.Time, bool, error) {
+	var until time.Time
+	err := db.DB.QueryRow(
+		`SELECT locked_until FROM login_lockouts
+		WHERE scope = ? AND key = ? AND locked_until > ?
+		ORDER BY locked_until DESC LIMIT 1`,
+		scope, key, time.Now().UTC(),
+	).Scan(&until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+	return until, true, nil
+}
+
+// loginLockedUntil checks both the account and the client address and returns
+// the later of their lockouts
+func loginLockedUntil(accountKey, ip string) (time.Time, bool, error) {
+	var latest time.Time
+	locked := false
+	for scope, key := range map[string]string{lockoutScopeAccount: accountKey, lockoutScopeIP: ip} {
+		until, ok, err := lockedUntil(scope, key)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if ok && until.After(latest) {
+			latest, locked = until, true
+		}
+	}
+	return latest, locked, nil
+}
+
+// recordFailedLogin counts a failed login against the account and the client
+// address, locking either out once it passes its threshold
+func recordFailedLogin(accountKey, ip string) error {
+	for scope, key := range map[string]string{lockoutScopeAccount: accountKey, lockoutScopeIP: ip} {
+		if err := recordFailure(scope, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recordFailure(scope, key string) error {
+	throttle := loginThrottles[scope]
+	now := time.Now().UTC()
+
+	if _, err := db.DB.Exec(
+		"INSERT INTO login_attempts (scope, key, attempted_at) VALUES (?, ?, ?)", scope, key, now,
+	); err != nil {
+		return err
+	}
+
+	var failures int
+	err := db.DB.QueryRow(
+		"SELECT COUNT(*) FROM login_attempts WHERE scope = ? AND key = ? AND attempted_at > ?",
+		scope, key, now.Add(-throttle.Window),
+	).Scan(&failures)
+	if err != nil {
+		return err
+	}
+	if failures < throttle.Threshold {
+		return nil
+	}
+
+	var previous int
+	err = db.DB.QueryRow(
+		"SELECT COUNT(*) FROM login_lockouts WHERE scope = ? AND key = ? AND locked_at > ?",
+		scope, key, now.Add(-escalationWindow),
+	).Scan(&previous)
+	if err != nil {
+		return err
+	}
+
+	duration := throttle.BaseLockout
+	for i := 0; i < previous && duration < throttle.MaxLockout; i++ {
+		duration *= 2
+	}
+	if duration > throttle.MaxLockout {
+		duration = throttle.MaxLockout
+	}
+
+	_, err = db.DB.Exec(
+		"INSERT INTO login_lockouts (scope, key, failures, locked_at, locked_until) VALUES (?, ?, ?, ?, ?)",
+		scope, key, failures, now, now.Add(duration),
+	)
+	if err == nil {
+		log.Printf("Locked out %s %s for %s after %d failed logins", scope, key, duration, failures)
+	}
+	return err
+}
+
+// clearFailedLogins resets the account's counter after a successful login.
+// The address keeps its count so one good account can't unlock guessing at others.
+func clearFailedLogins(accountKey string) error {
+	_, err := db.DB.Exec(
+		"DELETE FROM login_attempts WHERE scope = ? AND key = ?", lockoutScopeAccount, accountKey,
+	)
+	return err
+}
+
+// pruneLoginAttempts removes failed logins too old to count towards a lockout
+func pruneLoginAttempts() (int64, error) {
+	var oldest time.Duration
+	for _, throttle := range loginThrottles {
+		if throttle.Window > oldest {
+			oldest = throttle.Window
+		}
+	}
+	result, err := db.DB.Exec(
+		"DELETE FROM login_attempts WHERE attempted_at < ?", time.Now().UTC().Add(-oldest),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RecentLockouts returns the most recent lockouts, newest first
+func RecentLockouts(limit int) ([]Lockout, error) {
+	rows, err := db.DB.Query(
+		`SELECT id, scope, key, failures, locked_at, locked_until FROM login_lockouts
+		ORDER BY locked_at DESC LIMIT ?`, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lockouts []Lockout
+	for rows.Next() {
+		var lockout Lockout
+		if err := rows.Scan(&lockout.ID, &lockout.Scope, &lockout.Key, &lockout.Failures,
+			&lockout.LockedAt, &lockout.LockedUntil); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, lockout)
+	}
+	return lockouts, rows.Err()
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccountLockout(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	accountKey := accountLockoutKey(&User{ID: 1}, "")
+	threshold := loginThrottles[lockoutScopeAccount].Threshold
+
+	for i := 0; i < threshold-1; i++ {
+		if err := recordFailedLogin(accountKey, "10.0.0.1"); err != nil {
+			t.Fatalf("recordFailedLogin returned error: %v", err)
+		}
+	}
+	if _, locked, _ := loginLockedUntil(accountKey, "10.0.0.2"); locked {
+		t.Fatal("Account should not be locked below the threshold")
+	}
+
+	if err := recordFailedLogin(accountKey, "10.0.0.1"); err != nil {
+		t.Fatalf("recordFailedLogin returned error: %v", err)
+	}
+	first, locked, err := loginLockedUntil(accountKey, "10.0.0.2")
+	if err != nil || !locked {
+		t.Fatalf("Expected account to be locked from any address (%v)", err)
+	}
+
+	// Once the lockout ends, the next failure locks the account for twice as long
+	if _, err := testDB.Exec(`UPDATE login_lockouts SET locked_until = ?`, time.Now().UTC().Add(-time.Second)); err != nil {
+		t.Fatalf("Failed to expire lockout: %v", err)
+	}
+	if err := recordFailedLogin(accountKey, "10.0.0.1"); err != nil {
+		t.Fatalf("recordFailedLogin returned error: %v", err)
+	}
+	second, locked, _ := loginLockedUntil(accountKey, "10.0.0.2")
+	if !locked {
+		t.Fatal("Expected account to be locked again")
+	}
+	if second.Sub(first) < 30*time.Second {
+		t.Errorf("Expected the second lockout to be longer, first ends %v, second %v", first, second)
+	}
+
+	lockouts, err := RecentLockouts(10)
+	if err != nil {
+		t.Fatalf("RecentLockouts returned error: %v", err)
+	}
+	if len(lockouts) != 2 || lockouts[0].Key != accountKey {
+		t.Errorf("Expected 2 recorded lockouts for %s, got %+v", accountKey, lockouts)
+	}
+}
+
+func TestIPLockout(t *testing.T) {
+	setupAuthTestDB(t)
+	threshold := loginThrottles[lockoutScopeIP].Threshold
+
+	// Spread the guesses over many usernames so no single account locks
+	for i := 0; i < threshold; i++ {
+		key := accountLockoutKey(nil, "guess"+string(rune('a'+i)))
+		if err := recordFailedLogin(key, "10.0.0.9"); err != nil {
+			t.Fatalf("recordFailedLogin returned error: %v", err)
+		}
+	}
+
+	if _, locked, _ := loginLockedUntil(accountLockoutKey(&User{ID: 2}, ""), "10.0.0.9"); !locked {
+		t.Error("Expected the address to be locked for every account")
+	}
+	if _, locked, _ := loginLockedUntil(accountLockoutKey(&User{ID: 2}, ""), "10.0.0.10"); locked {
+		t.Error("Another address should not be locked")
+	}
+}
+
+func TestClearFailedLogins(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	accountKey := accountLockoutKey(nil, "  TestUser ")
+	if accountKey != accountLockoutKey(nil, "testuser") {
+		t.Error("Unknown identifiers should be compared case-insensitively")
+	}
+
+	for i := 0; i < 3; i++ {
+		recordFailedLogin(accountKey, "10.0.0.1")
+	}
+	if err := clearFailedLogins(accountKey); err != nil {
+		t.Fatalf("clearFailedLogins returned error: %v", err)
+	}
+
+	var accountRows, ipRows int
+	testDB.QueryRow(`SELECT COUNT(*) FROM login_attempts WHERE scope = 'account'`).Scan(&accountRows)
+	testDB.QueryRow(`SELECT COUNT(*) FROM login_attempts WHERE scope = 'ip'`).Scan(&ipRows)
+	if accountRows != 0 {
+		t.Errorf("Expected account failures to be cleared, got %d", accountRows)
+	}
+	if ipRows != 3 {
+		t.Errorf("Expected address failures to be kept, got %d", ipRows)
+	}
+}
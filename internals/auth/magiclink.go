@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"forum/internals/fails"
+	"forum/internals/mail"
+)
+
+// RequestMagicLink emails a single-use login link to the account with the
+// given address, so users can log in without their password
+func RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !magicLinkSettings.Enabled {
+		fails.JSONError(w, http.StatusNotFound, "Login links are turned off")
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if !isValidEmail(email) {
+		fails.JSONError(w, http.StatusBadRequest, "Invalid email format")
+		return
+	}
+
+	user, err := userRepo.GetByEmail(email)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error looking up user for login link: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	// As with password resets, the answer doesn't say whether the email is registered
+	if user != nil {
+		if err := sendMagicLinkEmail(user, localRedirect(r.FormValue("next"))); err != nil {
+			log.Printf("Error sending login link email: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "If an account exists for that email, a login link has been sent.",
+	})
+}
+
+// sendMagicLinkEmail issues a login token for the user and emails the link.
+// A redirect target rides along in the link and is checked again on use.
+func sendMagicLinkEmail(user *User, redirect string) error {
+	ttl := magicLinkSettings.TTL.Duration
+	token, err := issueToken(user.ID, tokenPurposeMagicLink, ttl)
+	if err != nil {
+		return fmt.Errorf("failed to issue login token: %v", err)
+	}
+
+	link := baseURL + "/login/magic?token=" + url.QueryEscape(token)
+	if redirect != "" {
+		link += "&next=" + url.QueryEscape(redirect)
+	}
+	return mail.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Your Forum login link",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nOpen this link within %d minutes to log in to the Forum:\n\n%s\n\n"+
+				"The link works once. If you didn't ask for it, you can ignore this email.\n",
+			user.UserName, int(ttl.Minutes()), link,
+		),
+	})
+}
+
+// MagicLinkLogin finishes a login by email. Opening the link only shows a
+// button; the token is used up by the POST it sends, so mail scanners that
+// fetch links can't log in or spend the token.
+func MagicLinkLogin(w http.ResponseWriter, r *http.Request) {
+	if !magicLinkSettings.Enabled {
+		fails.ErrorPageHandler(w, r, http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		token := r.URL.Query().Get("token")
+		_, lookupErr := lookupToken(token, tokenPurposeMagicLink)
+		if lookupErr != nil && lookupErr != errInvalidToken {
+			log.Printf("Error checking login token: %v", lookupErr)
+			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/login-magic.html")
+		if err != nil {
+			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+		data := struct {
+			Token string
+			Valid bool
+			Next  string
+		}{
+			Token: token,
+			Valid: lookupErr == nil,
+			Next:  localRedirect(r.URL.Query().Get("next")),
+		}
+		if err := tmpl.Execute(w, data); err != nil {
+			log.Println("Template execution error:", err)
+			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		userID, err := consumeToken(r.FormValue("token"), tokenPurposeMagicLink)
+		if err == errInvalidToken {
+			fails.JSONError(w, http.StatusBadRequest, "This login link is invalid or has expired")
+			return
+		} else if err != nil {
+			log.Printf("Error consuming login token: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to log in")
+			return
+		}
+
+		user, err := userRepo.GetByID(userID)
+		if err != nil {
+			log.Printf("Error loading user for login link: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to log in")
+			return
+		}
+
+		// Following the link proves the user reads mail sent to the address
+		if err := markEmailVerified(user.ID); err != nil {
+			log.Printf("Error marking email verified: %v", err)
+		}
+
+		// The link stands in for the password only; 2FA still applies
+		twoFactor, err := isTwoFactorEnabled(user.ID)
+		if err != nil {
+			log.Printf("Error checking 2FA status: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to log in")
+			return
+		}
+		if twoFactor {
+			if err := startLoginChallenge(w, user.ID, false, afterLogin(r)); err != nil {
+				log.Printf("Error starting login challenge: %v", err)
+				fails.JSONError(w, http.StatusInternalServerError, "Failed to log in")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"status": "2fa_required"})
+			return
+		}
+
+		if session := startSession(w, r, user.ID, user.UserName, false); session == nil {
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to create session")
+			return
+		}
+		recordAuthEvent(r, AuthEvent{UserID: user.ID, Event: eventLogin, Method: methodMagicLink})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":   "success",
+			"username": user.UserName,
+			"redirect": afterLogin(r),
+		})
+
+	default:
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/internals/config"
+)
+
+func TestMagicLinkLogin(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	mailDir := useFileMailer(t)
+
+	rec := postForm(RequestMagicLink, "/login/magic/request", url.Values{"email": {"test1@example.com"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	token := tokenFromMail(t, mailDir)
+
+	rec = postForm(MagicLinkLogin, "/login/magic", url.Values{"token": {token}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	cookie := responseCookie(rec, "session")
+	if cookie == nil {
+		t.Fatal("Expected a session cookie")
+	}
+	var userID int
+	if err := testDB.QueryRow(`SELECT user_id FROM sessions`).Scan(&userID); err != nil || userID != 1 {
+		t.Errorf("Expected a session for user 1, got %d (%v)", userID, err)
+	}
+	if !IsEmailVerified(1) {
+		t.Error("Expected following the link to verify the email")
+	}
+
+	// Links are single use
+	rec = postForm(MagicLinkLogin, "/login/magic", url.Values{"token": {token}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 when reusing a link, got %d", rec.Code)
+	}
+}
+
+func TestMagicLinkUnknownEmail(t *testing.T) {
+	setupAuthTestDB(t)
+	mailDir := useFileMailer(t)
+
+	known := postForm(RequestMagicLink, "/login/magic/request", url.Values{"email": {"test1@example.com"}})
+	unknown := postForm(RequestMagicLink, "/login/magic/request", url.Values{"email": {"nobody@example.com"}})
+	if unknown.Code != known.Code || unknown.Body.String() != known.Body.String() {
+		t.Error("Unknown emails should get the same answer as known ones")
+	}
+	if files, _ := filepath.Glob(filepath.Join(mailDir, "*.eml")); len(files) != 1 {
+		t.Errorf("Expected mail for the known account only, got %d", len(files))
+	}
+}
+
+func TestMagicLinkExpiry(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	mailDir := useFileMailer(t)
+
+	postForm(RequestMagicLink, "/login/magic/request", url.Values{"email": {"test1@example.com"}})
+	token := tokenFromMail(t, mailDir)
+	testDB.Exec(`UPDATE auth_tokens SET expires_at = ?`, time.Now().UTC().Add(-time.Minute))
+
+	rec := postForm(MagicLinkLogin, "/login/magic", url.Values{"token": {token}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an expired link, got %d", rec.Code)
+	}
+}
+
+func TestMagicLinkTwoFactor(t *testing.T) {
+	setupAuthTestDB(t)
+	mailDir := useFileMailer(t)
+	enableTestTOTP(t, 1)
+
+	postForm(RequestMagicLink, "/login/magic/request", url.Values{"email": {"test1@example.com"}})
+	rec := postForm(MagicLinkLogin, "/login/magic", url.Values{"token": {tokenFromMail(t, mailDir)}})
+	if !strings.Contains(rec.Body.String(), "2fa_required") {
+		t.Fatalf("Expected the 2FA step, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if responseCookie(rec, "session") != nil {
+		t.Error("No session should start before the code is entered")
+	}
+	if responseCookie(rec, loginChallengeCookie) == nil {
+		t.Error("Expected a login challenge cookie")
+	}
+}
+
+func TestMagicLinkRedirect(t *testing.T) {
+	setupAuthTestDB(t)
+	mailDir := useFileMailer(t)
+
+	postForm(RequestMagicLink, "/login/magic/request", url.Values{"email": {"test1@example.com"}, "next": {"/view-post?id=4"}})
+	files, _ := filepath.Glob(filepath.Join(mailDir, "*.eml"))
+	content, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(content), "&next=%2Fview-post%3Fid%3D4") {
+		t.Errorf("Expected the link to carry the page to return to:\n%s", content)
+	}
+
+	token := tokenFromMail(t, mailDir)
+	rec := postForm(MagicLinkLogin, "/login/magic", url.Values{"token": {token}, "next": {"/view-post?id=4"}})
+	if !strings.Contains(rec.Body.String(), `"redirect":"/view-post?id=4"`) {
+		t.Errorf("Expected a redirect back to the post, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMagicLinkPage(t *testing.T) {
+	setupAuthTestDB(t)
+	mailDir := useFileMailer(t)
+
+	// The page renders templates relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir("internals/auth")
+
+	postForm(RequestMagicLink, "/login/magic/request", url.Values{"email": {"test1@example.com"}})
+	token := tokenFromMail(t, mailDir)
+
+	// Opening the link must not use it up, so mail scanners can't spend it
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		MagicLinkLogin(rec, httptest.NewRequest("GET", "/login/magic?token="+url.QueryEscape(token), nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Continue") {
+			t.Fatalf("Expected the continue button, got %d", rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	MagicLinkLogin(rec, httptest.NewRequest("GET", "/login/magic?token=not-a-token", nil))
+	if strings.Contains(rec.Body.String(), "Continue") || !strings.Contains(rec.Body.String(), "invalid") {
+		t.Errorf("Expected an unknown token to be reported as invalid")
+	}
+
+	original := magicLinkSettings
+	magicLinkSettings = config.MagicLink{Enabled: false}
+	defer func() { magicLinkSettings = original }()
+	if rec := postForm(MagicLinkLogin, "/login/magic", url.Values{"token": {token}}); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 with login links turned off, got %d", rec.Code)
+	}
+}
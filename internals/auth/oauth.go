@@ -0,0 +1,447 @@
+package auth
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"forum/internals/fails"
+)
+
+// Provider is an OAuth2 login provider. The login handlers only talk to
+// providers through this interface, so adding one is a matter of registering
+// another implementation.
+type Provider interface {
+	// Name is the provider's path segment in /auth/<name>
+	Name() string
+	// DisplayName is shown on the login button
+	DisplayName() string
+	// AuthCodeURL is the consent page the user is sent to, with the PKCE
+	// code challenge for the flow
+	AuthCodeURL(state, codeChallenge string) string
+	// Exchange trades the authorization code and the PKCE code verifier for
+	// an access token
+	Exchange(code, codeVerifier string) (*OAuthToken, error)
+	// FetchUser asks the provider who the token belongs to
+	FetchUser(token *OAuthToken) (*ExternalUser, error)
+}
+
+// OAuthToken is the part of a token response the providers need
+type OAuthToken struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// ExternalUser is the account a provider reports for a token
+type ExternalUser struct {
+	Subject       string // the provider's stable id for the account
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string
+}
+
+// oauthEndpoints are the URLs of an authorization-code provider
+type oauthEndpoints struct {
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+}
+
+// oauth2Provider implements Provider for any authorization-code provider.
+// The only part that differs between providers is mapUser, which turns the
+// user info response into an ExternalUser.
+type oauth2Provider struct {
+	name         string
+	displayName  string
+	clientID     string
+	clientSecret string
+	redirectURI  string
+	endpoints    oauthEndpoints
+	scopes       []string
+	authParams   url.Values // extra query parameters for the consent page
+	mapUser      func(p *oauth2Provider, token *OAuthToken, info map[string]any) (*ExternalUser, error)
+}
+
+// oauthClient is used for every request to a provider
+var oauthClient = &http.Client{Timeout: 10 * time.Second}
+
+func (p *oauth2Provider) Name() string        { return p.name }
+func (p *oauth2Provider) DisplayName() string { return p.displayName }
+
+func (p *oauth2Provider) AuthCodeURL(state, codeChallenge string) string {
+	params := url.Values{}
+	for key, values := range p.authParams {
+		params[key] = values
+	}
+	params.Set("client_id", p.clientID)
+	params.Set("redirect_uri", p.redirectURI)
+	params.Set("response_type", "code")
+	params.Set("state", state)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+	if len(p.scopes) > 0 {
+		params.Set("scope", strings.Join(p.scopes, " "))
+	}
+
+	separator := "?"
+	if strings.Contains(p.endpoints.AuthURL, "?") {
+		separator = "&"
+	}
+	return p.endpoints.AuthURL + separator + params.Encode()
+}
+
+func (p *oauth2Provider) Exchange(code, codeVerifier string) (*OAuthToken, error) {
+	data := url.Values{}
+	data.Set("code", code)
+	data.Set("code_verifier", codeVerifier)
+	data.Set("client_id", p.clientID)
+	data.Set("client_secret", p.clientSecret)
+	data.Set("redirect_uri", p.redirectURI)
+	data.Set("grant_type", "authorization_code")
+
+	req, err := http.NewRequest("POST", p.endpoints.TokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token OAuthToken
+	if err := doJSON(req, &token); err != nil && token.Error == "" {
+		return nil, err
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("%s: %s", token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("token response has no access token")
+	}
+	return &token, nil
+}
+
+func (p *oauth2Provider) FetchUser(token *OAuthToken) (*ExternalUser, error) {
+	var info map[string]any
+	if err := p.getJSON(p.endpoints.UserInfoURL, token, &info); err != nil {
+		return nil, err
+	}
+	user, err := p.mapUser(p, token, info)
+	if err != nil {
+		return nil, err
+	}
+	if user.Subject == "" {
+		return nil, errors.New("user info has no account id")
+	}
+	return user, nil
+}
+
+// getJSON fetches a provider API URL with the access token
+func (p *oauth2Provider) getJSON(target string, token *OAuthToken, v any) error {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return doJSON(req, v)
+}
+
+// doJSON sends the request and decodes the JSON response into v. Numbers are
+// kept as json.Number so large account ids survive. Error responses are
+// still decoded, for the error fields of token responses.
+func doJSON(req *http.Request, v any) error {
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	decodeErr := decoder.Decode(v)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s returned %s", req.Method, req.URL.Redacted(), resp.Status)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("failed to parse response from %s: %v", req.URL.Redacted(), decodeErr)
+	}
+	return nil
+}
+
+// claimString reads a string or number field from a user info response
+func claimString(info map[string]any, key string) string {
+	switch value := info[key].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	}
+	return ""
+}
+
+// claimBool reads a boolean field, which some providers send as a string
+func claimBool(info map[string]any, key string) bool {
+	switch value := info[key].(type) {
+	case bool:
+		return value
+	case string:
+		b, _ := strconv.ParseBool(value)
+		return b
+	}
+	return false
+}
+
+// Registered providers, keyed by name. Configure fills the registry from the
+// server configuration.
+var (
+	providersMu   sync.RWMutex
+	providers     = make(map[string]Provider)
+	providerOrder []string
+)
+
+// RegisterProvider makes the provider available under /auth/<name>,
+// replacing any provider already registered under that name
+func RegisterProvider(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if _, exists := providers[p.Name()]; !exists {
+		providerOrder = append(providerOrder, p.Name())
+	}
+	providers[p.Name()] = p
+}
+
+// resetProviders removes every registered provider
+func resetProviders() {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	providers = make(map[string]Provider)
+	providerOrder = nil
+}
+
+func lookupProvider(name string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	p, ok := providers[name]
+	return p, ok
+}
+
+// registeredProviders lists the providers in the order they were registered
+func registeredProviders() []Provider {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	list := make([]Provider, 0, len(providerOrder))
+	for _, name := range providerOrder {
+		list = append(list, providers[name])
+	}
+	return list
+}
+
+// HandleOAuth serves /auth/<provider>, which starts a login, and
+// /auth/<provider>/callback, where the provider sends the user back
+func HandleOAuth(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/auth/"), "/")
+
+	provider, ok := lookupProvider(name)
+	if !ok {
+		fails.ErrorPageHandler(w, r, http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "", "login": // /auth/facebook/login predates the generic routes
+		startOAuth(w, r, provider)
+	case "callback":
+		finishOAuth(w, r, provider)
+	default:
+		fails.ErrorPageHandler(w, r, http.StatusNotFound)
+	}
+}
+
+// startOAuth sends the user to the provider's consent page
+func startOAuth(w http.ResponseWriter, r *http.Request, provider Provider) {
+	query := r.URL.Query()
+	consentURL, err := beginOAuthFlow(w, r, provider, oauthState{
+		Invite:   query.Get("invite"),
+		Redirect: localRedirect(query.Get("next")),
+	})
+	if err != nil {
+		log.Printf("Error starting %s login: %v", provider.Name(), err)
+		http.Error(w, "Failed to generate state token", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, consentURL, http.StatusTemporaryRedirect)
+}
+
+// finishOAuth handles the provider's callback and logs the user in
+func finishOAuth(w http.ResponseWriter, r *http.Request, provider Provider) {
+	query := r.URL.Query()
+
+	state, ok := consumeOAuthState(r, provider)
+	if !ok {
+		log.Printf("Invalid or expired %s state token", provider.Name())
+		http.Error(w, "Invalid state token", http.StatusBadRequest)
+		return
+	}
+
+	// The user declined on the consent page
+	if errCode := query.Get("error"); errCode != "" {
+		log.Printf("%s login was not completed: %s", provider.Name(), errCode)
+		if state.LinkUserID != 0 {
+			http.Redirect(w, r, "/account/security", http.StatusFound)
+		} else {
+			http.Redirect(w, r, "/login", http.StatusFound)
+		}
+		return
+	}
+
+	token, err := provider.Exchange(query.Get("code"), state.Verifier)
+	if err != nil {
+		log.Printf("Error exchanging %s code for token: %v", provider.Name(), err)
+		http.Error(w, "Failed to exchange code for token", http.StatusInternalServerError)
+		return
+	}
+
+	external, err := provider.FetchUser(token)
+	if err != nil {
+		log.Printf("Error getting %s user info: %v", provider.Name(), err)
+		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
+		return
+	}
+
+	if state.LinkUserID != 0 {
+		finishLink(w, r, provider, state.LinkUserID, external)
+		return
+	}
+
+	user, created, err := loginExternalUser(provider.Name(), external, state.Invite)
+	if notice, refused := registrationNotices[err]; refused {
+		http.Redirect(w, r, "/login?notice="+notice, http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, errUnverifiedExternalEmail) {
+		fails.ErrorPageHandler(w, r, http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, errLinkRequired) {
+		http.Redirect(w, r, "/login?notice=link-required", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Printf("Error signing in %s user: %v", provider.Name(), err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	// The provider stands in for the password only; 2FA still applies, and
+	// the login is recorded once the code step passes
+	twoFactor, err := isTwoFactorEnabled(user.ID)
+	if err != nil {
+		log.Printf("Error checking 2FA status: %v", err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	if twoFactor {
+		if err := startLoginChallenge(w, user.ID, false, state.Redirect); err != nil {
+			log.Printf("Error starting login challenge: %v", err)
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	// Create session and set the session cookie
+	if session := startSession(w, r, user.ID, user.UserName, false); session == nil {
+		log.Printf("Error creating session")
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	if created {
+		recordAuthEvent(r, AuthEvent{UserID: user.ID, Event: eventSignup, Method: methodOAuth, Provider: provider.Name()})
+	}
+	recordAuthEvent(r, AuthEvent{UserID: user.ID, Event: eventLogin, Method: methodOAuth, Provider: provider.Name()})
+
+	target := state.Redirect
+	if target == "" {
+		target = "/"
+	}
+	http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+}
+
+// errUnverifiedExternalEmail is returned when the provider hasn't confirmed
+// the address. Accounts are matched by email, so trusting an unconfirmed one
+// would let anyone sign in as its owner.
+var errUnverifiedExternalEmail = errors.New("provider has not verified the email address")
+
+var invalidUsernameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// usernameFromProfile derives a username from the provider's profile,
+// preferring its username, then the display name, then the email
+func usernameFromProfile(external *ExternalUser) string {
+	base := external.Username
+	if base == "" {
+		base = external.Name
+	}
+	if base == "" {
+		base = strings.Split(external.Email, "@")[0]
+	}
+
+	clean := invalidUsernameChars.ReplaceAllString(base, "-")
+
+	// Trim length (3-20 characters is common for usernames)
+	if len(clean) > 20 {
+		clean = clean[:20]
+	}
+
+	// Ensure minimum length
+	if len(clean) < 3 {
+		clean = "user" + clean // Fallback prefix
+	}
+	return clean
+}
+
+// availableUsername returns base, or base with a number appended if someone
+// already has it
+func availableUsername(base string) (string, error) {
+	for i := 1; i <= 100; i++ {
+		candidate := base
+		if i > 1 {
+			suffix := strconv.Itoa(i)
+			candidate = base[:min(len(base), 20-len(suffix))] + suffix
+		}
+
+		_, err := userRepo.GetByUsername(candidate)
+		if err == sql.ErrNoRows {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free username for %q", base)
+}
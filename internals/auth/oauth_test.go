@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"forum/db"
+	"forum/internals/config"
+)
+
+// fakeAuthServer is a minimal OAuth2/OpenID Connect provider. It accepts the
+// code "good-code" and reports the profile in userInfo for the access token.
+type fakeAuthServer struct {
+	*httptest.Server
+	issuer   string // defaults to the server's URL
+	userInfo map[string]any
+	emails   []GitHubEmail
+	verifier string // the PKCE code verifier of the last token request
+}
+
+func newFakeAuthServer(t *testing.T) *fakeAuthServer {
+	fake := &fakeAuthServer{}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := fake.issuer
+		if issuer == "" {
+			issuer = fake.URL
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": fake.URL + "/authorize",
+			"token_endpoint":         fake.URL + "/token",
+			"userinfo_endpoint":      fake.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		fake.verifier = r.FormValue("code_verifier")
+		if r.Method != http.MethodPost || r.FormValue("client_secret") != "secret" || r.FormValue("code") != "good-code" || fake.verifier == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access-token", "token_type": "Bearer"})
+	})
+	authorized := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer access-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+		}
+	}
+	mux.HandleFunc("/userinfo", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(fake.userInfo)
+	}))
+	mux.HandleFunc("/userinfo/emails", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(fake.emails)
+	}))
+
+	fake.Server = httptest.NewServer(mux)
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (fake *fakeAuthServer) endpoints() oauthEndpoints {
+	return oauthEndpoints{
+		AuthURL:     fake.URL + "/authorize",
+		TokenURL:    fake.URL + "/token",
+		UserInfoURL: fake.URL + "/userinfo",
+	}
+}
+
+func registerTestProvider(t *testing.T, p Provider) {
+	RegisterProvider(p)
+	t.Cleanup(resetProviders)
+}
+
+// oauthLogin runs the browser's side of the flow and returns the callback response
+func oauthLogin(t *testing.T, provider, code string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	HandleOAuth(rec, httptest.NewRequest("GET", "/auth/"+provider, nil))
+	if rec.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected a redirect to the provider, got %d", rec.Code)
+	}
+	return oauthCallback(rec, provider, code)
+}
+
+// oauthCallback comes back from the consent page that start redirected to,
+// with the cookies start set, as the provider would send the browser
+func oauthCallback(start *httptest.ResponseRecorder, provider, code string) *httptest.ResponseRecorder {
+	location, _ := url.Parse(start.Header().Get("Location"))
+	callback := "/auth/" + provider + "/callback?" + url.Values{
+		"state": {location.Query().Get("state")},
+		"code":  {code},
+	}.Encode()
+
+	req := httptest.NewRequest("GET", callback, nil)
+	for _, cookie := range start.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	HandleOAuth(rec, req)
+	return rec
+}
+
+func TestOIDCProviderLogin(t *testing.T) {
+	setupAuthTestDB(t)
+	fake := newFakeAuthServer(t)
+	fake.userInfo = map[string]any{
+		"sub":                "abc-123",
+		"email":              "oidc@example.com",
+		"email_verified":     true,
+		"preferred_username": "oidc.user",
+	}
+
+	provider, err := newConfiguredProvider(config.Provider{
+		Name:         "acme",
+		DisplayName:  "Acme",
+		Issuer:       fake.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURI:  "http://forum.test/auth/acme/callback",
+	})
+	if err != nil {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+	registerTestProvider(t, provider)
+
+	// The consent page comes from the discovery document
+	rec := httptest.NewRecorder()
+	HandleOAuth(rec, httptest.NewRequest("GET", "/auth/acme", nil))
+	location := rec.Header().Get("Location")
+	if !strings.HasPrefix(location, fake.URL+"/authorize?") || !strings.Contains(location, "scope=openid+email+profile") {
+		t.Errorf("Unexpected consent URL %q", location)
+	}
+
+	rec = oauthLogin(t, "acme", "good-code")
+	if rec.Code != http.StatusTemporaryRedirect || !hasSessionCookie(rec) {
+		t.Fatalf("Expected a logged in redirect, got %d", rec.Code)
+	}
+
+	user, err := userRepo.GetByEmail("oidc@example.com")
+	if err != nil {
+		t.Fatalf("User was not created: %v", err)
+	}
+	if user.UserName != "oidc-user" || !IsEmailVerified(user.ID) {
+		t.Errorf("Unexpected user %+v", user)
+	}
+
+	// Logging in again uses the same account
+	oauthLogin(t, "acme", "good-code")
+	var count int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", "oidc@example.com").Scan(&count); err != nil || count != 1 {
+		t.Errorf("Expected one account after two logins, got %d (%v)", count, err)
+	}
+}
+
+func TestGitHubProviderLogin(t *testing.T) {
+	setupAuthTestDB(t)
+	fake := newFakeAuthServer(t)
+	fake.userInfo = map[string]any{"id": 583231, "login": "testuser", "name": "The Octocat"}
+	fake.emails = []GitHubEmail{
+		{Email: "old@example.com", Verified: true},
+		{Email: "octocat@example.com", Primary: true, Verified: true},
+	}
+
+	provider := newGitHubProvider(config.Provider{ClientID: "client", ClientSecret: "secret"})
+	provider.endpoints = fake.endpoints()
+	registerTestProvider(t, provider)
+
+	rec := oauthLogin(t, "github", "good-code")
+	if rec.Code != http.StatusTemporaryRedirect || !hasSessionCookie(rec) {
+		t.Fatalf("Expected a logged in redirect, got %d", rec.Code)
+	}
+
+	// "testuser" and "testuser2" are taken by the seeded users
+	user, err := userRepo.GetByEmail("octocat@example.com")
+	if err != nil {
+		t.Fatalf("User was not created from the primary email: %v", err)
+	}
+	if user.UserName != "testuser3" {
+		t.Errorf("Expected a free username, got %q", user.UserName)
+	}
+}
+
+func TestOAuthCallbackRejected(t *testing.T) {
+	setupAuthTestDB(t)
+	fake := newFakeAuthServer(t)
+	fake.userInfo = map[string]any{"sub": "1", "email": "unverified@example.com", "email_verified": false}
+
+	for _, name := range []string{"one", "two"} {
+		provider, err := newConfiguredProvider(config.Provider{Name: name, Issuer: fake.URL, ClientID: "client", ClientSecret: "secret"})
+		if err != nil {
+			t.Fatalf("Discovery failed: %v", err)
+		}
+		registerTestProvider(t, provider)
+	}
+
+	t.Run("Unknown provider", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		HandleOAuth(rec, httptest.NewRequest("GET", "/auth/nope", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("State from another provider", func(t *testing.T) {
+		start := httptest.NewRecorder()
+		HandleOAuth(start, httptest.NewRequest("GET", "/auth/one", nil))
+		if rec := oauthCallback(start, "two", "good-code"); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("State from another browser", func(t *testing.T) {
+		start := httptest.NewRecorder()
+		HandleOAuth(start, httptest.NewRequest("GET", "/auth/one", nil))
+		location, _ := url.Parse(start.Header().Get("Location"))
+		req := httptest.NewRequest("GET", "/auth/one/callback?code=good-code&state="+url.QueryEscape(location.Query().Get("state")), nil)
+		rec := httptest.NewRecorder()
+		HandleOAuth(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 without the binding cookie, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Bad code", func(t *testing.T) {
+		if rec := oauthLogin(t, "one", "bad-code"); rec.Code != http.StatusInternalServerError || hasSessionCookie(rec) {
+			t.Errorf("Expected the exchange to fail, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Unverified email", func(t *testing.T) {
+		if rec := oauthLogin(t, "one", "good-code"); rec.Code != http.StatusForbidden || hasSessionCookie(rec) {
+			t.Errorf("Expected 403, got %d", rec.Code)
+		}
+		if _, err := userRepo.GetByEmail("unverified@example.com"); err == nil {
+			t.Error("No account should be created for an unverified email")
+		}
+	})
+}
+
+func TestDiscoverOIDCIssuerMismatch(t *testing.T) {
+	fake := newFakeAuthServer(t)
+	fake.issuer = "https://accounts.example.com"
+	if _, err := discoverOIDC(fake.URL); err == nil {
+		t.Error("Expected an error when the document names another issuer")
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// oauthStateTTL is how long the user has to get through the consent page
+	oauthStateTTL = 15 * time.Minute
+	// maxOAuthStates bounds the flows waiting for a callback, so requests
+	// that are never finished can't fill memory between purges
+	maxOAuthStates = 10000
+	// oauthBindingCookie ties a state to the browser that started the flow
+	oauthBindingCookie = "oauth_binding"
+)
+
+var errTooManyOAuthFlows = errors.New("too many unfinished OAuth flows")
+
+// oauthState is what a flow needs to remember between sending the user to
+// the provider and the callback. A flow started from the security page links
+// the login to LinkUserID instead of logging in. Invite carries an invite
+// code from the signup page, Redirect is where to go after logging in, and
+// Verifier is the PKCE code verifier for the token exchange.
+type oauthState struct {
+	Provider   string
+	LinkUserID int
+	Invite     string
+	Redirect   string
+	Verifier   string
+	binding    string
+	ExpiresAt  time.Time
+}
+
+// oauthStateStore holds the states of flows in progress, keyed by the state
+// token sent to the provider. It is shared by every provider.
+type oauthStateStore struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	states map[string]oauthState
+}
+
+func newOAuthStateStore(ttl time.Duration) *oauthStateStore {
+	return &oauthStateStore{ttl: ttl, states: make(map[string]oauthState)}
+}
+
+// oauthStates protect the callback against CSRF and carry each flow's data
+var oauthStates = newOAuthStateStore(oauthStateTTL)
+
+// issue stores the state for the browser with the given binding and returns
+// the token to send to the provider
+func (s *oauthStateStore) issue(state oauthState, binding string) (string, error) {
+	token, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.states) >= maxOAuthStates {
+		s.evictExpiredLocked(time.Now())
+		if len(s.states) >= maxOAuthStates {
+			return "", errTooManyOAuthFlows
+		}
+	}
+	state.binding = binding
+	state.ExpiresAt = time.Now().Add(s.ttl)
+	s.states[token] = state
+	return token, nil
+}
+
+// consume returns the state for a token issued for the provider to the
+// browser with the given binding, if it hasn't expired. A token can only be
+// used once, whether or not it matched.
+func (s *oauthStateStore) consume(token, provider, binding string) (oauthState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[token]
+	if !ok {
+		return oauthState{}, false
+	}
+	delete(s.states, token)
+
+	if state.Provider != provider || !time.Now().Before(state.ExpiresAt) ||
+		binding == "" || subtle.ConstantTimeCompare([]byte(state.binding), []byte(binding)) != 1 {
+		return oauthState{}, false
+	}
+	return state, true
+}
+
+// evictExpired drops the states of flows that were never finished and
+// returns how many were removed
+func (s *oauthStateStore) evictExpired() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.evictExpiredLocked(time.Now())
+}
+
+func (s *oauthStateStore) evictExpiredLocked(now time.Time) int {
+	removed := 0
+	for token, state := range s.states {
+		if !now.Before(state.ExpiresAt) {
+			delete(s.states, token)
+			removed++
+		}
+	}
+	return removed
+}
+
+// beginOAuthFlow stores the state for a new flow and returns the consent
+// page URL to send the user to
+func beginOAuthFlow(w http.ResponseWriter, r *http.Request, provider Provider, state oauthState) (string, error) {
+	verifier, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+	binding, err := oauthBinding(w, r)
+	if err != nil {
+		return "", err
+	}
+
+	state.Provider = provider.Name()
+	state.Verifier = verifier
+	token, err := oauthStates.issue(state, binding)
+	if err != nil {
+		return "", err
+	}
+	return provider.AuthCodeURL(token, pkceChallenge(verifier)), nil
+}
+
+// oauthBinding returns the browser's binding value, setting the cookie if it
+// doesn't have one yet. Flows in several tabs share the same value.
+func oauthBinding(w http.ResponseWriter, r *http.Request) (string, error) {
+	binding := ""
+	if cookie, err := r.Cookie(oauthBindingCookie); err == nil && len(cookie.Value) == 43 {
+		binding = cookie.Value
+	} else if binding, err = randomURLToken(); err != nil {
+		return "", err
+	}
+
+	// The provider sends the user back with a cross-site redirect, which a
+	// Strict cookie wouldn't survive
+	cookie := newCookie(oauthBindingCookie, binding, "/auth", int(oauthStateTTL.Seconds()))
+	if cookie.SameSite == http.SameSiteStrictMode {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	http.SetCookie(w, cookie)
+	return binding, nil
+}
+
+// consumeOAuthState checks the callback's state token against the store and
+// the browser's binding cookie
+func consumeOAuthState(r *http.Request, provider Provider) (oauthState, bool) {
+	cookie, err := r.Cookie(oauthBindingCookie)
+	if err != nil {
+		return oauthState{}, false
+	}
+	return oauthStates.consume(r.URL.Query().Get("state"), provider.Name(), cookie.Value)
+}
+
+// randomURLToken returns 32 random bytes as 43 URL-safe characters
+func randomURLToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallenge is the S256 code challenge for a PKCE verifier (RFC 7636)
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOAuthStateStore(t *testing.T) {
+	store := newOAuthStateStore(time.Minute)
+
+	token, err := store.issue(oauthState{Provider: "acme", Invite: "code"}, "browser-a")
+	if err != nil {
+		t.Fatalf("issue returned error: %v", err)
+	}
+	if _, ok := store.consume(token, "acme", "browser-b"); ok {
+		t.Error("Expected a state to be refused for another browser")
+	}
+	// The failed attempt used the token up
+	if _, ok := store.consume(token, "acme", "browser-a"); ok {
+		t.Error("Expected a state to work only once")
+	}
+
+	token, _ = store.issue(oauthState{Provider: "acme", Invite: "code"}, "browser-a")
+	state, ok := store.consume(token, "acme", "browser-a")
+	if !ok || state.Invite != "code" {
+		t.Errorf("Expected the stored state, got %+v, %v", state, ok)
+	}
+
+	expired := newOAuthStateStore(-time.Minute)
+	token, _ = expired.issue(oauthState{Provider: "acme"}, "browser-a")
+	expired.issue(oauthState{Provider: "acme"}, "browser-a")
+	if _, ok := expired.consume(token, "acme", "browser-a"); ok {
+		t.Error("Expected an expired state to be refused")
+	}
+	if removed := expired.evictExpired(); removed != 1 || len(expired.states) != 0 {
+		t.Errorf("Expected one expired state to be evicted, got %d with %d left", removed, len(expired.states))
+	}
+}
+
+func TestOAuthPKCEAndRedirect(t *testing.T) {
+	setupAuthTestDB(t)
+	fake := registerFakeProvider(t)
+	fake.userInfo = map[string]any{"sub": "new-1", "email": "new@example.com", "email_verified": true}
+
+	tests := []struct {
+		name string
+		next string
+		want string
+	}{
+		{"Local path", "/post/7?comment=2", "/post/7?comment=2"},
+		{"No target", "", "/"},
+		{"Other site", "https://evil.example/", "/"},
+		{"Protocol-relative", "//evil.example/", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := httptest.NewRecorder()
+			HandleOAuth(start, httptest.NewRequest("GET", "/auth/acme?next="+url.QueryEscape(tt.next), nil))
+			location, _ := url.Parse(start.Header().Get("Location"))
+			challenge := location.Query().Get("code_challenge")
+			if location.Query().Get("code_challenge_method") != "S256" || challenge == "" {
+				t.Fatalf("Expected a PKCE challenge in %q", location)
+			}
+
+			rec := oauthCallback(start, "acme", "good-code")
+			if pkceChallenge(fake.verifier) != challenge {
+				t.Errorf("Expected the exchange to send the verifier for %q, got %q", challenge, fake.verifier)
+			}
+			if got := rec.Header().Get("Location"); !hasSessionCookie(rec) || got != tt.want {
+				t.Errorf("Expected a login redirecting to %q, got %d %q", tt.want, rec.Code, got)
+			}
+		})
+	}
+}
+
+func TestOAuthLoginWithTwoFactor(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	fake := registerFakeProvider(t)
+	fake.userInfo = map[string]any{"sub": "linked-1", "email": "test1@example.com", "email_verified": true}
+	linkIdentity(1, "acme", &ExternalUser{Subject: "linked-1"})
+	secret := enableTestTOTP(t, 1)
+
+	start := httptest.NewRecorder()
+	HandleOAuth(start, httptest.NewRequest("GET", "/auth/acme?next=/drafts", nil))
+	rec := oauthCallback(start, "acme", "good-code")
+	if hasSessionCookie(rec) || rec.Header().Get("Location") != "/login/2fa" {
+		t.Fatalf("Expected the linked login to stop at the 2FA step, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	var logins int
+	testDB.QueryRow(`SELECT COUNT(*) FROM auth_events WHERE user_id = 1 AND event = ?`, eventLogin).Scan(&logins)
+	if logins != 0 {
+		t.Errorf("Expected no login recorded before the code, got %d", logins)
+	}
+
+	cookie := responseCookie(rec, loginChallengeCookie)
+	if cookie == nil {
+		t.Fatal("Expected a login challenge cookie")
+	}
+	rec = postTwoFactorCode(cookie, currentTOTPCode(secret))
+	if rec.Code != http.StatusOK || !hasSessionCookie(rec) || !strings.Contains(rec.Body.String(), `"redirect":"/drafts"`) {
+		t.Errorf("Expected the code to finish the login, got %d: %s", rec.Code, rec.Body.String())
+	}
+	testDB.QueryRow(`SELECT COUNT(*) FROM auth_events WHERE user_id = 1 AND event = ?`, eventLogin).Scan(&logins)
+	if logins != 1 {
+		t.Errorf("Expected the login recorded after the code, got %d", logins)
+	}
+}
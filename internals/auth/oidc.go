@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"forum/internals/config"
+)
+
+// oidcDiscovery is the part of an OpenID Connect discovery document we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// discoverOIDC reads the issuer's endpoints from its discovery document
+func discoverOIDC(issuer string) (oauthEndpoints, error) {
+	issuer = strings.TrimRight(issuer, "/")
+
+	req, err := http.NewRequest("GET", issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return oauthEndpoints{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	var doc oidcDiscovery
+	if err := doJSON(req, &doc); err != nil {
+		return oauthEndpoints{}, err
+	}
+
+	// The document must describe the issuer we asked for, or a compromised
+	// or misconfigured host could point logins somewhere else
+	if strings.TrimRight(doc.Issuer, "/") != issuer {
+		return oauthEndpoints{}, fmt.Errorf("discovery document is for issuer %q, expected %q", doc.Issuer, issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.UserinfoEndpoint == "" {
+		return oauthEndpoints{}, fmt.Errorf("discovery document for %q is missing endpoints", issuer)
+	}
+
+	return oauthEndpoints{
+		AuthURL:     doc.AuthorizationEndpoint,
+		TokenURL:    doc.TokenEndpoint,
+		UserInfoURL: doc.UserinfoEndpoint,
+	}, nil
+}
+
+// standardClaims are the OpenID Connect user info claims, used for any field
+// a configured provider doesn't map
+var standardClaims = map[string]string{
+	"subject":        "sub",
+	"email":          "email",
+	"email_verified": "email_verified",
+	"name":           "name",
+	"username":       "preferred_username",
+}
+
+// newConfiguredProvider builds a provider from an oauth.providers entry,
+// fetching the issuer's discovery document if it has one
+func newConfiguredProvider(p config.Provider) (*oauth2Provider, error) {
+	endpoints := oauthEndpoints{AuthURL: p.AuthURL, TokenURL: p.TokenURL, UserInfoURL: p.UserInfoURL}
+	scopes := p.Scopes
+
+	if p.Issuer != "" {
+		var err error
+		if endpoints, err = discoverOIDC(p.Issuer); err != nil {
+			return nil, err
+		}
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+	}
+
+	fields := make(map[string]string, len(standardClaims))
+	for field, claim := range standardClaims {
+		fields[field] = claim
+	}
+	for field, claim := range p.Fields {
+		fields[field] = claim
+	}
+
+	return &oauth2Provider{
+		name:         p.Name,
+		displayName:  p.DisplayName,
+		clientID:     p.ClientID,
+		clientSecret: p.ClientSecret,
+		redirectURI:  p.RedirectURI,
+		endpoints:    endpoints,
+		scopes:       scopes,
+		mapUser: func(_ *oauth2Provider, _ *OAuthToken, info map[string]any) (*ExternalUser, error) {
+			return &ExternalUser{
+				Subject:       claimString(info, fields["subject"]),
+				Email:         claimString(info, fields["email"]),
+				EmailVerified: claimBool(info, fields["email_verified"]),
+				Name:          claimString(info, fields["name"]),
+				Username:      claimString(info, fields["username"]),
+			}, nil
+		},
+	}, nil
+}
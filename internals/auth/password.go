@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"forum/db"
+	"forum/internals/config"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher turns passwords into self-describing hashes: everything
+// needed to check a password, including the parameters, is in the encoded
+// string, so parameters can change without breaking existing hashes.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) (bool, error)
+	// Handles reports whether encoded was produced by this kind of hasher
+	Handles(encoded string) bool
+	// NeedsRehash reports whether encoded, which the hasher handles, was made
+	// with parameters other than the hasher's current ones
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2idHasher hashes passwords with Argon2id and encodes them in the PHC
+// string format: $argon2id$v=19$m=<KiB>,t=<iterations>,p=<threads>$<salt>$<key>
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var errMalformedHash = errors.New("malformed password hash")
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// decodeArgon2id splits an encoded hash into its parameters, salt and key
+func decodeArgon2id(encoded string) (Argon2idHasher, []byte, []byte, error) {
+	var params Argon2idHasher
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errMalformedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, errMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errMalformedHash
+	}
+	return params, salt, key, nil
+}
+
+func (h Argon2idHasher) Verify(encoded, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(candidate, key) == 1, nil
+}
+
+func (h Argon2idHasher) Handles(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	return err != nil || params != h || len(salt) != argon2SaltLength || len(key) != argon2KeyLength
+}
+
+// BcryptHasher hashes passwords with bcrypt, which was the only scheme
+// before Argon2id. Its hashes carry their cost in the $2a$<cost>$ prefix.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func (h BcryptHasher) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h BcryptHasher) Handles(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+var (
+	// passwordHasher makes every new hash. Configure sets it from the
+	// server configuration.
+	passwordHasher PasswordHasher = Argon2idHasher{Memory: 19 * 1024, Iterations: 2, Parallelism: 1}
+	// legacyHashers can still check hashes made by other schemes
+	legacyHashers = []PasswordHasher{
+		Argon2idHasher{Memory: 19 * 1024, Iterations: 2, Parallelism: 1},
+		BcryptHasher{Cost: bcrypt.DefaultCost},
+	}
+)
+
+// newPasswordHasher builds the hasher the configuration asks for
+func newPasswordHasher(cfg config.Password) PasswordHasher {
+	if cfg.Hasher == "bcrypt" {
+		return BcryptHasher{Cost: cfg.BcryptCost}
+	}
+	return Argon2idHasher{Memory: cfg.Argon2.Memory, Iterations: cfg.Argon2.Iterations, Parallelism: cfg.Argon2.Parallelism}
+}
+
+// SetPasswordHasher replaces the hasher used for new passwords. Hashes made
+// by earlier hashers keep working and are upgraded as their users log in.
+func SetPasswordHasher(hasher PasswordHasher) {
+	dummyHash.Lock()
+	defer dummyHash.Unlock()
+	passwordHasher = hasher
+	dummyHash.hash = ""
+}
+
+func hashPassword(password string) (string, error) {
+	return passwordHasher.Hash(password)
+}
+
+// checkPassword reports whether the password matches the stored hash, and
+// whether the hash should be replaced because it was made by another
+// scheme or with outdated parameters
+func checkPassword(encoded, password string) (match bool, rehash bool) {
+	for _, hasher := range append([]PasswordHasher{passwordHasher}, legacyHashers...) {
+		if !hasher.Handles(encoded) {
+			continue
+		}
+		match, err := hasher.Verify(encoded, password)
+		if err != nil {
+			log.Printf("Error verifying password hash: %v", err)
+			return false, false
+		}
+		current := passwordHasher.Handles(encoded) && !passwordHasher.NeedsRehash(encoded)
+		return match, match && !current
+	}
+	return false, false
+}
+
+// rehashPassword stores a fresh hash of the password the user just logged in
+// with. It leaves the row alone if the password changed in the meantime.
+func rehashPassword(user *User, password string) error {
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec("UPDATE users SET password = ? WHERE id = ? AND password = ?", hashed, user.ID, user.Password)
+	return err
+}
+
+// dummyHash is checked against when a login names no known user, so the
+// response takes as long as a real password check with the current hasher
+var dummyHash struct {
+	sync.Mutex
+	hash string
+}
+
+func dummyPasswordHash() string {
+	dummyHash.Lock()
+	defer dummyHash.Unlock()
+	if dummyHash.hash == "" {
+		hash, err := passwordHasher.Hash("not a real password")
+		if err != nil {
+			log.Printf("Error making dummy password hash: %v", err)
+		}
+		dummyHash.hash = hash
+	}
+	return dummyHash.hash
+}
+
+// maxPasswordLength keeps hashing cost bounded
+const maxPasswordLength = 128
+
+// passwordPolicy decides which new passwords are accepted
+type passwordPolicy struct {
+	MinLength int
+	// Blocklist holds lowercased common and breached passwords
+	Blocklist map[string]bool
+}
+
+var policy = passwordPolicy{MinLength: 8}
+
+// loadPasswordBlocklist reads one password per line, skipping blank lines and
+// lines starting with #
+func loadPasswordBlocklist(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	blocklist := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		blocklist[strings.ToLower(line)] = true
+	}
+	return blocklist, scanner.Err()
+}
+
+// Check returns everything wrong with a new password for the account
+func (p passwordPolicy) Check(password, username, email string) []string {
+	var problems []string
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		problems = append(problems, fmt.Sprintf("Password must be at least %d characters long", p.MinLength))
+	}
+	if length > maxPasswordLength {
+		problems = append(problems, fmt.Sprintf("Password must be at most %d characters long", maxPasswordLength))
+	}
+
+	lower := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+	switch {
+	case p.Blocklist[lower]:
+		problems = append(problems, "This password is too common. Please choose another one")
+	case username != "" && lower == strings.ToLower(username), localPart != "" && lower == localPart:
+		problems = append(problems, "Password must not be the same as your username or email")
+	}
+	return problems
+}
+
+// configurePasswords applies the password settings
+func configurePasswords(cfg config.Password) {
+	SetPasswordHasher(newPasswordHasher(cfg))
+	policy = passwordPolicy{MinLength: cfg.MinLength}
+	if cfg.BlocklistFile == "" {
+		return
+	}
+	blocklist, err := loadPasswordBlocklist(cfg.BlocklistFile)
+	if err != nil {
+		log.Printf("Password blocklist not loaded, common passwords will be accepted: %v", err)
+		return
+	}
+	policy.Blocklist = blocklist
+}
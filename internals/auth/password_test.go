@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// usePasswordHasher swaps in a password hasher for the length of a test
+func usePasswordHasher(t *testing.T, hasher PasswordHasher) {
+	original := passwordHasher
+	SetPasswordHasher(hasher)
+	t.Cleanup(func() { SetPasswordHasher(original) })
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1}
+	encoded, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash returned error: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Expected parameters in the encoded hash, got %q", encoded)
+	}
+	if ok, err := hasher.Verify(encoded, "correct horse"); !ok || err != nil {
+		t.Errorf("Expected password to verify, got %v, %v", ok, err)
+	}
+	if ok, _ := hasher.Verify(encoded, "wrong horse"); ok {
+		t.Error("Wrong password should not verify")
+	}
+
+	// The stored parameters are used, so a hasher with other settings still verifies it
+	stronger := Argon2idHasher{Memory: 128, Iterations: 2, Parallelism: 1}
+	if ok, _ := stronger.Verify(encoded, "correct horse"); !ok {
+		t.Error("Expected hash to verify with its own parameters")
+	}
+	if hasher.NeedsRehash(encoded) || !stronger.NeedsRehash(encoded) {
+		t.Error("NeedsRehash should compare the stored parameters with the hasher's")
+	}
+
+	if _, err := hasher.Verify("$argon2id$v=19$m=64$bad", "x"); err != errMalformedHash {
+		t.Errorf("Expected errMalformedHash, got %v", err)
+	}
+}
+
+func TestCheckPasswordRehash(t *testing.T) {
+	current := Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1}
+	usePasswordHasher(t, current)
+
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	outdated, _ := Argon2idHasher{Memory: 32, Iterations: 1, Parallelism: 1}.Hash("password123")
+	fresh, _ := current.Hash("password123")
+
+	tests := []struct {
+		name       string
+		encoded    string
+		password   string
+		wantMatch  bool
+		wantRehash bool
+	}{
+		{"Bcrypt hash is upgraded", string(legacy), "password123", true, true},
+		{"Outdated Argon2id parameters", outdated, "password123", true, true},
+		{"Current hash", fresh, "password123", true, false},
+		{"Wrong password is never rehashed", string(legacy), "password124", false, false},
+		{"Unknown scheme", "plaintext", "plaintext", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, rehash := checkPassword(tt.encoded, tt.password)
+			if match != tt.wantMatch || rehash != tt.wantRehash {
+				t.Errorf("checkPassword() = %v, %v, want %v, %v", match, rehash, tt.wantMatch, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	usePasswordHasher(t, Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1})
+
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	if _, err := testDB.Exec(`UPDATE users SET password = ? WHERE id = 1`, string(legacy)); err != nil {
+		t.Fatalf("Failed to store bcrypt hash: %v", err)
+	}
+
+	// Login renders templates relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir("internals/auth")
+
+	rec := postForm(Login, "/login", url.Values{"identifier": {"testuser"}, "password": {"password123"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var stored string
+	testDB.QueryRow(`SELECT password FROM users WHERE id = 1`).Scan(&stored)
+	if !strings.HasPrefix(stored, "$argon2id$") {
+		t.Fatalf("Expected the hash to be upgraded to Argon2id, got %q", stored)
+	}
+	if match, rehash := checkPassword(stored, "password123"); !match || rehash {
+		t.Error("Upgraded hash should verify and be current")
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "common.txt")
+	if err := os.WriteFile(path, []byte("# common passwords\nPassword123\n\nqwertyuiop\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write blocklist: %v", err)
+	}
+	blocklist, err := loadPasswordBlocklist(path)
+	if err != nil {
+		t.Fatalf("loadPasswordBlocklist returned error: %v", err)
+	}
+	if len(blocklist) != 2 {
+		t.Fatalf("Expected 2 blocked passwords, got %d", len(blocklist))
+	}
+	p := passwordPolicy{MinLength: 10, Blocklist: blocklist}
+
+	tests := []struct {
+		name     string
+		password string
+		want     string
+	}{
+		{"Long enough", "a fine passphrase", ""},
+		{"Too short", "short", "at least 10"},
+		{"Too long", strings.Repeat("x", maxPasswordLength+1), "at most"},
+		{"Blocked regardless of case", "PASSWORD123", "too common"},
+		{"Same as username", "AliceInChains", "username"},
+		{"Same as email", "alice.smith", "username or email"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := strings.Join(p.Check(tt.password, "aliceinchains", "alice.smith@example.com"), "; ")
+			if tt.want == "" && problems != "" {
+				t.Errorf("Expected no problems, got %q", problems)
+			}
+			if !strings.Contains(problems, tt.want) {
+				t.Errorf("Expected a problem mentioning %q, got %q", tt.want, problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/db"
+	"forum/internals/fails"
+)
+
+// Registration modes, set by registration.mode in the configuration
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite"
+	RegistrationDomain = "domain"
+	RegistrationClosed = "closed"
+)
+
+const (
+	// maxInviteUses caps how many accounts one invite code can create
+	maxInviteUses = 1000
+	// maxInviteDays caps how long an invite code stays valid
+	maxInviteDays = 90
+)
+
+// Reasons a new account is refused under the registration mode
+var (
+	errRegistrationClosed = errors.New("registration is closed")
+	errInviteRequired     = errors.New("a valid invite code is required")
+	errDomainNotAllowed   = errors.New("email domain is not allowed to register")
+)
+
+// registrationErrors are the messages shown when a signup is refused, and
+// registrationNotices the login page notices for refused OAuth signups
+var (
+	registrationErrors = map[error]string{
+		errRegistrationClosed: "New accounts can't be created right now",
+		errInviteRequired:     "A valid invite code is needed to sign up",
+		errDomainNotAllowed:   "Sign up with an email address from an allowed domain",
+	}
+	registrationNotices = map[error]string{
+		errRegistrationClosed: "registration-closed",
+		errInviteRequired:     "invite-required",
+		errDomainNotAllowed:   "domain-not-allowed",
+	}
+)
+
+// Invite is an invite code as listed to admins. Only a hash of the code is
+// stored, so it can't be shown again after creation.
+type Invite struct {
+	ID        int       `json:"id"`
+	CreatedBy int       `json:"created_by"`
+	MaxUses   int       `json:"max_uses"`
+	Uses      int       `json:"uses"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// emailDomainAllowed reports whether the email is at one of the allowed domains
+func emailDomainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range registrationSettings.AllowedDomains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// admitRegistration decides whether a new account may be created for the
+// email. In invite mode the code is redeemed and its id returned, so a
+// signup that fails afterwards can give the use back with releaseInvite.
+func admitRegistration(email, code string) (int, error) {
+	switch registrationSettings.Mode {
+	case RegistrationOpen:
+		return 0, nil
+	case RegistrationInvite:
+		return redeemInvite(code)
+	case RegistrationDomain:
+		if !emailDomainAllowed(email) {
+			return 0, errDomainNotAllowed
+		}
+		return 0, nil
+	default:
+		return 0, errRegistrationClosed
+	}
+}
+
+// createInvite stores a new invite code and returns it along with the code
+// to hand out
+func createInvite(createdBy, maxUses int, ttl time.Duration) (*Invite, string, error) {
+	code, codeHash, err := generateToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now().UTC()
+	invite := &Invite{CreatedBy: createdBy, MaxUses: maxUses, ExpiresAt: now.Add(ttl), CreatedAt: now}
+	result, err := db.DB.Exec(
+		"INSERT INTO invites (code_hash, created_by, max_uses, expires_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		codeHash, createdBy, maxUses, invite.ExpiresAt, now,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, "", err
+	}
+	invite.ID = int(id)
+	return invite, code, nil
+}
+
+// redeemInvite uses up one use of a valid invite code and returns its id
+func redeemInvite(code string) (int, error) {
+	if code == "" {
+		return 0, errInviteRequired
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(
+		"SELECT id FROM invites WHERE code_hash = ? AND uses < max_uses AND expires_at > ?",
+		hashToken(code), time.Now().UTC(),
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, errInviteRequired
+	} else if err != nil {
+		return 0, err
+	}
+
+	// The condition is checked again so two signups can't share the last use
+	result, err := tx.Exec("UPDATE invites SET uses = uses + 1 WHERE id = ? AND uses < max_uses", id)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		return 0, errInviteRequired
+	}
+	return id, tx.Commit()
+}
+
+// releaseInvite gives back a use taken by a signup that then failed
+func releaseInvite(id int) {
+	if id == 0 {
+		return
+	}
+	if _, err := db.DB.Exec("UPDATE invites SET uses = uses - 1 WHERE id = ? AND uses > 0", id); err != nil {
+		log.Printf("Error releasing invite: %v", err)
+	}
+}
+
+// listInvites returns every invite code, newest first
+func listInvites() ([]Invite, error) {
+	rows, err := db.DB.Query(
+		"SELECT id, created_by, max_uses, uses, expires_at, created_at FROM invites ORDER BY created_at DESC, id DESC",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []Invite{}
+	for rows.Next() {
+		var invite Invite
+		if err := rows.Scan(&invite.ID, &invite.CreatedBy, &invite.MaxUses, &invite.Uses, &invite.ExpiresAt, &invite.CreatedAt); err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// revokeInvite deletes an invite code and reports whether it existed.
+// Accounts already created with it are kept.
+func revokeInvite(id int) (bool, error) {
+	result, err := db.DB.Exec("DELETE FROM invites WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// ListInvites returns every invite code as JSON
+func ListInvites(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	invites, err := listInvites()
+	if err != nil {
+		log.Printf("Error listing invites: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to list invites")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// CreateInvite makes an invite code that can be used max_uses times (1 by
+// default) within days days (7 by default). The code is in the response, with
+// a ready-made signup link, and is never shown again.
+func CreateInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	maxUses, err := formInt(r, "max_uses", 1)
+	if err != nil || maxUses < 1 || maxUses > maxInviteUses {
+		fails.JSONError(w, http.StatusBadRequest, "max_uses must be between 1 and "+strconv.Itoa(maxInviteUses))
+		return
+	}
+	days, err := formInt(r, "days", 7)
+	if err != nil || days < 1 || days > maxInviteDays {
+		fails.JSONError(w, http.StatusBadRequest, "days must be between 1 and "+strconv.Itoa(maxInviteDays))
+		return
+	}
+
+	invite, code, err := createInvite(session.UserID, maxUses, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		log.Printf("Error creating invite: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to create invite")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":   code,
+		"link":   baseURL + "/signup?invite=" + url.QueryEscape(code),
+		"invite": invite,
+	})
+}
+
+// formInt reads an optional integer form field
+func formInt(r *http.Request, name string, fallback int) (int, error) {
+	value := strings.TrimSpace(r.FormValue(name))
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// RevokeInvite deletes an invite code so it can't be used any more
+func RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	inviteID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		fails.JSONError(w, http.StatusBadRequest, "Missing invite id")
+		return
+	}
+
+	found, err := revokeInvite(inviteID)
+	if err != nil {
+		log.Printf("Error revoking invite: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to revoke invite")
+		return
+	}
+	if !found {
+		fails.JSONError(w, http.StatusNotFound, "Invite not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "revoked"})
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"forum/db"
+	"forum/internals/config"
+)
+
+// useRegistration swaps in registration settings for the length of a test
+func useRegistration(t *testing.T, settings config.Registration) {
+	original := registrationSettings
+	registrationSettings = settings
+	t.Cleanup(func() { registrationSettings = original })
+}
+
+func TestAdmitRegistration(t *testing.T) {
+	setupAuthTestDB(t)
+
+	tests := []struct {
+		name     string
+		settings config.Registration
+		email    string
+		want     error
+	}{
+		{"Open", config.Registration{Mode: RegistrationOpen}, "anyone@example.net", nil},
+		{"Allowed domain", config.Registration{Mode: RegistrationDomain, AllowedDomains: []string{"example.com"}}, "me@Example.COM", nil},
+		{"Other domain", config.Registration{Mode: RegistrationDomain, AllowedDomains: []string{"example.com"}}, "me@example.com.evil.net", errDomainNotAllowed},
+		{"Subdomain is not the domain", config.Registration{Mode: RegistrationDomain, AllowedDomains: []string{"example.com"}}, "me@mail.example.com", errDomainNotAllowed},
+		{"Invite without a code", config.Registration{Mode: RegistrationInvite}, "me@example.com", errInviteRequired},
+		{"Closed", config.Registration{Mode: RegistrationClosed}, "me@example.com", errRegistrationClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRegistration(t, tt.settings)
+			if _, err := admitRegistration(tt.email, ""); err != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestInviteUses(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+
+	invite, code, err := createInvite(1, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("createInvite returned error: %v", err)
+	}
+	var stored string
+	testDB.QueryRow("SELECT code_hash FROM invites WHERE id = ?", invite.ID).Scan(&stored)
+	if stored != hashToken(code) {
+		t.Error("Expected the invite code to be stored as its hash")
+	}
+
+	for i := 0; i < 2; i++ {
+		if id, err := redeemInvite(code); err != nil || id != invite.ID {
+			t.Fatalf("Use %d: expected invite %d, got %d, %v", i+1, invite.ID, id, err)
+		}
+	}
+	if _, err := redeemInvite(code); err != errInviteRequired {
+		t.Errorf("Expected the invite to be used up, got %v", err)
+	}
+
+	// A failed signup gives its use back
+	releaseInvite(invite.ID)
+	if _, err := redeemInvite(code); err != nil {
+		t.Errorf("Expected a released use to be available, got %v", err)
+	}
+
+	_, expired, _ := createInvite(1, 5, -time.Minute)
+	if _, err := redeemInvite(expired); err != errInviteRequired {
+		t.Errorf("Expected an expired invite to be refused, got %v", err)
+	}
+	if _, err := redeemInvite("not-a-code"); err != errInviteRequired {
+		t.Errorf("Expected an unknown code to be refused, got %v", err)
+	}
+
+	if found, err := revokeInvite(invite.ID); !found || err != nil {
+		t.Errorf("Expected the invite to be revoked, got %v, %v", found, err)
+	}
+	if invites, _ := listInvites(); len(invites) != 1 {
+		t.Errorf("Expected one invite left, got %d", len(invites))
+	}
+}
+
+func TestSignupInviteOnly(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	useFileMailer(t)
+	usePasswordHasher(t, Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1})
+	useRegistration(t, config.Registration{Mode: RegistrationInvite})
+	invite, code, _ := createInvite(1, 1, time.Hour)
+
+	// Signup parses templates relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir("internals/auth")
+
+	form := url.Values{"username": {"newcomer"}, "email": {"new@example.com"}, "password": {"a fine passphrase"}}
+	if rec := postForm(Signup, "/signup", form); rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 without an invite, got %d", rec.Code)
+	}
+
+	// An invalid form doesn't use up the invite
+	form.Set("invite", code)
+	form.Set("password", "short")
+	if rec := postForm(Signup, "/signup", form); rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a short password, got %d", rec.Code)
+	}
+
+	form.Set("password", "a fine passphrase")
+	if rec := postForm(Signup, "/signup", form); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 with an invite, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var uses int
+	testDB.QueryRow("SELECT uses FROM invites WHERE id = ?", invite.ID).Scan(&uses)
+	if uses != 1 {
+		t.Errorf("Expected the invite to be used once, got %d", uses)
+	}
+
+	form.Set("username", "latecomer")
+	form.Set("email", "late@example.com")
+	if rec := postForm(Signup, "/signup", form); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 once the invite is used up, got %d", rec.Code)
+	}
+}
+
+func TestOAuthSignupRegistrationModes(t *testing.T) {
+	setupAuthTestDB(t)
+	fake := registerFakeProvider(t)
+	fake.userInfo = map[string]any{"sub": "new-1", "email": "new@example.com", "email_verified": true}
+
+	countUsers := func() int {
+		var count int
+		db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", "new@example.com").Scan(&count)
+		return count
+	}
+
+	useRegistration(t, config.Registration{Mode: RegistrationClosed})
+	rec := oauthLogin(t, "acme", "good-code")
+	if location := rec.Header().Get("Location"); location != "/login?notice=registration-closed" {
+		t.Fatalf("Expected the registration notice, got %d %q", rec.Code, location)
+	}
+	if countUsers() != 0 {
+		t.Fatal("No account should be created while registration is closed")
+	}
+
+	// The invite code from the signup page survives the trip to the provider
+	useRegistration(t, config.Registration{Mode: RegistrationInvite})
+	_, code, _ := createInvite(1, 1, time.Hour)
+	rec = httptest.NewRecorder()
+	HandleOAuth(rec, httptest.NewRequest("GET", "/auth/acme?invite="+url.QueryEscape(code), nil))
+	rec = oauthCallback(rec, "acme", "good-code")
+	if !hasSessionCookie(rec) || countUsers() != 1 {
+		t.Fatalf("Expected the invite to create an account, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// Existing accounts keep logging in without an invite
+	useRegistration(t, config.Registration{Mode: RegistrationClosed})
+	if rec := oauthLogin(t, "acme", "good-code"); !hasSessionCookie(rec) {
+		t.Errorf("Expected an existing account to log in, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/db"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	// rememberCookie holds selector:validator for browsers that chose "remember me"
+	rememberCookie = "remember"
+	// rememberGrace is how long the validator replaced by a rotation keeps
+	// working, so parallel requests that sent the old cookie aren't mistaken
+	// for a stolen token
+	rememberGrace = time.Minute
+)
+
+// rememberToken is a row of remember_tokens
+type rememberToken struct {
+	Selector      string
+	UserID        int
+	SessionID     string
+	ValidatorHash string
+	PreviousHash  string
+	RotatedAt     time.Time
+	ExpiresAt     time.Time
+}
+
+// rememberEnabled reports whether "remember me" is offered at all
+func rememberEnabled() bool {
+	return sessionSettings.RememberMe.Duration > 0
+}
+
+// issueRememberToken stores a new remember-me token tied to the session and
+// sets its cookie. The selector finds the row; only a hash of the validator
+// is stored, so a leaked database can't be used to log in.
+func issueRememberToken(w http.ResponseWriter, userID int, sessionID uuid.UUID) error {
+	selector, _, err := generateToken()
+	if err != nil {
+		return err
+	}
+	validator, validatorHash, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(sessionSettings.RememberMe.Duration)
+	_, err = db.DB.Exec(
+		`INSERT INTO remember_tokens (selector, user_id, session_id, validator_hash, rotated_at, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		selector, userID, sessionID.String(), validatorHash, now, expiresAt, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	setRememberCookie(w, selector, validator, expiresAt)
+	return nil
+}
+
+func setRememberCookie(w http.ResponseWriter, selector, validator string, expiresAt time.Time) {
+	http.SetCookie(w, newCookie(rememberCookie, selector+":"+validator, "/", int(time.Until(expiresAt).Seconds())))
+}
+
+func clearRememberCookie(w http.ResponseWriter) {
+	http.SetCookie(w, newCookie(rememberCookie, "", "/", -1))
+}
+
+// parseRememberCookie splits the request's remember cookie into its selector and validator
+func parseRememberCookie(r *http.Request) (string, string, bool) {
+	cookie, err := r.Cookie(rememberCookie)
+	if err != nil {
+		return "", "", false
+	}
+	selector, validator, ok := strings.Cut(cookie.Value, ":")
+	if !ok || selector == "" || validator == "" {
+		return "", "", false
+	}
+	return selector, validator, true
+}
+
+func lookupRememberToken(selector string) (*rememberToken, error) {
+	var token rememberToken
+	err := db.DB.QueryRow(
+		`SELECT selector, user_id, session_id, validator_hash, previous_hash, rotated_at, expires_at
+		FROM remember_tokens WHERE selector = ? AND expires_at > ?`,
+		selector, time.Now().UTC(),
+	).Scan(
+		&token.Selector, &token.UserID, &token.SessionID, &token.ValidatorHash,
+		&token.PreviousHash, &token.RotatedAt, &token.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// resumeRememberedSession signs the browser back in from its remember cookie
+// once its session has ended. Every use swaps the validator for a new one.
+// A validator that was already swapped out means the cookie was copied, so
+// every remembered browser and session of the user is signed out.
+func resumeRememberedSession(w http.ResponseWriter, r *http.Request) *Session {
+	selector, validator, ok := parseRememberCookie(r)
+	if !ok {
+		return nil
+	}
+
+	token, err := lookupRememberToken(selector)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error looking up remember-me token: %v", err)
+		}
+		clearRememberCookie(w)
+		return nil
+	}
+
+	validatorHash := hashToken(validator)
+	switch {
+	case subtle.ConstantTimeCompare([]byte(validatorHash), []byte(token.ValidatorHash)) == 1:
+		return rotateRememberToken(w, r, token)
+
+	case token.PreviousHash != "" &&
+		subtle.ConstantTimeCompare([]byte(validatorHash), []byte(token.PreviousHash)) == 1 &&
+		time.Since(token.RotatedAt) < rememberGrace:
+		// Another request already rotated this token; share its session
+		return rememberedSession(token)
+
+	default:
+		log.Printf("Remember-me token reused for user %d; signing out every device", token.UserID)
+		if err := store.DeleteUserSessions(token.UserID); err != nil {
+			log.Printf("Error revoking sessions after remember-me token reuse: %v", err)
+		}
+		recordAuthEvent(r, AuthEvent{UserID: token.UserID, Event: eventSessionRevoked, Detail: "remember_me_reused"})
+		clearRememberCookie(w)
+		return nil
+	}
+}
+
+// rememberedSession returns the live session the token points at, if any
+func rememberedSession(token *rememberToken) *Session {
+	sessionID, err := uuid.FromString(token.SessionID)
+	if err != nil {
+		return nil
+	}
+	session, valid := store.GetSession(sessionID)
+	if !valid {
+		return nil
+	}
+	return session
+}
+
+// rotateRememberToken starts a new session for a valid remember cookie and
+// replaces the cookie's validator. The token keeps its original expiry.
+func rotateRememberToken(w http.ResponseWriter, r *http.Request, token *rememberToken) *Session {
+	user, err := userRepo.GetByID(token.UserID)
+	if err != nil {
+		log.Printf("Error loading user for remember-me token: %v", err)
+		return nil
+	}
+
+	validator, validatorHash, err := generateToken()
+	if err != nil {
+		log.Printf("Error generating remember-me validator: %v", err)
+		return nil
+	}
+	session := store.CreateSession(user.ID, user.UserName, clientIP(r), r.UserAgent())
+	if session == nil {
+		return nil
+	}
+
+	// Only one of several racing requests wins the rotation
+	result, err := db.DB.Exec(
+		`UPDATE remember_tokens SET validator_hash = ?, previous_hash = validator_hash, rotated_at = ?, session_id = ?
+		WHERE selector = ? AND validator_hash = ?`,
+		validatorHash, time.Now().UTC(), session.ID.String(), token.Selector, token.ValidatorHash,
+	)
+	if err != nil {
+		log.Printf("Error rotating remember-me token: %v", err)
+		store.DeleteSession(session.ID)
+		return nil
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// A parallel request rotated it first; share the session it started
+		store.DeleteSession(session.ID)
+		if current, err := lookupRememberToken(token.Selector); err == nil {
+			return rememberedSession(current)
+		}
+		return nil
+	}
+
+	// The session the token used to point at is of no further use
+	if oldID, err := uuid.FromString(token.SessionID); err == nil {
+		store.DeleteSession(oldID)
+	}
+
+	setSessionCookie(w, session)
+	setRememberCookie(w, token.Selector, validator, token.ExpiresAt)
+	recordAuthEvent(r, AuthEvent{UserID: user.ID, Event: eventLogin, Method: methodRememberMe})
+	return session
+}
+
+// forgetRememberToken removes the browser's remember-me token, if any, and its cookie
+func forgetRememberToken(w http.ResponseWriter, r *http.Request) {
+	if selector, _, ok := parseRememberCookie(r); ok {
+		if _, err := db.DB.Exec(`DELETE FROM remember_tokens WHERE selector = ?`, selector); err != nil {
+			log.Printf("Error deleting remember-me token: %v", err)
+		}
+	}
+	clearRememberCookie(w)
+}
+
+// forgetSessionRememberTokens removes the remember-me token of a signed out session
+func forgetSessionRememberTokens(sessionID uuid.UUID) error {
+	_, err := db.DB.Exec(`DELETE FROM remember_tokens WHERE session_id = ?`, sessionID.String())
+	return err
+}
+
+// deleteExpiredRememberTokens purges tokens whose expiry has passed and
+// returns how many were removed
+func deleteExpiredRememberTokens() (int64, error) {
+	result, err := db.DB.Exec(`DELETE FROM remember_tokens WHERE expires_at < ?`, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
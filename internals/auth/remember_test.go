@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"forum/internals/config"
+)
+
+// useSessionSettings swaps in session settings for the length of a test
+func useSessionSettings(t *testing.T, settings config.Session) {
+	original := sessionSettings
+	sessionSettings = settings
+	t.Cleanup(func() { sessionSettings = original })
+}
+
+// responseCookie returns the named cookie set on the response, if any
+func responseCookie(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestSessionAbsoluteLifetime(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	useSessionSettings(t, config.Session{
+		IdleTimeout: config.Duration{Duration: time.Hour},
+		MaxLifetime: config.Duration{Duration: 2 * time.Hour},
+	})
+
+	session := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+	if got := time.Until(session.ExpiresAt); got > time.Hour || got < 59*time.Minute {
+		t.Errorf("Expected the idle timeout to set the first expiry, got %v", got)
+	}
+
+	// Activity keeps extending the session, but never past its lifetime
+	createdAt := time.Now().UTC().Add(-90 * time.Minute)
+	if _, err := testDB.Exec(`UPDATE sessions SET created_at = ? WHERE id = ?`, createdAt, session.ID.String()); err != nil {
+		t.Fatalf("Failed to age session: %v", err)
+	}
+	aged, ok := store.GetSession(session.ID)
+	if !ok {
+		t.Fatal("Session inside its lifetime should be valid")
+	}
+	store.ExtendSession(aged)
+	extended, _ := store.GetSession(session.ID)
+	if want := createdAt.Add(2 * time.Hour); !extended.ExpiresAt.Equal(want) {
+		t.Errorf("Expected expiry capped at %v, got %v", want, extended.ExpiresAt)
+	}
+
+	// A session past its lifetime is rejected even if its expiry says otherwise
+	_, err := testDB.Exec(`UPDATE sessions SET created_at = ?, expires_at = ? WHERE id = ?`,
+		time.Now().UTC().Add(-3*time.Hour), time.Now().UTC().Add(time.Hour), session.ID.String())
+	if err != nil {
+		t.Fatalf("Failed to age session: %v", err)
+	}
+	if _, ok := store.GetSession(session.ID); ok {
+		t.Error("Session past its absolute lifetime should be rejected")
+	}
+}
+
+func TestRotateSession(t *testing.T) {
+	setupAuthTestDB(t)
+	session := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+
+	rec := httptest.NewRecorder()
+	rotated := rotateSession(rec, session)
+	if rotated.ID == session.ID || rotated.CSRFToken == session.CSRFToken {
+		t.Fatal("Expected a new session ID and CSRF token")
+	}
+	if _, ok := store.GetSession(session.ID); ok {
+		t.Error("Old session ID should stop working")
+	}
+	stored, ok := store.GetSession(rotated.ID)
+	if !ok || !stored.CreatedAt.Equal(session.CreatedAt) {
+		t.Error("Rotated session should keep its creation time")
+	}
+	if cookie := responseCookie(rec, "session"); cookie == nil || cookie.Value != rotated.ID.String() {
+		t.Error("Expected the session cookie to carry the new ID")
+	}
+	if rec.Header().Get(csrfHeader) != rotated.CSRFToken {
+		t.Error("Expected the new CSRF token in the response header")
+	}
+}
+
+func TestRememberMe(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+
+	login := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/login", nil)
+	session := startSession(login, req, 1, "testuser", true)
+	if session == nil {
+		t.Fatal("Expected a session")
+	}
+	if cookie := responseCookie(login, "session"); cookie == nil || cookie.MaxAge != 0 {
+		t.Error("Session cookie should only last as long as the browser")
+	}
+	remember := responseCookie(login, rememberCookie)
+	if remember == nil || remember.MaxAge <= 0 {
+		t.Fatal("Expected a persistent remember-me cookie")
+	}
+
+	var stored string
+	testDB.QueryRow(`SELECT validator_hash FROM remember_tokens`).Scan(&stored)
+	if stored == "" || stored == remember.Value {
+		t.Error("Only a hash of the validator should be stored")
+	}
+
+	// After the session ends, the remember cookie signs the browser back in
+	store.DeleteSession(session.ID)
+	resume := func(cookie *http.Cookie) (*Session, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		return CheckIfLoggedIn(rec, req), rec
+	}
+	resumed, rec := resume(remember)
+	if resumed == nil || resumed.UserID != 1 {
+		t.Fatal("Expected the remember cookie to start a new session")
+	}
+	rotated := responseCookie(rec, rememberCookie)
+	if rotated == nil || rotated.Value == remember.Value {
+		t.Fatal("Expected the remember cookie to be rotated on use")
+	}
+	if cookie := responseCookie(rec, "session"); cookie == nil || cookie.Value != resumed.ID.String() {
+		t.Error("Expected a session cookie for the new session")
+	}
+
+	// A request racing the rotation with the old cookie shares the new session
+	if again, _ := resume(remember); again == nil || again.ID != resumed.ID {
+		t.Error("The previous cookie should still work during the grace period")
+	}
+
+	// Outside the grace period the old cookie means it was copied
+	if _, err := testDB.Exec(`UPDATE remember_tokens SET rotated_at = ?`, time.Now().UTC().Add(-time.Hour)); err != nil {
+		t.Fatalf("Failed to age rotation: %v", err)
+	}
+	if stolen, _ := resume(remember); stolen != nil {
+		t.Error("A reused remember cookie should be refused")
+	}
+	if _, ok := store.GetSession(resumed.ID); ok {
+		t.Error("Reuse of a remember cookie should sign out every session")
+	}
+	if again, _ := resume(rotated); again != nil {
+		t.Error("Reuse of a remember cookie should revoke every remember token")
+	}
+}
+
+func TestLogoutForgetsRememberToken(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+
+	login := httptest.NewRecorder()
+	session := startSession(login, httptest.NewRequest("POST", "/login", nil), 1, "testuser", true)
+	remember := responseCookie(login, rememberCookie)
+
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.Header.Set(csrfHeader, session.CSRFToken)
+	req.AddCookie(&http.Cookie{Name: "session", Value: session.ID.String()})
+	req.AddCookie(remember)
+	Middleware(http.HandlerFunc(Logout))(httptest.NewRecorder(), req)
+
+	var count int
+	testDB.QueryRow(`SELECT COUNT(*) FROM remember_tokens`).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected logout to delete the remember-me token, %d left", count)
+	}
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"forum/db"
+	"forum/internals/fails"
+	"forum/internals/mail"
+)
+
+// passwordResetTTL is how long an emailed reset link stays valid
+const passwordResetTTL = time.Hour
+
+// ForgotPassword shows the "forgot password" form and emails a reset link
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tmpl, err := template.ParseFiles("templates/forgot-password.html")
+		if err != nil {
+			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+		if err := tmpl.Execute(w, nil); err != nil {
+			log.Println("Template execution error:", err)
+			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		email := strings.TrimSpace(r.FormValue("email"))
+		if !isValidEmail(email) {
+			fails.JSONError(w, http.StatusBadRequest, "Invalid email format")
+			return
+		}
+
+		user, err := userRepo.GetByEmail(email)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Error looking up user for password reset: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to process request")
+			return
+		}
+
+		// Only send mail for known accounts, but answer the same either way so
+		// the form can't be used to find out which emails are registered
+		if user != nil {
+			if err := sendPasswordResetEmail(user); err != nil {
+				log.Printf("Error sending password reset email: %v", err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "success",
+			"message": "If an account exists for that email, a password reset link has been sent.",
+		})
+
+	default:
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+// sendPasswordResetEmail issues a reset token for the user and emails the link
+func sendPasswordResetEmail(user *User) error {
+	token, err := issueToken(user.ID, tokenPurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return fmt.Errorf("failed to issue reset token: %v", err)
+	}
+
+	link := baseURL + "/reset-password?token=" + url.QueryEscape(token)
+	return mail.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Forum password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nSomeone asked to reset the password for your Forum account.\n"+
+				"Open this link within %d minutes to choose a new password:\n\n%s\n\n"+
+				"If you didn't ask for this, you can ignore this email.\n",
+			user.UserName, int(passwordResetTTL.Minutes()), link,
+		),
+	})
+}
+
+// ResetPassword shows the reset form for a valid token and sets the new password
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		token := r.URL.Query().Get("token")
+		_, lookupErr := lookupToken(token, tokenPurposePasswordReset)
+		if lookupErr != nil && lookupErr != errInvalidToken {
+			log.Printf("Error checking reset token: %v", lookupErr)
+			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/reset-password.html")
+		if err != nil {
+			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+		data := struct {
+			Token string
+			Valid bool
+		}{
+			Token: token,
+			Valid: lookupErr == nil,
+		}
+		if err := tmpl.Execute(w, data); err != nil {
+			log.Println("Template execution error:", err)
+			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		token := r.FormValue("token")
+		password := r.FormValue("password")
+
+		// The policy compares the password with the username and email, so
+		// look the account up before using the token up
+		userID, err := lookupToken(token, tokenPurposePasswordReset)
+		if err == errInvalidToken {
+			fails.JSONError(w, http.StatusBadRequest, "This reset link is invalid or has expired")
+			return
+		} else if err != nil {
+			log.Printf("Error checking reset token: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to reset password")
+			return
+		}
+		user, err := userRepo.GetByID(userID)
+		if err != nil {
+			log.Printf("Error loading user for password reset: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to reset password")
+			return
+		}
+		if problems := policy.Check(password, user.UserName, user.Email); len(problems) > 0 {
+			fails.JSONError(w, http.StatusBadRequest, strings.Join(problems, ". "))
+			return
+		}
+
+		hashedPassword, err := hashPassword(password)
+		if err != nil {
+			fails.JSONError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		userID, err = consumeToken(token, tokenPurposePasswordReset)
+		if err == errInvalidToken {
+			fails.JSONError(w, http.StatusBadRequest, "This reset link is invalid or has expired")
+			return
+		} else if err != nil {
+			log.Printf("Error consuming reset token: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to reset password")
+			return
+		}
+
+		if _, err := db.DB.Exec("UPDATE users SET password = ? WHERE id = ?", hashedPassword, userID); err != nil {
+			log.Printf("Error updating password: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to reset password")
+			return
+		}
+
+		// Sign out every device in case the old password was compromised
+		if err := store.DeleteUserSessions(userID); err != nil {
+			log.Printf("Error revoking sessions after password reset: %v", err)
+		}
+		recordAuthEvent(r, AuthEvent{UserID: userID, Event: eventPasswordChanged, Detail: "reset"})
+		recordAuthEvent(r, AuthEvent{UserID: userID, Event: eventSessionRevoked, Detail: "all_devices"})
+		// This browser may have been signed in too; its cookies point nowhere now
+		clearSessionCookie(w)
+		clearRememberCookie(w)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "success",
+			"message": "Your password has been reset. You can now log in.",
+		})
+
+	default:
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+	}
+}
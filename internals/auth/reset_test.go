@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/internals/mail"
+)
+
+// useFileMailer routes outgoing mail to a temporary directory for the test
+func useFileMailer(t *testing.T) string {
+	dir := t.TempDir()
+	original := mail.Default
+	mail.Default = &mail.FileMailer{Dir: dir, From: "no-reply@forum.local"}
+	t.Cleanup(func() { mail.Default = original })
+	return dir
+}
+
+// tokenFromMail extracts the token query parameter from the only email sent
+func tokenFromMail(t *testing.T, dir string) string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected exactly one email, got %d (%v)", len(files), err)
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("Failed to read email: %v", err)
+	}
+	match := regexp.MustCompile(`token=([A-Za-z0-9_%-]+)`).FindStringSubmatch(string(content))
+	if match == nil {
+		t.Fatalf("No token link in email:\n%s", content)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("Failed to unescape token: %v", err)
+	}
+	return token
+}
+
+func postForm(handler http.HandlerFunc, target string, values url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	mailDir := useFileMailer(t)
+
+	existing := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+
+	rec := postForm(ForgotPassword, "/forgot-password", url.Values{"email": {"test1@example.com"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	token := tokenFromMail(t, mailDir)
+
+	// The token is stored hashed, never in plain text
+	var stored string
+	if err := testDB.QueryRow(`SELECT token_hash FROM auth_tokens WHERE user_id = 1`).Scan(&stored); err != nil {
+		t.Fatalf("Failed to read stored token: %v", err)
+	}
+	if stored == token || stored != hashToken(token) {
+		t.Error("Expected the token to be stored as its hash")
+	}
+
+	// Too short a password is rejected without using up the token
+	rec = postForm(ResetPassword, "/reset-password", url.Values{"token": {token}, "password": {"short"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for short password, got %d", rec.Code)
+	}
+
+	rec = postForm(ResetPassword, "/reset-password", url.Values{"token": {token}, "password": {"newpassword123"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var hashed string
+	if err := testDB.QueryRow(`SELECT password FROM users WHERE id = 1`).Scan(&hashed); err != nil {
+		t.Fatalf("Failed to read password: %v", err)
+	}
+	if match, _ := checkPassword(hashed, "newpassword123"); !match {
+		t.Error("Expected the new password to be stored")
+	}
+
+	if _, ok := store.GetSession(existing.ID); ok {
+		t.Error("Expected existing sessions to be revoked after a reset")
+	}
+	if cookie := responseCookie(rec, "session"); cookie == nil || cookie.MaxAge >= 0 {
+		t.Error("Expected the reset to clear this browser's session cookie")
+	}
+
+	// Tokens are single use
+	rec = postForm(ResetPassword, "/reset-password", url.Values{"token": {token}, "password": {"anotherpassword"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 when reusing a token, got %d", rec.Code)
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	setupAuthTestDB(t)
+	mailDir := useFileMailer(t)
+
+	rec := postForm(ForgotPassword, "/forgot-password", url.Values{"email": {"nobody@example.com"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the same response for unknown emails, got %d", rec.Code)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(mailDir, "*.eml"))
+	if len(files) != 0 {
+		t.Errorf("Expected no email for an unknown address, got %d", len(files))
+	}
+}
+
+func TestExpiredResetToken(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+
+	token, err := issueToken(1, tokenPurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatalf("issueToken returned error: %v", err)
+	}
+	_, err = testDB.Exec(`UPDATE auth_tokens SET expires_at = ?`, time.Now().UTC().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to expire token: %v", err)
+	}
+
+	if _, err := consumeToken(token, tokenPurposePasswordReset); err != errInvalidToken {
+		t.Errorf("Expected errInvalidToken for an expired token, got %v", err)
+	}
+}
+
+func TestIssueTokenInvalidatesPrevious(t *testing.T) {
+	setupAuthTestDB(t)
+
+	first, err := issueToken(1, tokenPurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatalf("issueToken returned error: %v", err)
+	}
+	second, err := issueToken(1, tokenPurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatalf("issueToken returned error: %v", err)
+	}
+
+	if _, err := lookupToken(first, tokenPurposePasswordReset); err != errInvalidToken {
+		t.Error("Expected the earlier token to be invalidated")
+	}
+	if userID, err := lookupToken(second, tokenPurposePasswordReset); err != nil || userID != 1 {
+		t.Errorf("Expected the latest token to be valid, got user %d, err %v", userID, err)
+	}
+}
+
+func TestResetPasswordPage(t *testing.T) {
+	setupAuthTestDB(t)
+	token, err := issueToken(1, tokenPurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatalf("issueToken returned error: %v", err)
+	}
+
+	// The page renders templates relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir("internals/auth")
+
+	tests := []struct {
+		name     string
+		token    string
+		wantForm bool
+	}{
+		{"Valid token", token, true},
+		{"Unknown token", "not-a-token", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ResetPassword(rec, httptest.NewRequest("GET", "/reset-password?token="+url.QueryEscape(tt.token), nil))
+			if hasForm := strings.Contains(rec.Body.String(), `id="resetForm"`); hasForm != tt.wantForm {
+				t.Errorf("Reset form shown = %v, want %v", hasForm, tt.wantForm)
+			}
+		})
+	}
+}
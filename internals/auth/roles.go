@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"forum/db"
+	"forum/internals/fails"
+)
+
+// Built-in roles, from least to most trusted. The roles table can hold more.
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Built-in permissions, granted to roles through role_permissions
+const (
+	PermModeratePosts    = "moderate_posts"
+	PermModerateComments = "moderate_comments"
+	PermManageUsers      = "manage_users"
+)
+
+// errUnknownRole is returned when assigning a role that isn't in the roles table
+var errUnknownRole = errors.New("unknown role")
+
+// HasPermission reports whether the role has been granted the permission
+func HasPermission(role, permission string) bool {
+	var granted bool
+	err := db.DB.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM role_permissions WHERE role = ? AND permission = ?)", role, permission,
+	).Scan(&granted)
+	if err != nil {
+		log.Printf("Error checking permission: %v", err)
+		return false
+	}
+	return granted
+}
+
+// roleAtLeast reports whether role ranks as high as minimum. Unknown roles
+// rank below everything.
+func roleAtLeast(role, minimum string) bool {
+	var atLeast sql.NullBool
+	err := db.DB.QueryRow(
+		"SELECT (SELECT rank FROM roles WHERE name = ?) >= (SELECT rank FROM roles WHERE name = ?)", role, minimum,
+	).Scan(&atLeast)
+	if err != nil {
+		log.Printf("Error comparing roles: %v", err)
+		return false
+	}
+	return atLeast.Valid && atLeast.Bool
+}
+
+// Can reports whether the session's user has the permission
+func (session *Session) Can(permission string) bool {
+	return HasPermission(session.Role, permission)
+}
+
+// HasRole reports whether the session's user has the role or a higher one
+func (session *Session) HasRole(role string) bool {
+	return roleAtLeast(session.Role, role)
+}
+
+// rejectForbidden answers a request the user isn't allowed to make: pages
+// get the error page, everything else a JSON error
+func rejectForbidden(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		fails.ErrorPageHandler(w, r, http.StatusForbidden)
+	} else {
+		fails.JSONError(w, http.StatusForbidden, "You don't have permission to do that")
+	}
+}
+
+// RequireRole rejects the request unless the signed in user has the role or
+// a higher one. It must run inside Middleware so the session is in the
+// request context.
+func RequireRole(role string, next http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := r.Context().Value(UserSessionKey).(*Session)
+		if !ok || session == nil {
+			fails.ErrorPageHandler(w, r, http.StatusUnauthorized)
+			return
+		}
+		if !session.HasRole(role) {
+			rejectForbidden(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission rejects the request unless the signed in user's role has
+// been granted the permission. It must run inside Middleware so the session
+// is in the request context.
+func RequirePermission(permission string, next http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := r.Context().Value(UserSessionKey).(*Session)
+		if !ok || session == nil {
+			fails.ErrorPageHandler(w, r, http.StatusUnauthorized)
+			return
+		}
+		if !session.Can(permission) {
+			rejectForbidden(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetUserRole gives the user with the username the role and signs them out
+// everywhere, so no session from before the change carries on under it. It
+// returns sql.ErrNoRows for an unknown user and errUnknownRole for an unknown
+// role.
+func SetUserRole(username, role string) error {
+	role = strings.ToLower(strings.TrimSpace(role))
+	var exists bool
+	if err := db.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM roles WHERE name = ?)", role).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return errUnknownRole
+	}
+
+	user, err := userRepo.GetByUsername(username)
+	if err != nil {
+		return err
+	}
+	if _, err := db.DB.Exec("UPDATE users SET role = ? WHERE id = ?", role, user.ID); err != nil {
+		return err
+	}
+	return store.DeleteUserSessions(user.ID)
+}
+
+// ChangeUserRole lets an administrator change another user's role
+func ChangeUserRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	if strings.EqualFold(username, session.UserName) {
+		// Keeps the last admin from locking everyone out by accident
+		fails.JSONError(w, http.StatusBadRequest, "You can't change your own role")
+		return
+	}
+
+	err := SetUserRole(username, r.FormValue("role"))
+	switch {
+	case err == sql.ErrNoRows:
+		fails.JSONError(w, http.StatusNotFound, "User not found")
+		return
+	case err == errUnknownRole:
+		fails.JSONError(w, http.StatusBadRequest, "Unknown role")
+		return
+	case err != nil:
+		log.Printf("Error changing user role: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to change role")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessionRole(t *testing.T) {
+	setupAuthTestDB(t)
+
+	session := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+	if session.Role != RoleMember {
+		t.Errorf("Expected new users to be members, got %q", session.Role)
+	}
+
+	if err := SetUserRole("TestUser", "Moderator"); err != nil {
+		t.Fatalf("SetUserRole returned error: %v", err)
+	}
+	// Sessions from before the change are signed out
+	if _, ok := store.GetSession(session.ID); ok {
+		t.Error("Expected the user's sessions to be revoked on a role change")
+	}
+	loaded := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+	if loaded.Role != RoleModerator {
+		t.Errorf("Expected moderator, got %q", loaded.Role)
+	}
+	if !loaded.Can(PermModeratePosts) || loaded.Can(PermManageUsers) {
+		t.Error("Moderators should moderate posts but not manage users")
+	}
+	if !loaded.HasRole(RoleMember) || !loaded.HasRole(RoleModerator) || loaded.HasRole(RoleAdmin) {
+		t.Error("Role ranks compared incorrectly")
+	}
+
+	if err := SetUserRole("testuser", "overlord"); err != errUnknownRole {
+		t.Errorf("Expected errUnknownRole, got %v", err)
+	}
+	if err := SetUserRole("nobody", RoleAdmin); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestRequireRoleAndPermission(t *testing.T) {
+	setupAuthTestDB(t)
+	if err := SetUserRole("testuser2", RoleAdmin); err != nil {
+		t.Fatalf("SetUserRole returned error: %v", err)
+	}
+	member := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+	admin := store.CreateSession(2, "testuser2", "127.0.0.2", "test-agent")
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		session  *Session
+		method   string
+		wantCode int
+		wantJSON bool
+	}{
+		{"Member below moderator", RequireRole(RoleModerator, ok), member, "GET", http.StatusForbidden, false},
+		{"Admin above moderator", RequireRole(RoleModerator, ok), admin, "GET", http.StatusOK, false},
+		{"Member lacks permission", RequirePermission(PermManageUsers, ok), member, "POST", http.StatusForbidden, true},
+		{"Admin has permission", RequirePermission(PermManageUsers, ok), admin, "POST", http.StatusOK, false},
+		{"No session", RequirePermission(PermManageUsers, ok), nil, "POST", http.StatusUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/admin", nil)
+			if tt.session != nil {
+				req = req.WithContext(context.WithValue(req.Context(), UserSessionKey, tt.session))
+			}
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("Expected %d, got %d", tt.wantCode, rec.Code)
+			}
+			isJSON := strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json")
+			if isJSON != tt.wantJSON {
+				t.Errorf("JSON response = %v, want %v", isJSON, tt.wantJSON)
+			}
+		})
+	}
+}
+
+func TestChangeUserRole(t *testing.T) {
+	setupAuthTestDB(t)
+	admin := &Session{UserID: 2, UserName: "testuser2", Role: RoleAdmin}
+	target := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+
+	change := func(username, role string) int {
+		body := strings.NewReader("username=" + username + "&role=" + role)
+		req := httptest.NewRequest("POST", "/admin/users/role", body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithValue(req.Context(), UserSessionKey, admin))
+		rec := httptest.NewRecorder()
+		ChangeUserRole(rec, req)
+		return rec.Code
+	}
+
+	if code := change("testuser", RoleModerator); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if user, _ := userRepo.GetByID(1); user.Role != RoleModerator {
+		t.Errorf("Expected moderator, got %q", user.Role)
+	}
+	if _, ok := store.GetSession(target.ID); ok {
+		t.Error("Expected the user to be signed out after their role changed")
+	}
+	if code := change("testuser2", RoleMember); code != http.StatusBadRequest {
+		t.Errorf("Changing your own role should be refused, got %d", code)
+	}
+	if code := change("testuser", "overlord"); code != http.StatusBadRequest {
+		t.Errorf("Unknown role should be refused, got %d", code)
+	}
+	if code := change("nobody", RoleMember); code != http.StatusNotFound {
+		t.Errorf("Unknown user should be 404, got %d", code)
+	}
+}
@@ -1,109 +1,287 @@
 package auth
 
 import (
-	"sync"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
 	"time"
 
+	"forum/db"
+
 	"github.com/gofrs/uuid"
 )
 
+// sessionExpiry is when a session created at createdAt expires if it is
+// used now: after the idle timeout, but never past the absolute lifetime
+func sessionExpiry(createdAt, now time.Time) time.Time {
+	expiry := now.Add(sessionSettings.IdleTimeout.Duration)
+	if limit := createdAt.Add(sessionSettings.MaxLifetime.Duration); expiry.After(limit) {
+		return limit
+	}
+	return expiry
+}
+
 // Session represents a user's active session
 type Session struct {
 	ID uuid.UUID
 
 	UserID int
 
+	// Role is the user's role, read fresh from users each time the session is loaded
+	Role string
+
 	CreatedAt time.Time
 
 	ExpiresAt time.Time
 
 	UserName     string
 	IPAddress    string
+	UserAgent    string
 	LastActivity time.Time
+
+	// CSRFToken must accompany every state-changing request made with the session
+	CSRFToken string
+
+	// APIToken is set when the request authenticated with an API token
+	// instead of a session cookie. Such sessions are never stored.
+	APIToken *APIToken
 }
 
-// active session
-type SessionStore struct {
-	mu       sync.RWMutex
-	sessions map[uuid.UUID]*Session
+// Handle returns a public identifier for the session. The session ID itself is
+// the login credential, so pages that list sessions refer to them by handle.
+func (session *Session) Handle() string {
+	sum := sha256.Sum256([]byte(session.ID.String()))
+	return hex.EncodeToString(sum[:8])
 }
 
+// SessionStore keeps active sessions in the sessions table so they survive
+// restarts and can be shared by several server processes.
+type SessionStore struct{}
+
 func NewSessionStore() *SessionStore {
-	return &SessionStore{
-		sessions: make(map[uuid.UUID]*Session),
-	}
+	return &SessionStore{}
 }
 
 // Create a new session
-func (store *SessionStore) CreateSession(userID int, username, ipAddress string) *Session {
-	store.mu.Lock()
-	defer store.mu.Unlock()
-
+func (store *SessionStore) CreateSession(userID int, username, ipAddress, userAgent string) *Session {
 	// generate a new UUID for the session
 	sessionid, err := uuid.NewV4()
 	if err != nil {
 		return nil
 	}
+	csrfToken, err := generateCSRFToken()
+	if err != nil {
+		return nil
+	}
+	var role string
+	if err := db.DB.QueryRow(`SELECT role FROM users WHERE id = ?`, userID).Scan(&role); err != nil {
+		log.Printf("Error loading role for session: %v", err)
+		return nil
+	}
+	now := time.Now().UTC()
 	session := &Session{
 		ID:           sessionid,
 		UserID:       userID,
+		Role:         role,
 		UserName:     username,
-		CreatedAt:    time.Now(),
-		ExpiresAt:    time.Now().Add(time.Hour * 24),
+		CreatedAt:    now,
+		ExpiresAt:    sessionExpiry(now, now),
 		IPAddress:    ipAddress,
-		LastActivity: time.Now(),
+		UserAgent:    userAgent,
+		LastActivity: now,
+		CSRFToken:    csrfToken,
+	}
+
+	_, err = db.DB.Exec(
+		`INSERT INTO sessions (id, user_id, ip_address, user_agent, created_at, last_activity, expires_at, csrf_token) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.ID.String(), session.UserID, session.IPAddress, session.UserAgent, session.CreatedAt, session.LastActivity, session.ExpiresAt, session.CSRFToken,
+	)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		return nil
 	}
-	store.sessions[sessionid] = session
 
 	return session
 }
 
 // retrieve a session
 func (store *SessionStore) GetSession(sessionID uuid.UUID) (*Session, bool) {
-	store.mu.RLock()
-	defer store.mu.RUnlock()
-
-	session, ok := store.sessions[sessionID]
-	if !ok {
+	var session Session
+	var id string
+	err := db.DB.QueryRow(`
+		SELECT s.id, s.user_id, u.username, u.role, s.ip_address, s.user_agent, s.created_at, s.last_activity, s.expires_at, s.csrf_token
+		FROM sessions s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.id = ?`, sessionID.String()).Scan(
+		&id, &session.UserID, &session.UserName, &session.Role, &session.IPAddress, &session.UserAgent,
+		&session.CreatedAt, &session.LastActivity, &session.ExpiresAt, &session.CSRFToken,
+	)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error fetching session: %v", err)
+		}
 		return nil, false
 	}
+	session.ID = sessionID
 
-	if time.Now().After(session.ExpiresAt) {
-		delete(store.sessions, sessionID)
+	// Sessions from before the absolute lifetime was introduced may have been
+	// extended past it
+	now := time.Now()
+	if now.After(session.ExpiresAt) || now.After(session.CreatedAt.Add(sessionSettings.MaxLifetime.Duration)) {
+		store.DeleteSession(sessionID)
 		return nil, false
 	}
 
-	return session, true
+	return &session, true
 }
 
 // delete a session
 func (store *SessionStore) DeleteSession(sessionID uuid.UUID) {
-	store.mu.Lock()
-	defer store.mu.Unlock()
+	_, err := db.DB.Exec(`DELETE FROM sessions WHERE id = ?`, sessionID.String())
+	if err != nil {
+		log.Printf("Error deleting session: %v", err)
+	}
+}
 
-	delete(store.sessions, sessionID)
+// Extend the expiration time of a session, up to its absolute lifetime
+func (store *SessionStore) ExtendSession(session *Session) {
+	now := time.Now().UTC()
+	_, err := db.DB.Exec(
+		`UPDATE sessions SET expires_at = ?, last_activity = ? WHERE id = ?`,
+		sessionExpiry(session.CreatedAt, now), now, session.ID.String(),
+	)
+	if err != nil {
+		log.Printf("Error extending session: %v", err)
+	}
 }
 
-// Extend the expiration time of a session
-func (store *SessionStore) ExtendSession(sessionID uuid.UUID) {
-	store.mu.Lock()
-	defer store.mu.Unlock()
+// RotateSession gives the session a new ID and CSRF token, keeping everything
+// else. The old ID stops working, so a session ID that leaked before a
+// privilege change is useless afterwards.
+func (store *SessionStore) RotateSession(session *Session) (*Session, error) {
+	newID, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	csrfToken, err := generateCSRFToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-	session, ok := store.sessions[sessionID]
-	if ok {
-		session.ExpiresAt = time.Now().Add(time.Hour * 24)
-		session.LastActivity = time.Now()
+	result, err := tx.Exec(
+		`UPDATE sessions SET id = ?, csrf_token = ? WHERE id = ?`,
+		newID.String(), csrfToken, session.ID.String(),
+	)
+	if err != nil {
+		return nil, err
 	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	// A remembered browser keeps its token pointing at the live session
+	if _, err := tx.Exec(
+		`UPDATE remember_tokens SET session_id = ? WHERE session_id = ?`,
+		newID.String(), session.ID.String(),
+	); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	rotated := *session
+	rotated.ID = newID
+	rotated.CSRFToken = csrfToken
+	return &rotated, nil
 }
 
-func (store *SessionStore) GetSessionByUserId(userid int) (*Session, bool) {
-	store.mu.RLock()
-	defer store.mu.RUnlock()
+// ListUserSessions returns the user's unexpired sessions, most recently used first
+func (store *SessionStore) ListUserSessions(userID int) ([]Session, error) {
+	rows, err := db.DB.Query(`
+		SELECT s.id, s.user_id, u.username, s.ip_address, s.user_agent, s.created_at, s.last_activity, s.expires_at
+		FROM sessions s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.user_id = ? AND s.expires_at > ?
+		ORDER BY s.last_activity DESC`, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	for _, session := range store.sessions {
-		if session.UserID == userid {
-			return session, true
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		var id string
+		if err := rows.Scan(
+			&id, &session.UserID, &session.UserName, &session.IPAddress, &session.UserAgent,
+			&session.CreatedAt, &session.LastActivity, &session.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		session.ID, err = uuid.FromString(id)
+		if err != nil {
+			log.Printf("Skipping session with malformed id: %v", err)
+			continue
 		}
+		sessions = append(sessions, session)
 	}
-	return nil, false
+	return sessions, rows.Err()
+}
+
+// DeleteUserSessions removes every session belonging to the user, along with
+// the remember-me tokens that could start new ones
+func (store *SessionStore) DeleteUserSessions(userID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM remember_tokens WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteExpiredSessions removes every session whose expiry has passed and
+// returns how many were removed.
+func (store *SessionStore) DeleteExpiredSessions() (int64, error) {
+	result, err := db.DB.Exec(`DELETE FROM sessions WHERE expires_at < ?`, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// StartSessionCleanup purges expired sessions in the background every interval.
+func StartSessionCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			removed, err := store.DeleteExpiredSessions()
+			if err != nil {
+				log.Printf("Error purging expired sessions: %v", err)
+			} else if removed > 0 {
+				log.Printf("Purged %d expired sessions", removed)
+			}
+			if _, err := pruneLoginAttempts(); err != nil {
+				log.Printf("Error pruning old login attempts: %v", err)
+			}
+			if _, err := deleteExpiredRememberTokens(); err != nil {
+				log.Printf("Error purging expired remember-me tokens: %v", err)
+			}
+			oauthStates.evictExpired()
+		}
+	}()
 }
@@ -1,15 +1,201 @@
 package auth
 
 import (
+	"database/sql"
 	"testing"
 	"time"
+
+	"forum/db"
+
+	_ "github.com/mattn/go-sqlite3"
 )
 
+// setupAuthTestDB creates an in-memory database with the tables used by sessions and account flows
+func setupAuthTestDB(t *testing.T) *sql.DB {
+	testDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	// Every connection to :memory: is a separate database, so keep just one
+	testDB.SetMaxOpenConns(1)
+
+	_, err = testDB.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL,
+			email TEXT NOT NULL UNIQUE,
+			password TEXT NOT NULL,
+			email_verified INTEGER NOT NULL DEFAULT 0,
+			role TEXT NOT NULL DEFAULT 'member',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX idx_users_email_nocase ON users(email COLLATE NOCASE);
+		CREATE INDEX idx_users_username_nocase ON users(username COLLATE NOCASE);
+
+		CREATE TABLE sessions (
+			id TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			ip_address TEXT,
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			last_activity DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			csrf_token TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+
+		CREATE TABLE auth_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			purpose TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME DEFAULT NULL,
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+
+		CREATE TABLE user_totp (
+			user_id INTEGER PRIMARY KEY,
+			secret TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 0,
+			last_used_step INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL
+		);
+
+		CREATE TABLE recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			used_at DATETIME DEFAULT NULL
+		);
+
+		CREATE TABLE login_challenges (
+			id_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			remember INTEGER NOT NULL DEFAULT 0,
+			redirect TEXT NOT NULL DEFAULT '',
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+
+		CREATE TABLE login_attempts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			scope TEXT NOT NULL,
+			key TEXT NOT NULL,
+			attempted_at DATETIME NOT NULL
+		);
+
+		CREATE TABLE login_lockouts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			scope TEXT NOT NULL,
+			key TEXT NOT NULL,
+			failures INTEGER NOT NULL,
+			locked_at DATETIME NOT NULL,
+			locked_until DATETIME NOT NULL
+		);
+
+		CREATE TABLE user_identities (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			email TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			UNIQUE (provider, subject),
+			UNIQUE (user_id, provider)
+		);
+
+		CREATE TABLE remember_tokens (
+			selector TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			session_id TEXT NOT NULL DEFAULT '',
+			validator_hash TEXT NOT NULL,
+			previous_hash TEXT NOT NULL DEFAULT '',
+			rotated_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+
+		CREATE TABLE api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			scopes TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			last_used_at DATETIME DEFAULT NULL
+		);
+
+		CREATE TABLE roles (
+			name TEXT PRIMARY KEY,
+			rank INTEGER UNIQUE NOT NULL,
+			description TEXT
+		);
+
+		CREATE TABLE permissions (
+			name TEXT PRIMARY KEY,
+			description TEXT
+		);
+
+		CREATE TABLE role_permissions (
+			role TEXT NOT NULL,
+			permission TEXT NOT NULL,
+			PRIMARY KEY (role, permission)
+		);
+
+		CREATE TABLE invites (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			code_hash TEXT UNIQUE NOT NULL,
+			created_by INTEGER NOT NULL,
+			max_uses INTEGER NOT NULL,
+			uses INTEGER NOT NULL DEFAULT 0,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+
+		CREATE TABLE auth_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			event TEXT NOT NULL,
+			method TEXT NOT NULL DEFAULT '',
+			provider TEXT NOT NULL DEFAULT '',
+			identifier TEXT NOT NULL DEFAULT '',
+			detail TEXT NOT NULL DEFAULT '',
+			ip_address TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		);
+
+		INSERT INTO users (id, username, email, password) VALUES
+			(1, 'testuser', 'test1@example.com', 'hashedpassword1'),
+			(2, 'testuser2', 'test2@example.com', 'hashedpassword2');
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create test tables: %v", err)
+	}
+
+	originalDB := db.DB
+	db.DB = testDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		testDB.Close()
+	})
+
+	if err := db.EnsureDefaultRoles(); err != nil {
+		t.Fatalf("Failed to create default roles: %v", err)
+	}
+
+	return testDB
+}
+
 func TestSessionStore(t *testing.T) {
+	testDB := setupAuthTestDB(t)
 	store := NewSessionStore()
 
 	// Test creating new session
-	session := store.CreateSession(1, "testuser", "127.0.0.1")
+	session := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
 	if session == nil {
 		t.Fatal("Expected session to be created")
 	}
@@ -20,26 +206,24 @@ func TestSessionStore(t *testing.T) {
 	// Test getting session
 	retrieved, ok := store.GetSession(session.ID)
 	if !ok {
-		t.Error("Failed to retrieve session")
+		t.Fatal("Failed to retrieve session")
 	}
 	if retrieved.ID != session.ID {
 		t.Error("Retrieved wrong session")
 	}
-
-	// Test getting session by user ID
-	retrieved, ok = store.GetSessionByUserId(1)
-	if !ok {
-		t.Error("Failed to retrieve session by user ID")
-	}
-	if retrieved.UserID != 1 {
-		t.Error("Retrieved wrong session by user ID")
+	if retrieved.UserName != "testuser" {
+		t.Errorf("Expected username testuser, got %s", retrieved.UserName)
 	}
 
 	// Test extending session
-	originalExpiry := session.ExpiresAt
+	originalExpiry := retrieved.ExpiresAt
 	time.Sleep(time.Millisecond)
-	store.ExtendSession(session.ID)
-	if session.ExpiresAt.Equal(originalExpiry) {
+	store.ExtendSession(session)
+	extended, ok := store.GetSession(session.ID)
+	if !ok {
+		t.Fatal("Failed to retrieve extended session")
+	}
+	if !extended.ExpiresAt.After(originalExpiry) {
 		t.Error("Session expiry not extended")
 	}
 
@@ -51,10 +235,104 @@ func TestSessionStore(t *testing.T) {
 	}
 
 	// Test expired session
-	session = store.CreateSession(2, "testuser2", "127.0.0.2")
-	session.ExpiresAt = time.Now().Add(-time.Hour) // Set to expired
+	session = store.CreateSession(2, "testuser2", "127.0.0.2", "test-agent")
+	_, err := testDB.Exec(`UPDATE sessions SET expires_at = ? WHERE id = ?`,
+		time.Now().UTC().Add(-time.Hour), session.ID.String())
+	if err != nil {
+		t.Fatalf("Failed to expire session: %v", err)
+	}
 	_, ok = store.GetSession(session.ID)
 	if ok {
 		t.Error("Expired session should not be retrievable")
 	}
 }
+
+func TestSessionsSurviveNewStore(t *testing.T) {
+	setupAuthTestDB(t)
+
+	session := NewSessionStore().CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+	if session == nil {
+		t.Fatal("Expected session to be created")
+	}
+
+	// A fresh store, as after a restart, still sees the session
+	if _, ok := NewSessionStore().GetSession(session.ID); !ok {
+		t.Error("Session should persist across store instances")
+	}
+}
+
+func TestDeleteExpiredSessions(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	store := NewSessionStore()
+
+	active := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+	expired := store.CreateSession(2, "testuser2", "127.0.0.2", "test-agent")
+	if active == nil || expired == nil {
+		t.Fatal("Expected sessions to be created")
+	}
+	_, err := testDB.Exec(`UPDATE sessions SET expires_at = ? WHERE id = ?`,
+		time.Now().UTC().Add(-time.Minute), expired.ID.String())
+	if err != nil {
+		t.Fatalf("Failed to expire session: %v", err)
+	}
+
+	removed, err := store.DeleteExpiredSessions()
+	if err != nil {
+		t.Fatalf("DeleteExpiredSessions returned error: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 expired session removed, got %d", removed)
+	}
+
+	var count int
+	if err := testDB.QueryRow(`SELECT COUNT(*) FROM sessions`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count sessions: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 remaining session, got %d", count)
+	}
+}
+
+func TestMultipleSessionsPerUser(t *testing.T) {
+	setupAuthTestDB(t)
+	store := NewSessionStore()
+
+	laptop := store.CreateSession(1, "testuser", "127.0.0.1", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+	phone := store.CreateSession(1, "testuser", "127.0.0.3", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0) Safari/604.1")
+	other := store.CreateSession(2, "testuser2", "127.0.0.2", "test-agent")
+	if laptop == nil || phone == nil || other == nil {
+		t.Fatal("Expected sessions to be created")
+	}
+
+	// Logging in on a second device keeps the first session valid
+	if _, ok := store.GetSession(laptop.ID); !ok {
+		t.Error("First session should still be valid after a second login")
+	}
+
+	sessions, err := store.ListUserSessions(1)
+	if err != nil {
+		t.Fatalf("ListUserSessions returned error: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions for user 1, got %d", len(sessions))
+	}
+	for _, session := range sessions {
+		if session.UserID != 1 {
+			t.Errorf("Listed session belongs to user %d", session.UserID)
+		}
+		if session.UserAgent == "" {
+			t.Error("Expected user agent to be recorded")
+		}
+	}
+
+	// Log out everywhere only touches the user's own sessions
+	if err := store.DeleteUserSessions(1); err != nil {
+		t.Fatalf("DeleteUserSessions returned error: %v", err)
+	}
+	if _, ok := store.GetSession(phone.ID); ok {
+		t.Error("Session should be removed after logging out everywhere")
+	}
+	if _, ok := store.GetSession(other.ID); !ok {
+		t.Error("Another user's session should not be removed")
+	}
+}
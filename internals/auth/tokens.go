@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"forum/db"
+)
+
+// Purposes for the single-use tokens kept in auth_tokens
+const (
+	tokenPurposePasswordReset = "password_reset"
+	tokenPurposeEmailVerify   = "email_verify"
+	tokenPurposeMagicLink     = "magic_link"
+)
+
+// errInvalidToken is returned for unknown, expired or already used tokens
+var errInvalidToken = errors.New("invalid or expired token")
+
+// generateToken returns a random URL-safe token and the hash stored in its place
+func generateToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken hashes a token for storage so a leaked database can't be used to log in
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueToken creates a single-use token for the user that expires after ttl.
+// Any earlier unused token for the same purpose is invalidated.
+func issueToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE auth_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL`,
+		now, userID, purpose,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO auth_tokens (user_id, purpose, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+		userID, purpose, tokenHash, now.Add(ttl), now,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
+// lookupToken returns the user a valid token belongs to without using it up
+func lookupToken(token, purpose string) (int, error) {
+	var userID int
+	err := db.DB.QueryRow(
+		`SELECT user_id FROM auth_tokens
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?`,
+		hashToken(token), purpose, time.Now().UTC(),
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, errInvalidToken
+	}
+	return userID, err
+}
+
+// consumeToken marks a valid token as used and returns the user it belongs to
+func consumeToken(token, purpose string) (int, error) {
+	now := time.Now().UTC()
+	var userID int
+	err := db.DB.QueryRow(
+		`UPDATE auth_tokens SET used_at = ?
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id`,
+		now, hashToken(token), purpose, now,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, errInvalidToken
+	}
+	return userID, err
+}
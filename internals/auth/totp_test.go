@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	// RFC 6238 appendix B uses the ASCII key "12345678901234567890" with SHA-1.
+	// The RFC lists 8-digit codes; ours are the last 6 digits of each.
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		at := time.Unix(tt.unix, 0)
+		step, ok := validateTOTP(secret, tt.code, at)
+		if !ok {
+			t.Errorf("Expected code %s to be valid at %d", tt.code, tt.unix)
+			continue
+		}
+		if step != totpStep(at) {
+			t.Errorf("Expected step %d, got %d", totpStep(at), step)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	now := time.Now()
+	code := hotp(key, uint64(totpStep(now)))
+
+	if _, ok := validateTOTP(secret, code, now.Add(totpPeriod)); !ok {
+		t.Error("Code from the previous period should be accepted")
+	}
+	if _, ok := validateTOTP(secret, code, now.Add(3*totpPeriod)); ok {
+		t.Error("Code from three periods ago should be rejected")
+	}
+	if _, ok := validateTOTP(secret, "12345", now); ok {
+		t.Error("Short code should be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := totpURI("JBSWY3DPEHPK3PXP", "test user")
+	for _, want := range []string{"otpauth://totp/Forum:test%20user?", "secret=JBSWY3DPEHPK3PXP", "issuer=Forum", "digits=6", "period=30"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("Expected %q in URI %s", want, uri)
+		}
+	}
+}
@@ -0,0 +1,600 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/db"
+	"forum/internals/fails"
+)
+
+const (
+	// loginChallengeTTL is how long a user has to enter their code after the password step
+	loginChallengeTTL = 5 * time.Minute
+	// maxChallengeAttempts is how many wrong codes end a login challenge
+	maxChallengeAttempts = 5
+	// recoveryCodeCount is how many recovery codes are handed out at a time
+	recoveryCodeCount = 10
+	// loginChallengeCookie holds the challenge between the password and code steps
+	loginChallengeCookie = "login_challenge"
+)
+
+// isTwoFactorEnabled reports whether the user has confirmed an authenticator app
+func isTwoFactorEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := db.DB.QueryRow("SELECT enabled FROM user_totp WHERE user_id = ?", userID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// verifyTOTPCode checks a code from the user's authenticator app. A code is
+// only accepted once, even while it is still inside its time window.
+func verifyTOTPCode(userID int, code string) (bool, error) {
+	var secret string
+	var lastUsedStep int64
+	err := db.DB.QueryRow(
+		"SELECT secret, last_used_step FROM user_totp WHERE user_id = ? AND enabled = 1", userID,
+	).Scan(&secret, &lastUsedStep)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	step, ok := validateTOTP(secret, code, time.Now())
+	if !ok || step <= lastUsedStep {
+		return false, nil
+	}
+
+	// Guard against two requests racing to use the same code
+	result, err := db.DB.Exec(
+		"UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?",
+		step, userID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes so codes can be typed loosely
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// generateRecoveryCodes replaces the user's recovery codes with a fresh set and
+// returns them. Only hashes are stored, so this is the only time they are shown.
+func generateRecoveryCodes(userID int) ([]string, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// replaceRecoveryCodes swaps the user's recovery codes for new ones inside
+// the transaction and returns them
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := generateTOTPSecret()
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(raw[:5] + "-" + raw[5:10])
+		if _, err := tx.Exec(
+			"INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, hashToken(normalizeRecoveryCode(code)),
+		); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// useRecoveryCode marks one of the user's unused recovery codes as used
+func useRecoveryCode(userID int, code string) (bool, error) {
+	result, err := db.DB.Exec(
+		"UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now().UTC(), userID, hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// remainingRecoveryCodes counts the user's unused recovery codes
+func remainingRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := db.DB.QueryRow(
+		"SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID,
+	).Scan(&count)
+	return count, err
+}
+
+// verifySecondFactor accepts either an authenticator code or a recovery code
+func verifySecondFactor(userID int, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		return verifyTOTPCode(userID, code)
+	}
+	return useRecoveryCode(userID, code)
+}
+
+// startLoginChallenge records that the user passed the password step and sets
+// the cookie the code step is checked against. Only the hash of the challenge
+// is stored, and it expires quickly.
+func startLoginChallenge(w http.ResponseWriter, userID int, remember bool, redirect string) error {
+	challenge, challengeHash, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err = db.DB.Exec(
+		"INSERT INTO login_challenges (id_hash, user_id, remember, redirect, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		challengeHash, userID, remember, redirect, now.Add(loginChallengeTTL), now,
+	)
+	if err != nil {
+		return err
+	}
+
+	cookie := newCookie(loginChallengeCookie, challenge, "/login", int(loginChallengeTTL.Seconds()))
+	// Lax, not Strict: an OAuth login reaches the code form at the end of the
+	// provider's cross-site redirect. The code itself is only posted same-site.
+	cookie.SameSite = http.SameSiteLaxMode
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// loginChallenge is a live challenge between the password and code steps
+type loginChallenge struct {
+	UserID int
+	Hash   string
+	// Remember is whether "remember me" was ticked at the password step
+	Remember bool
+	// Redirect is where to go once the code is accepted
+	Redirect string
+}
+
+// loginChallengeUser returns the challenge for the request's challenge
+// cookie, or errInvalidToken if there is no live challenge
+func loginChallengeUser(r *http.Request) (*loginChallenge, error) {
+	cookie, err := r.Cookie(loginChallengeCookie)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	challenge := loginChallenge{Hash: hashToken(cookie.Value)}
+	err = db.DB.QueryRow(
+		"SELECT user_id, remember, redirect FROM login_challenges WHERE id_hash = ? AND expires_at > ? AND attempts < ?",
+		challenge.Hash, time.Now().UTC(), maxChallengeAttempts,
+	).Scan(&challenge.UserID, &challenge.Remember, &challenge.Redirect)
+	if err == sql.ErrNoRows {
+		return nil, errInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// endLoginChallenge removes a challenge once it has been used or given up on
+func endLoginChallenge(w http.ResponseWriter, challengeHash string) {
+	if _, err := db.DB.Exec("DELETE FROM login_challenges WHERE id_hash = ?", challengeHash); err != nil {
+		log.Printf("Error deleting login challenge: %v", err)
+	}
+	http.SetCookie(w, newCookie(loginChallengeCookie, "", "/login", -1))
+}
+
+// LoginTwoFactor is the second login step for accounts with 2FA enabled
+func LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if _, err := loginChallengeUser(r); err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		tmpl, err := template.ParseFiles("templates/login-2fa.html")
+		if err != nil {
+			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+		if err := tmpl.Execute(w, nil); err != nil {
+			log.Println("Template execution error:", err)
+			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		challenge, err := loginChallengeUser(r)
+		if err == errInvalidToken {
+			fails.JSONError(w, http.StatusUnauthorized, "Your login has expired. Please log in again.")
+			return
+		} else if err != nil {
+			log.Printf("Error checking login challenge: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to verify code")
+			return
+		}
+
+		userID, challengeHash := challenge.UserID, challenge.Hash
+		ip := clientIP(r)
+		accountKey := accountLockoutKey(&User{ID: userID}, "")
+		if _, locked, err := loginLockedUntil(accountKey, ip); err != nil {
+			log.Printf("Error checking login lockout: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to verify code")
+			return
+		} else if locked {
+			recordAuthEvent(r, AuthEvent{UserID: userID, Event: eventLoginFailed, Method: methodTwoFactor, Detail: "locked_out"})
+			endLoginChallenge(w, challengeHash)
+			fails.JSONError(w, http.StatusTooManyRequests, "Too many failed login attempts. Please try again later.")
+			return
+		}
+
+		ok, err := verifySecondFactor(userID, r.FormValue("code"))
+		if err != nil {
+			log.Printf("Error verifying second factor: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to verify code")
+			return
+		}
+		if !ok {
+			// Wrong codes count against the account like wrong passwords, so
+			// starting a fresh challenge doesn't give unlimited guesses
+			if err := recordFailedLogin(accountKey, ip); err != nil {
+				log.Printf("Error recording failed login: %v", err)
+			}
+			recordAuthEvent(r, AuthEvent{UserID: userID, Event: eventLoginFailed, Method: methodTwoFactor, Detail: "wrong_code"})
+
+			var attempts int
+			err := db.DB.QueryRow(
+				"UPDATE login_challenges SET attempts = attempts + 1 WHERE id_hash = ? RETURNING attempts",
+				challengeHash,
+			).Scan(&attempts)
+			if err != nil {
+				log.Printf("Error recording failed code: %v", err)
+			}
+			if attempts >= maxChallengeAttempts {
+				endLoginChallenge(w, challengeHash)
+				fails.JSONError(w, http.StatusUnauthorized, "Too many incorrect codes. Please log in again.")
+				return
+			}
+			fails.JSONError(w, http.StatusUnauthorized, "Incorrect code")
+			return
+		}
+
+		endLoginChallenge(w, challengeHash)
+		if err := clearFailedLogins(accountKey); err != nil {
+			log.Printf("Error clearing failed logins: %v", err)
+		}
+
+		user, err := userRepo.GetByID(userID)
+		if err != nil {
+			log.Printf("Error loading user after 2FA: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to create session")
+			return
+		}
+		if session := startSession(w, r, user.ID, user.UserName, challenge.Remember); session == nil {
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to create session")
+			return
+		}
+		recordAuthEvent(r, AuthEvent{UserID: user.ID, Event: eventLogin, Method: methodTwoFactor})
+
+		redirect := challenge.Redirect
+		if redirect == "" {
+			redirect = "/"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":   "success",
+			"username": user.UserName,
+			"redirect": redirect,
+		})
+
+	default:
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+// ServeSecurity renders the account security page
+func ServeSecurity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.ErrorPageHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	enabled, err := isTwoFactorEnabled(session.UserID)
+	if err != nil {
+		log.Printf("Error checking 2FA status: %v", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	remaining, err := remainingRecoveryCodes(session.UserID)
+	if err != nil {
+		log.Printf("Error counting recovery codes: %v", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	identities, err := identityRows(session.UserID)
+	if err != nil {
+		log.Printf("Error listing linked logins: %v", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := listAPITokens(session.UserID)
+	if err != nil {
+		log.Printf("Error listing API tokens: %v", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	activity, err := recentAuthEvents(session.UserID)
+	if err != nil {
+		log.Printf("Error listing security activity: %v", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		PageData         PageData
+		TwoFactorEnabled bool
+		RecoveryCodes    int
+		Identities       []identityRow
+		IdentityNotice   struct{ Text, Type string }
+		APITokens        []APIToken
+		Activity         []AuthEvent
+	}{
+		PageData:         PageData{IsLoggedIn: true, UserName: session.UserName, CSRFToken: session.CSRFToken, Role: session.Role},
+		TwoFactorEnabled: enabled,
+		RecoveryCodes:    remaining,
+		Identities:       identities,
+		IdentityNotice:   identityNotices[r.URL.Query().Get("identity")],
+		APITokens:        tokens,
+		Activity:         activity,
+	}
+
+	tmpl, err := template.ParseFiles("templates/security.html")
+	if err != nil {
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Println("Template execution error:", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// SetupTwoFactor creates a new, not yet enabled, authenticator secret for the user
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	enabled, err := isTwoFactorEnabled(session.UserID)
+	if err != nil {
+		log.Printf("Error checking 2FA status: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to set up two-factor authentication")
+		return
+	}
+	if enabled {
+		fails.JSONError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to set up two-factor authentication")
+		return
+	}
+	_, err = db.DB.Exec(
+		`INSERT INTO user_totp (user_id, secret, enabled, created_at) VALUES (?, ?, 0, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, enabled = 0, last_used_step = 0, created_at = excluded.created_at`,
+		session.UserID, secret, time.Now().UTC(),
+	)
+	if err != nil {
+		log.Printf("Error saving TOTP secret: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to set up two-factor authentication")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret": secret,
+		"uri":    totpURI(secret, session.UserName),
+	})
+}
+
+// ConfirmTwoFactor enables 2FA once the user proves their app produces valid
+// codes, and hands out recovery codes
+func ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	var secret string
+	err := db.DB.QueryRow(
+		"SELECT secret FROM user_totp WHERE user_id = ? AND enabled = 0", session.UserID,
+	).Scan(&secret)
+	if err == sql.ErrNoRows {
+		fails.JSONError(w, http.StatusBadRequest, "Start two-factor setup first")
+		return
+	} else if err != nil {
+		log.Printf("Error loading TOTP secret: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+
+	step, valid := validateTOTP(secret, r.FormValue("code"), time.Now())
+	if !valid {
+		fails.JSONError(w, http.StatusBadRequest, "Incorrect code")
+		return
+	}
+
+	// 2FA only goes on together with its recovery codes, so a failure can't
+	// leave the user with a second factor and no way around losing it
+	codes, err := enableTwoFactor(session.UserID, step)
+	if err != nil {
+		log.Printf("Error enabling 2FA: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+	recordAuthEvent(r, AuthEvent{UserID: session.UserID, Event: eventTwoFactorEnabled})
+	rotateSession(w, session)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// enableTwoFactor turns on the user's confirmed TOTP secret and stores new
+// recovery codes in one transaction, returning the codes
+func enableTwoFactor(userID int, step int64) ([]string, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"UPDATE user_totp SET enabled = 1, last_used_step = ? WHERE user_id = ?", step, userID,
+	); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a current code
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	valid, err := verifyTOTPCode(session.UserID, r.FormValue("code"))
+	if err != nil {
+		log.Printf("Error verifying TOTP code: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+	if !valid {
+		fails.JSONError(w, http.StatusBadRequest, "Incorrect code")
+		return
+	}
+
+	codes, err := generateRecoveryCodes(session.UserID)
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "regenerated",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor turns 2FA off after checking an authenticator or recovery code
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	valid, err := verifySecondFactor(session.UserID, r.FormValue("code"))
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	if !valid {
+		fails.JSONError(w, http.StatusBadRequest, "Incorrect code")
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", session.UserID); err != nil {
+		log.Printf("Error disabling 2FA: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", session.UserID); err != nil {
+		log.Printf("Error deleting recovery codes: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	recordAuthEvent(r, AuthEvent{UserID: session.UserID, Event: eventTwoFactorDisabled})
+	rotateSession(w, session)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "disabled"})
+}
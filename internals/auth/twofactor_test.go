@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/db"
+)
+
+// enableTestTOTP turns on 2FA for the user and returns the secret
+func enableTestTOTP(t *testing.T, userID int) string {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+	_, err = db.DB.Exec(
+		"INSERT INTO user_totp (user_id, secret, enabled, created_at) VALUES (?, ?, 1, ?)",
+		userID, secret, time.Now().UTC(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to enable 2FA: %v", err)
+	}
+	return secret
+}
+
+// currentTOTPCode returns the code an authenticator app would show right now
+func currentTOTPCode(secret string) string {
+	key, _ := totpEncoding.DecodeString(secret)
+	return hotp(key, uint64(totpStep(time.Now())))
+}
+
+// challengeCookie runs the password step's challenge creation and returns its cookie
+func challengeCookie(t *testing.T, userID int) *http.Cookie {
+	rec := httptest.NewRecorder()
+	if err := startLoginChallenge(rec, userID, false, ""); err != nil {
+		t.Fatalf("startLoginChallenge returned error: %v", err)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == loginChallengeCookie {
+			return cookie
+		}
+	}
+	t.Fatal("Expected a login challenge cookie")
+	return nil
+}
+
+func postTwoFactorCode(cookie *http.Cookie, code string) *httptest.ResponseRecorder {
+	body := url.Values{"code": {code}}.Encode()
+	req := httptest.NewRequest(http.MethodPost, "/login/2fa", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	LoginTwoFactor(rec, req)
+	return rec
+}
+
+func hasSessionCookie(rec *httptest.ResponseRecorder) bool {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "session" && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLoginTwoFactor(t *testing.T) {
+	setupAuthTestDB(t)
+	secret := enableTestTOTP(t, 1)
+
+	// No challenge means the password step was skipped
+	if rec := postTwoFactorCode(nil, currentTOTPCode(secret)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a challenge, got %d", rec.Code)
+	}
+
+	cookie := challengeCookie(t, 1)
+	if rec := postTwoFactorCode(cookie, "000000"); rec.Code != http.StatusUnauthorized || hasSessionCookie(rec) {
+		t.Errorf("Wrong code should not log in, got %d", rec.Code)
+	}
+
+	code := currentTOTPCode(secret)
+	rec := postTwoFactorCode(cookie, code)
+	if rec.Code != http.StatusOK || !hasSessionCookie(rec) {
+		t.Fatalf("Expected valid code to log in, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The challenge and the code are both single use
+	if rec := postTwoFactorCode(cookie, code); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected used challenge to be rejected, got %d", rec.Code)
+	}
+	replay := challengeCookie(t, 1)
+	if rec := postTwoFactorCode(replay, code); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected reused code to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestLoginChallengeAttemptLimit(t *testing.T) {
+	setupAuthTestDB(t)
+	secret := enableTestTOTP(t, 1)
+	cookie := challengeCookie(t, 1)
+
+	for i := 0; i < maxChallengeAttempts; i++ {
+		postTwoFactorCode(cookie, "000000")
+	}
+
+	// Even the right code is refused once the challenge is used up
+	if rec := postTwoFactorCode(cookie, currentTOTPCode(secret)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected challenge to be locked after %d attempts, got %d", maxChallengeAttempts, rec.Code)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	setupAuthTestDB(t)
+	enableTestTOTP(t, 1)
+
+	codes, err := generateRecoveryCodes(1)
+	if err != nil {
+		t.Fatalf("generateRecoveryCodes returned error: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("Expected %d codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	// Codes are accepted loosely typed, but only once
+	cookie := challengeCookie(t, 1)
+	if rec := postTwoFactorCode(cookie, strings.ToUpper(codes[0])); rec.Code != http.StatusOK {
+		t.Fatalf("Expected recovery code to log in, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ok, _ := useRecoveryCode(1, codes[0]); ok {
+		t.Error("Recovery code should only work once")
+	}
+	if ok, _ := useRecoveryCode(2, codes[1]); ok {
+		t.Error("Recovery code should not work for another user")
+	}
+
+	remaining, err := remainingRecoveryCodes(1)
+	if err != nil || remaining != recoveryCodeCount-1 {
+		t.Errorf("Expected %d remaining codes, got %d (%v)", recoveryCodeCount-1, remaining, err)
+	}
+}
+
+func TestEnableTwoFactorNeedsRecoveryCodes(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	secret := enableTestTOTP(t, 1)
+	testDB.Exec("UPDATE user_totp SET enabled = 0 WHERE user_id = 1")
+
+	// Without anywhere to keep the recovery codes, 2FA must stay off
+	testDB.Exec("ALTER TABLE recovery_codes RENAME TO recovery_codes_gone")
+	if _, err := enableTwoFactor(1, totpStep(time.Now())); err == nil {
+		t.Fatal("Expected enableTwoFactor to fail")
+	}
+	var enabled bool
+	testDB.QueryRow("SELECT enabled FROM user_totp WHERE user_id = 1").Scan(&enabled)
+	if enabled {
+		t.Fatal("Expected 2FA to stay off when the recovery codes can't be stored")
+	}
+
+	testDB.Exec("ALTER TABLE recovery_codes_gone RENAME TO recovery_codes")
+	codes, err := enableTwoFactor(1, totpStep(time.Now()))
+	if err != nil || len(codes) != recoveryCodeCount {
+		t.Fatalf("Expected 2FA on with %d codes, got %d (%v)", recoveryCodeCount, len(codes), err)
+	}
+	if valid, _ := verifyTOTPCode(1, currentTOTPCode(secret)); valid {
+		t.Error("Expected the code used to confirm setup not to work again")
+	}
+}
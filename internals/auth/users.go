@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"forum/db"
+)
+
+// UserRepository looks users up with indexed queries instead of scanning the
+// whole table. Emails and usernames are matched case-insensitively; lookups
+// return sql.ErrNoRows when there is no such user.
+type UserRepository struct{}
+
+func NewUserRepository() *UserRepository {
+	return &UserRepository{}
+}
+
+var userRepo = NewUserRepository()
+
+// errUserExists is returned when signing up with a taken email or username
+var errUserExists = errors.New("user already exists")
+
+const userColumns = "id, username, email, password, role, created_at"
+
+func scanUser(row *sql.Row) (*User, error) {
+	var user User
+	if err := row.Scan(&user.ID, &user.UserName, &user.Email, &user.Password, &user.Role, &user.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetByID looks up a user by id
+func (repo *UserRepository) GetByID(id int) (*User, error) {
+	return scanUser(db.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+// GetByEmail looks up a user by email address. An exact match wins over one
+// that only differs in case, for databases that already hold both.
+func (repo *UserRepository) GetByEmail(email string) (*User, error) {
+	email = strings.TrimSpace(email)
+	return scanUser(db.DB.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE email = ? COLLATE NOCASE ORDER BY email = ? DESC LIMIT 1",
+		email, email,
+	))
+}
+
+// GetByUsername looks up a user by username
+func (repo *UserRepository) GetByUsername(username string) (*User, error) {
+	username = strings.TrimSpace(username)
+	return scanUser(db.DB.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE username = ? COLLATE NOCASE ORDER BY username = ? DESC LIMIT 1",
+		username, username,
+	))
+}
+
+// GetByIdentifier looks up a user by whatever they typed into the login form,
+// which can be either their email or their username
+func (repo *UserRepository) GetByIdentifier(identifier string) (*User, error) {
+	if strings.Contains(identifier, "@") {
+		return repo.GetByEmail(identifier)
+	}
+	return repo.GetByUsername(identifier)
+}
+
+// Exists reports whether the email or the username is already taken
+func (repo *UserRepository) Exists(email, username string) (bool, error) {
+	var exists bool
+	err := db.DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM users WHERE email = ? COLLATE NOCASE)
+			OR EXISTS (SELECT 1 FROM users WHERE username = ? COLLATE NOCASE)`,
+		strings.TrimSpace(email), strings.TrimSpace(username),
+	).Scan(&exists)
+	return exists, err
+}
+
+// Create inserts the user and fills in its id and creation time
+func (repo *UserRepository) Create(user *User) error {
+	user.CreatedAt = time.Now()
+	result, err := db.DB.Exec(
+		"INSERT INTO users (username, email, password, created_at) VALUES (?, ?, ?, ?)",
+		user.UserName, user.Email, user.Password, user.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	user.ID = int(id)
+	return nil
+}
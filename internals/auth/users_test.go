@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestUserRepositoryLookups(t *testing.T) {
+	setupAuthTestDB(t)
+
+	tests := []struct {
+		name       string
+		lookup     func() (*User, error)
+		expectedID int
+	}{
+		{"By id", func() (*User, error) { return userRepo.GetByID(2) }, 2},
+		{"By email", func() (*User, error) { return userRepo.GetByEmail("test1@example.com") }, 1},
+		{"By email ignoring case", func() (*User, error) { return userRepo.GetByEmail("TEST1@Example.com") }, 1},
+		{"By username ignoring case", func() (*User, error) { return userRepo.GetByUsername("TestUser2") }, 2},
+		{"By identifier with email", func() (*User, error) { return userRepo.GetByIdentifier("test2@example.com") }, 2},
+		{"By identifier with username", func() (*User, error) { return userRepo.GetByIdentifier("testuser") }, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := tt.lookup()
+			if err != nil {
+				t.Fatalf("Lookup returned error: %v", err)
+			}
+			if user.ID != tt.expectedID {
+				t.Errorf("Expected user %d, got %d", tt.expectedID, user.ID)
+			}
+		})
+	}
+
+	if _, err := userRepo.GetByUsername("nobody"); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for unknown user, got %v", err)
+	}
+}
+
+func TestUserRepositoryPrefersExactMatch(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+
+	// Older databases can hold usernames that only differ in case
+	if _, err := testDB.Exec(`INSERT INTO users (id, username, email, password) VALUES (3, 'TestUser', 'test3@example.com', 'x')`); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	for name, expectedID := range map[string]int{"testuser": 1, "TestUser": 3} {
+		user, err := userRepo.GetByUsername(name)
+		if err != nil {
+			t.Fatalf("GetByUsername returned error: %v", err)
+		}
+		if user.ID != expectedID {
+			t.Errorf("Expected %q to find user %d, got %d", name, expectedID, user.ID)
+		}
+	}
+}
+
+func TestUserRepositoryCreate(t *testing.T) {
+	setupAuthTestDB(t)
+
+	user := User{UserName: "newuser", Email: "new@example.com", Password: "hash"}
+	if err := userRepo.Create(&user); err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+	if user.ID == 0 || user.CreatedAt.IsZero() {
+		t.Errorf("Expected id and creation time to be set, got %+v", user)
+	}
+
+	for _, tt := range []struct {
+		email, username string
+		taken           bool
+	}{
+		{"NEW@example.com", "someoneelse", true},
+		{"other@example.com", "NewUser", true},
+		{"other@example.com", "someoneelse", false},
+	} {
+		taken, err := userRepo.Exists(tt.email, tt.username)
+		if err != nil {
+			t.Fatalf("Exists returned error: %v", err)
+		}
+		if taken != tt.taken {
+			t.Errorf("Exists(%q, %q) = %v, expected %v", tt.email, tt.username, taken, tt.taken)
+		}
+	}
+}
+
+func TestUserLookupUsesIndex(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+
+	rows, err := testDB.Query("EXPLAIN QUERY PLAN SELECT "+userColumns+" FROM users WHERE email = ? COLLATE NOCASE", "x")
+	if err != nil {
+		t.Fatalf("Failed to explain query: %v", err)
+	}
+	defer rows.Close()
+
+	var plan strings.Builder
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			t.Fatalf("Failed to scan plan: %v", err)
+		}
+		plan.WriteString(detail + "\n")
+	}
+	if !strings.Contains(plan.String(), "idx_users_email_nocase") {
+		t.Errorf("Expected the email lookup to use the NOCASE index, plan:\n%s", plan.String())
+	}
+}
@@ -1,10 +1,12 @@
 package auth
 
 import (
+	"net"
+	"net/http"
+	"net/url"
 	"regexp"
+	"strings"
 	"unicode"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 func isValidEmail(email string) bool {
@@ -41,15 +43,42 @@ func isValidUsername(username string) bool {
 	return containsLetter
 }
 
-func encryptPassword(password string) (string, error) {
-	bcryptPassword, error := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if error != nil {
-		return "", error
+// clientIP returns the remote address of the request without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// localRedirect returns target if it is a path on this site, and "" for
+// anything that could send the user elsewhere, such as "//evil.example",
+// "/\evil.example" or "https://evil.example"
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.ContainsAny(target, "\\\r\n\t") {
+		return ""
 	}
-	return string(bcryptPassword), nil
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return ""
+	}
+	return target
 }
 
-func decryptPassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+// afterLogin is where to send the user once they are logged in: the
+// request's next parameter if it is a path on this site, else the home page
+func afterLogin(r *http.Request) string {
+	if target := localRedirect(r.FormValue("next")); target != "" {
+		return target
+	}
+	return "/"
+}
+
+// loginURL is the login page, sending the user on to target afterwards
+func loginURL(target string) string {
+	if target = localRedirect(target); target == "" || target == "/" {
+		return "/login"
+	}
+	return "/login?next=" + url.QueryEscape(target)
 }
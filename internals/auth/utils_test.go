@@ -50,26 +50,68 @@ func TestIsValidUsername(t *testing.T) {
 	}
 }
 
-func TestPasswordEncryptionAndDecryption(t *testing.T) {
+func TestPasswordHashing(t *testing.T) {
 	password := "myPassword123"
 
-	// Test encryption
-	hashedPassword, err := encryptPassword(password)
+	// Test hashing
+	hashedPassword, err := hashPassword(password)
 	if err != nil {
-		t.Errorf("encryptPassword() error = %v", err)
+		t.Errorf("hashPassword() error = %v", err)
 		return
 	}
 	if hashedPassword == password {
-		t.Error("encryptPassword() failed: hashed password equals original password")
+		t.Error("hashPassword() failed: hashed password equals original password")
 	}
 
-	// Test decryption
-	if !decryptPassword(hashedPassword, password) {
-		t.Error("decryptPassword() failed: could not verify password")
+	// Test verification
+	if match, _ := checkPassword(hashedPassword, password); !match {
+		t.Error("checkPassword() failed: could not verify password")
 	}
 
 	// Test wrong password
-	if decryptPassword(hashedPassword, "wrongPassword") {
-		t.Error("decryptPassword() failed: verified wrong password")
+	if match, _ := checkPassword(hashedPassword, "wrongPassword"); match {
+		t.Error("checkPassword() failed: verified wrong password")
+	}
+}
+
+func TestLocalRedirect(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"Path", "/post/3", "/post/3"},
+		{"Path with query", "/?category=go#top", "/?category=go#top"},
+		{"Empty", "", ""},
+		{"Relative", "post/3", ""},
+		{"Absolute URL", "https://evil.example/", ""},
+		{"Protocol-relative", "//evil.example/", ""},
+		{"Backslash", "/\\evil.example/", ""},
+		{"Header injection", "/\r\nSet-Cookie: a=b", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := localRedirect(tt.target); got != tt.want {
+				t.Errorf("localRedirect(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginURL(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/view-post?id=4", "/login?next=%2Fview-post%3Fid%3D4"},
+		{"/", "/login"},
+		{"https://evil.example/", "/login"},
+	}
+
+	for _, tt := range tests {
+		if got := loginURL(tt.target); got != tt.want {
+			t.Errorf("loginURL(%q) = %q, want %q", tt.target, got, tt.want)
+		}
 	}
 }
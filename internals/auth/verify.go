@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"forum/db"
+	"forum/internals/fails"
+	"forum/internals/mail"
+)
+
+// emailVerificationTTL is how long an emailed verification link stays valid
+const emailVerificationTTL = 48 * time.Hour
+
+// Actions that can be limited to accounts with a verified email
+const (
+	ActionPost    = "post"
+	ActionComment = "comment"
+)
+
+// VerificationPolicy controls what accounts with an unverified email may do.
+// Unverified users can always browse and read.
+type VerificationPolicy struct {
+	RequireForPosts    bool
+	RequireForComments bool
+}
+
+var verificationPolicy = VerificationPolicy{
+	RequireForPosts:    true,
+	RequireForComments: true,
+}
+
+// SetVerificationPolicy replaces the policy for unverified accounts
+func SetVerificationPolicy(policy VerificationPolicy) {
+	verificationPolicy = policy
+}
+
+// requiresVerification reports whether the policy limits the action to verified accounts
+func (policy VerificationPolicy) requiresVerification(action string) bool {
+	switch action {
+	case ActionPost:
+		return policy.RequireForPosts
+	case ActionComment:
+		return policy.RequireForComments
+	}
+	return false
+}
+
+// IsEmailVerified reports whether the user has confirmed their email address
+func IsEmailVerified(userID int) bool {
+	var verified bool
+	err := db.DB.QueryRow("SELECT email_verified FROM users WHERE id = ?", userID).Scan(&verified)
+	if err != nil {
+		log.Printf("Error checking email verification: %v", err)
+		return false
+	}
+	return verified
+}
+
+// markEmailVerified flags the user's email address as confirmed
+func markEmailVerified(userID int) error {
+	_, err := db.DB.Exec("UPDATE users SET email_verified = 1 WHERE id = ?", userID)
+	return err
+}
+
+// RequireVerified rejects the request when the verification policy limits the
+// action to verified accounts and the signed in user hasn't verified yet.
+// It must run inside Middleware so the session is in the request context.
+func RequireVerified(action string, next http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := r.Context().Value(UserSessionKey).(*Session)
+		if !ok || session == nil {
+			fails.ErrorPageHandler(w, r, http.StatusUnauthorized)
+			return
+		}
+
+		if verificationPolicy.requiresVerification(action) && !IsEmailVerified(session.UserID) {
+			if r.Method == http.MethodGet {
+				fails.ErrorPageHandler(w, r, http.StatusForbidden)
+			} else {
+				fails.JSONError(w, http.StatusForbidden, "Please verify your email address first")
+			}
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sendVerificationEmail issues a verification token for the user and emails the link
+func sendVerificationEmail(user *User) error {
+	token, err := issueToken(user.ID, tokenPurposeEmailVerify, emailVerificationTTL)
+	if err != nil {
+		return fmt.Errorf("failed to issue verification token: %v", err)
+	}
+
+	link := baseURL + "/verify-email?token=" + url.QueryEscape(token)
+	return mail.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Forum email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nWelcome to the Forum! Please confirm your email address by opening this link:\n\n%s\n\n"+
+				"The link is valid for %d hours. If you didn't sign up, you can ignore this email.\n",
+			user.UserName, link, int(emailVerificationTTL.Hours()),
+		),
+	})
+}
+
+// VerifyEmail confirms the email address a verification link was sent to
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	verified, err := confirmEmail(r.URL.Query().Get("token"))
+	if err != nil {
+		log.Printf("Error confirming email: %v", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/verify-email.html")
+	if err != nil {
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.Execute(w, struct{ Verified bool }{verified}); err != nil {
+		log.Println("Template execution error:", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// confirmEmail uses up a verification token and marks its user verified.
+// It reports false for unknown, expired or already used tokens.
+func confirmEmail(token string) (bool, error) {
+	userID, err := consumeToken(token, tokenPurposeEmailVerify)
+	if err == errInvalidToken {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := markEmailVerified(userID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ResendVerification emails a fresh verification link to the signed in user
+func ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := r.Context().Value(UserSessionKey).(*Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "User not logged in")
+		return
+	}
+
+	if IsEmailVerified(session.UserID) {
+		fails.JSONError(w, http.StatusConflict, "Your email address is already verified")
+		return
+	}
+
+	user, err := userRepo.GetByID(session.UserID)
+	if err != nil {
+		log.Printf("Error loading user for verification: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+
+	if err := sendVerificationEmail(user); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "A new verification link has been sent to " + user.Email,
+	})
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEmailVerificationFlow(t *testing.T) {
+	testDB := setupAuthTestDB(t)
+	mailDir := useFileMailer(t)
+
+	user, err := userRepo.GetByID(1)
+	if err != nil {
+		t.Fatalf("Failed to load user: %v", err)
+	}
+	if IsEmailVerified(user.ID) {
+		t.Fatal("New users should start unverified")
+	}
+
+	if err := sendVerificationEmail(user); err != nil {
+		t.Fatalf("sendVerificationEmail returned error: %v", err)
+	}
+	token := tokenFromMail(t, mailDir)
+
+	verified, err := confirmEmail(token)
+	if err != nil {
+		t.Fatalf("confirmEmail returned error: %v", err)
+	}
+	if !verified || !IsEmailVerified(user.ID) {
+		t.Fatal("Expected email to be verified")
+	}
+
+	// The link only works once
+	if verified, _ := confirmEmail(token); verified {
+		t.Error("Verification token should be single use")
+	}
+
+	// Expired links are rejected
+	user2, _ := userRepo.GetByID(2)
+	expired, err := issueToken(user2.ID, tokenPurposeEmailVerify, emailVerificationTTL)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	if _, err := testDB.Exec(`UPDATE auth_tokens SET expires_at = ? WHERE user_id = 2`,
+		time.Now().UTC().Add(-time.Minute)); err != nil {
+		t.Fatalf("Failed to expire token: %v", err)
+	}
+	if verified, _ := confirmEmail(expired); verified || IsEmailVerified(user2.ID) {
+		t.Error("Expired verification token should be rejected")
+	}
+}
+
+func TestRequireVerified(t *testing.T) {
+	setupAuthTestDB(t)
+	if err := markEmailVerified(1); err != nil {
+		t.Fatalf("Failed to verify user: %v", err)
+	}
+	verifiedSession := store.CreateSession(1, "testuser", "127.0.0.1", "test-agent")
+	unverifiedSession := store.CreateSession(2, "testuser2", "127.0.0.2", "test-agent")
+
+	original := verificationPolicy
+	t.Cleanup(func() { SetVerificationPolicy(original) })
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		policy         VerificationPolicy
+		action         string
+		session        *Session
+		expectedStatus int
+	}{
+		{"Verified user can post", VerificationPolicy{RequireForPosts: true}, ActionPost, verifiedSession, http.StatusOK},
+		{"Unverified user cannot post", VerificationPolicy{RequireForPosts: true}, ActionPost, unverifiedSession, http.StatusForbidden},
+		{"Unverified user can comment when allowed", VerificationPolicy{RequireForPosts: true}, ActionComment, unverifiedSession, http.StatusOK},
+		{"Unverified user cannot comment when required", VerificationPolicy{RequireForComments: true}, ActionComment, unverifiedSession, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetVerificationPolicy(tt.policy)
+
+			req := httptest.NewRequest(http.MethodPost, "/create-post", nil)
+			req = req.WithContext(context.WithValue(req.Context(), UserSessionKey, tt.session))
+			rec := httptest.NewRecorder()
+
+			RequireVerified(tt.action, next)(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
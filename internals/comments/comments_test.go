@@ -32,6 +32,12 @@ func TestGetPostComments(t *testing.T) {
 			username TEXT NOT NULL
 		);
 
+		CREATE TABLE posts (
+			id INTEGER PRIMARY KEY,
+			deleted_at DATETIME DEFAULT NULL,
+			publish_at DATETIME DEFAULT NULL
+		);
+
 	CREATE TABLE comments (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,     
 		post_id INTEGER NOT NULL,                 
@@ -39,6 +45,8 @@ func TestGetPostComments(t *testing.T) {
 		parent_id INTEGER DEFAULT NULL,                
 		content TEXT NOT NULL,                    
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		deleted_at DATETIME DEFAULT NULL,
+		deleted_by INTEGER DEFAULT NULL,
 		FOREIGN KEY (post_id) REFERENCES posts(id),
 		FOREIGN KEY (user_id) REFERENCES users(id)
 		FOREIGN KEY (parent_id) REFERENCES comments(id)
@@ -65,6 +73,8 @@ func TestGetPostComments(t *testing.T) {
 			(2, 'user2'),
 			(3, 'user3');
 
+		INSERT INTO posts (id) VALUES (123);
+
 		INSERT INTO comments (id, post_id, user_id, parent_id, content, created_at) VALUES
 			(1, '123', 1, NULL, 'Root comment 1', '2024-01-28 10:00:00'),
 			(2, '123', 2, 1, 'Reply to root 1', '2024-01-28 10:01:00'),
@@ -160,7 +170,7 @@ func TestGetPostComments(t *testing.T) {
 	// Run tests
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			comments, err := getPostComments(tt.postID, tt.userID)
+			comments, err := getPostComments(tt.postID, tt.userID, false)
 			if err != nil {
 				t.Fatalf("getPostComments returned unexpected error: %v", err)
 			}
@@ -235,6 +245,12 @@ func TestGetComments(t *testing.T) {
 			id INTEGER PRIMARY KEY,
 			username TEXT NOT NULL
 		);
+
+		CREATE TABLE posts (
+			id INTEGER PRIMARY KEY,
+			deleted_at DATETIME DEFAULT NULL,
+			publish_at DATETIME DEFAULT NULL
+		);
 	
 		CREATE TABLE comments (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,     
@@ -243,6 +259,8 @@ func TestGetComments(t *testing.T) {
 			parent_id INTEGER DEFAULT NULL,                
 			content TEXT NOT NULL,                    
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			deleted_at DATETIME DEFAULT NULL,
+			deleted_by INTEGER DEFAULT NULL,
 			FOREIGN KEY (post_id) REFERENCES posts(id),
 			FOREIGN KEY (user_id) REFERENCES users(id)
 			FOREIGN KEY (parent_id) REFERENCES comments(id)
@@ -278,6 +296,8 @@ func TestGetComments(t *testing.T) {
 
 		// Insert test comments
 		_, err = db.Exec(`
+			INSERT INTO posts (id) VALUES (1), (2);
+
 			INSERT INTO comments (id, post_id, user_id, parent_id, content, created_at)
 			VALUES 
 				(1, 1, 1, NULL, 'First comment', CURRENT_TIMESTAMP),
@@ -527,3 +547,165 @@ func TestReactToComment(t *testing.T) {
 		})
 	}
 }
+
+// setupThreadDB creates post 1 with a thread: 1 <- 2 <- 3, and 4 on its own.
+// User 1 wrote 1 and 3, user 2 wrote 2 and 4; user 3 moderates comments.
+func setupThreadDB(t *testing.T) *sql.DB {
+	testDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	testDB.SetMaxOpenConns(1)
+
+	_, err = testDB.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, username TEXT NOT NULL);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, deleted_at DATETIME DEFAULT NULL, publish_at DATETIME DEFAULT NULL);
+		CREATE TABLE comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			post_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			parent_id INTEGER DEFAULT NULL,
+			content TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			deleted_at DATETIME DEFAULT NULL,
+			deleted_by INTEGER DEFAULT NULL
+		);
+		CREATE TABLE comment_reactions (
+			comment_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			reaction_type TEXT NOT NULL,
+			UNIQUE (comment_id, user_id)
+		);
+		CREATE TABLE role_permissions (role TEXT NOT NULL, permission TEXT NOT NULL);
+
+		INSERT INTO users (id, username) VALUES (1, 'user1'), (2, 'user2'), (3, 'moderator');
+		INSERT INTO role_permissions (role, permission) VALUES ('moderator', 'moderate_comments');
+		INSERT INTO posts (id) VALUES (1);
+		INSERT INTO comments (id, post_id, user_id, parent_id, content) VALUES
+			(1, 1, 1, NULL, 'Root'),
+			(2, 1, 2, 1, 'Reply'),
+			(3, 1, 1, 2, 'Reply to the reply'),
+			(4, 1, 2, NULL, 'Alone');
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	originalDB := db.DB
+	db.DB = testDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		testDB.Close()
+	})
+	return testDB
+}
+
+func deleteCommentAs(userID int, role string, commentID int64) int {
+	body, _ := json.Marshal(deleteCommentInput{CommentID: commentID})
+	req := httptest.NewRequest(http.MethodPost, "/comments/delete", bytes.NewReader(body))
+	session := &auth.Session{UserID: userID, Role: role}
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserSessionKey, session))
+	rec := httptest.NewRecorder()
+	DeleteComment(rec, req)
+	return rec.Code
+}
+
+func TestDeleteComment(t *testing.T) {
+	setupThreadDB(t)
+
+	tests := []struct {
+		name      string
+		userID    int
+		role      string
+		commentID int64
+		want      int
+	}{
+		{"Someone else's", 1, "member", 2, http.StatusForbidden},
+		{"Unknown", 1, "member", 99, http.StatusNotFound},
+		{"Own", 2, "member", 2, http.StatusOK},
+		{"Already deleted", 2, "member", 2, http.StatusNotFound},
+		{"Moderator", 3, "moderator", 1, http.StatusOK},
+		{"Moderator again", 3, "moderator", 4, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deleteCommentAs(tt.userID, tt.role, tt.commentID); got != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, got)
+			}
+		})
+	}
+
+	// 1 and 2 hold up the live reply 3 as placeholders; 4 has nothing left
+	comments, err := getPostComments("1", 1, false)
+	if err != nil {
+		t.Fatalf("getPostComments returned error: %v", err)
+	}
+	if len(comments) != 1 {
+		t.Fatalf("Expected one thread, got %d", len(comments))
+	}
+	root := comments[0]
+	if !root.Deleted || root.Content != deletedPlaceholder || root.Username != deletedPlaceholder || root.CanDelete {
+		t.Errorf("Expected comment 1 to be a placeholder, got %+v", root)
+	}
+	if len(root.Children) != 1 || !root.Children[0].Deleted || len(root.Children[0].Children) != 1 {
+		t.Fatalf("Expected the thread to stay intact, got %+v", root)
+	}
+	if reply := root.Children[0].Children[0]; reply.Deleted || reply.Content != "Reply to the reply" || !reply.CanDelete {
+		t.Errorf("Expected comment 3 as written and deletable by its author, got %+v", reply)
+	}
+
+	// Once the last reply goes, so does the thread
+	deleteCommentAs(1, "member", 3)
+	if comments, _ := getPostComments("1", 1, false); len(comments) != 0 {
+		t.Errorf("Expected no comments left, got %+v", comments)
+	}
+}
+
+func TestCreateCommentOnDeletedPost(t *testing.T) {
+	testDB := setupThreadDB(t)
+	testDB.Exec(`UPDATE posts SET deleted_at = CURRENT_TIMESTAMP`)
+
+	body, _ := json.Marshal(commentInput{PostID: 1, Content: "Too late"})
+	req := httptest.NewRequest(http.MethodPost, "/comments/create", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserSessionKey, &auth.Session{UserID: 1}))
+	rec := httptest.NewRecorder()
+	CreateComment(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rec.Code)
+	}
+	if comments, _ := getPostComments("1", 1, true); len(comments) != 0 {
+		t.Errorf("Expected the comments of a deleted post to be hidden, got %d", len(comments))
+	}
+}
+
+func TestReactToHiddenComment(t *testing.T) {
+	testDB := setupThreadDB(t)
+	// Comment 1 stays in the thread as "[deleted]" because of its replies
+	deleteCommentAs(1, "member", 1)
+
+	react := func(commentID int64) int {
+		body, _ := json.Marshal(reactToCommentInput{CommentID: commentID, ReactionType: "LIKE"})
+		req := httptest.NewRequest(http.MethodPost, "/comments/react", bytes.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserSessionKey, &auth.Session{UserID: 2}))
+		rec := httptest.NewRecorder()
+		ReactToComment(rec, req)
+		return rec.Code
+	}
+
+	if code := react(1); code != http.StatusNotFound {
+		t.Errorf("Expected a deleted comment to refuse reactions, got %d", code)
+	}
+	if code := react(3); code != http.StatusOK {
+		t.Errorf("Expected a live comment to take reactions, got %d", code)
+	}
+	testDB.Exec(`UPDATE posts SET deleted_at = CURRENT_TIMESTAMP`)
+	if code := react(4); code != http.StatusNotFound {
+		t.Errorf("Expected a comment under a deleted post to refuse reactions, got %d", code)
+	}
+
+	var count int
+	testDB.QueryRow(`SELECT COUNT(*) FROM comment_reactions WHERE comment_id != 3`).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected no reactions on hidden comments, got %d", count)
+	}
+}
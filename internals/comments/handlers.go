@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"forum/db"
 	"forum/internals/auth"
@@ -47,6 +48,18 @@ func ReactToComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Comments in the trash, or under a post in the trash, take no reactions
+	var visible bool
+	if err := db.DB.QueryRow(QueryCommentVisible, input.CommentID).Scan(&visible); err != nil {
+		log.Println(err.Error())
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if !visible {
+		fails.ErrorPageHandler(w, r, http.StatusNotFound)
+		return
+	}
+
 	// Check the current reaction for the user and comment
 	var currentReaction string
 	err := db.DB.QueryRow(QueryCheckReaction, input.CommentID, session.UserID).Scan(&currentReaction)
@@ -135,8 +148,15 @@ func CreateComment(w http.ResponseWriter, r *http.Request) {
 	var createdAt string
 
 	// Execute the query and scan the result into the id and createdAt variables
-	err := db.DB.QueryRow(QueryCreateComment, input.PostID, input.ParentID, input.Content, session.UserID).Scan(&id, &createdAt)
-	if err != nil {
+	err := db.DB.QueryRow(QueryCreateComment, input.PostID, input.ParentID, input.Content, session.UserID, input.PostID).Scan(&id, &createdAt)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "failure",
+			"message": "Post not found",
+		})
+		return
+	} else if err != nil {
 		log.Println(err.Error())
 		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
 		return
@@ -181,15 +201,17 @@ func GetComments(w http.ResponseWriter, r *http.Request) {
 
 	// Get the current logged-in user from the session
 	var userID int64
+	var moderator bool
 	session := auth.CheckIfLoggedIn(w, r)
 	if session != nil {
 		userID = int64(session.UserID)
+		moderator = session.Can(auth.PermModerateComments)
 	} else {
 		userID = 0
 	}
 
 	// Fetch comments from the database
-	comments, err := getPostComments(postIDStr, userID)
+	comments, err := getPostComments(postIDStr, userID, moderator)
 	if err != nil {
 		log.Println("Error fetching comments:", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -207,3 +229,47 @@ func GetComments(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"status": "failure", "message": "Internal server error"}`, http.StatusInternalServerError)
 	}
 }
+
+// DeleteComment moves a comment to the trash. Its author can delete it, and
+// so can moderators; replies to it stay, under a placeholder.
+func DeleteComment(w http.ResponseWriter, r *http.Request) {
+	if !validateMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	// Retrieve the session from the request context
+	session, ok := validateSession(w, r)
+	if !ok {
+		return // validateSession already handles the response
+	}
+
+	var input deleteCommentInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.CommentID == 0 {
+		fails.JSONError(w, http.StatusBadRequest, "Invalid comment ID")
+		return
+	}
+
+	var authorID int64
+	err := db.DB.QueryRow(`SELECT user_id FROM comments WHERE id = ? AND deleted_at IS NULL`, input.CommentID).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		fails.JSONError(w, http.StatusNotFound, "Comment not found")
+		return
+	} else if err != nil {
+		log.Println(err.Error())
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to delete comment")
+		return
+	}
+	if authorID != int64(session.UserID) && !session.Can(auth.PermModerateComments) {
+		fails.JSONError(w, http.StatusForbidden, "You can only delete your own comments")
+		return
+	}
+
+	if _, err := db.DB.Exec(queryTrashComment, time.Now().UTC(), session.UserID, input.CommentID); err != nil {
+		log.Println(err.Error())
+		fails.JSONError(w, http.StatusInternalServerError, "Failed to delete comment")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
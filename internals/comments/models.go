@@ -13,6 +13,18 @@ type Comment struct {
 	Dislikes     int        `json:"dislikes"`
 	UserReaction *string    `json:"user_reaction,omitempty"`
 	Children     []*Comment `json:"children,omitempty"`
+	// Deleted comments are in the trash and only kept for their replies;
+	// their author and content are replaced with deletedPlaceholder
+	Deleted bool `json:"deleted"`
+	// CanDelete is set on the comments the user may move to the trash
+	CanDelete bool `json:"can_delete"`
+}
+
+// deletedPlaceholder stands in for the author and content of deleted comments
+const deletedPlaceholder = "[deleted]"
+
+type deleteCommentInput struct {
+	CommentID int64 `json:"comment_id"`
 }
 
 // CommentInput represents the input for creating a comment
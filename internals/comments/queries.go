@@ -18,26 +18,46 @@ const (
         VALUES (?, ?, ?)
         ON CONFLICT (comment_id, user_id) DO UPDATE SET reaction_type = ?`
 
-	// Query to insert a new comment
+	// Query to check that a comment can take reactions: it and its post are
+	// out of the trash, and the post is published
+	QueryCommentVisible = `
+        SELECT EXISTS (
+            SELECT 1 FROM comments c JOIN posts p ON p.id = c.post_id
+            WHERE c.id = ? AND c.deleted_at IS NULL AND p.deleted_at IS NULL AND p.publish_at IS NULL
+        )`
+
+	// Query to insert a new comment, unless the post is gone, in the trash
+	// or not published yet
 	QueryCreateComment = `
         INSERT INTO comments (post_id, parent_id, content, user_id)
-        VALUES (?, ?, ?, ?)
+        SELECT ?, ?, ?, ?
+        WHERE EXISTS (SELECT 1 FROM posts WHERE id = ? AND deleted_at IS NULL AND publish_at IS NULL)
         RETURNING id, created_at`
 
 	// Query to get comments for a post (example, adjust as needed)
 	QueryGetComments = `
         SELECT id, post_id, parent_id, content, user_id, created_at
         FROM comments
-        WHERE post_id = ?`
+        WHERE post_id = ? AND deleted_at IS NULL`
+
+	// Comments in the trash are returned too, flagged by deleted, so that
+	// the replies to them keep their place in the thread
 	queryGetPostComments = `
         SELECT 
             c.id, c.post_id, c.user_id, c.parent_id, c.content, c.created_at,
+            c.deleted_at IS NOT NULL AS deleted,
             u.username,
             (SELECT COUNT(*) FROM comment_reactions WHERE comment_id = c.id AND reaction_type = 'LIKE') as likes,
             (SELECT COUNT(*) FROM comment_reactions WHERE comment_id = c.id AND reaction_type = 'DISLIKE') as dislikes,
             (SELECT reaction_type FROM comment_reactions WHERE comment_id = c.id AND user_id = ?) as user_reaction
         FROM comments c
         JOIN users u ON c.user_id = u.id
-        WHERE c.post_id = ?
+        JOIN posts p ON c.post_id = p.id
+        WHERE c.post_id = ? AND p.deleted_at IS NULL
         ORDER BY c.created_at DESC`
+
+	// Query to move a comment to the trash
+	queryTrashComment = `
+        UPDATE comments SET deleted_at = ?, deleted_by = ?
+        WHERE id = ? AND deleted_at IS NULL`
 )
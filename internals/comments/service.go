@@ -9,8 +9,10 @@ import (
 	"forum/internals/fails"
 )
 
-// getPostComments retrieves all comments for a post
-func getPostComments(postID string, userID int64) ([]Comment, error) {
+// getPostComments retrieves all comments for a post. Deleted comments stay
+// in the tree as placeholders while any reply below them is still visible.
+// moderator marks every comment as one the user can delete.
+func getPostComments(postID string, userID int64, moderator bool) ([]Comment, error) {
 	// Query the database for comments
 	rows, err := db.DB.Query(queryGetPostComments, userID, postID)
 	if err != nil {
@@ -30,12 +32,20 @@ func getPostComments(postID string, userID int64) ([]Comment, error) {
 
 		err := rows.Scan(
 			&comment.ID, &comment.PostID, &comment.UserID, &ParentID,
-			&comment.Content, &comment.CreatedAt, &comment.Username,
+			&comment.Content, &comment.CreatedAt, &comment.Deleted, &comment.Username,
 			&comment.Likes, &comment.Dislikes, &UserReaction,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if comment.Deleted {
+			comment.UserID = 0
+			comment.Username = deletedPlaceholder
+			comment.Content = deletedPlaceholder
+			comment.Likes, comment.Dislikes, UserReaction = 0, 0, nil
+		} else {
+			comment.CanDelete = userID != 0 && (comment.UserID == userID || moderator)
+		}
 
 		// Add the parent ID and user reaction
 		comment.ParentID = ParentID
@@ -60,6 +70,9 @@ func getPostComments(postID string, userID int64) ([]Comment, error) {
 		}
 	}
 
+	// Drop deleted comments that no longer hold up a visible reply
+	rootComments = pruneDeleted(rootComments)
+
 	// Convert root comments from []*Comment to []Comment for the return type
 	finalRootComments := make([]Comment, len(rootComments))
 	for i, root := range rootComments {
@@ -69,6 +82,18 @@ func getPostComments(postID string, userID int64) ([]Comment, error) {
 	return finalRootComments, nil
 }
 
+// pruneDeleted removes deleted comments whose replies are all deleted too
+func pruneDeleted(comments []*Comment) []*Comment {
+	var kept []*Comment
+	for _, comment := range comments {
+		comment.Children = pruneDeleted(comment.Children)
+		if !comment.Deleted || len(comment.Children) > 0 {
+			kept = append(kept, comment)
+		}
+	}
+	return kept
+}
+
 // validateMethod checks if the request method is the expected one
 func validateMethod(w http.ResponseWriter, r *http.Request, method string) bool {
 	if r.Method != method {
@@ -0,0 +1,476 @@
+// Package config loads the server configuration from a JSON file and
+// FORUM_* environment variables, with environment variables taking precedence.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds everything that differs between deployments
+type Config struct {
+	ListenAddr   string       `json:"listen_addr"`
+	DBPath       string       `json:"db_path"`
+	UploadDir    string       `json:"upload_dir"`
+	BaseURL      string       `json:"base_url"`
+	Cookie       Cookie       `json:"cookie"`
+	Session      Session      `json:"session"`
+	Password     Password     `json:"password"`
+	MagicLink    MagicLink    `json:"magic_link"`
+	Registration Registration `json:"registration"`
+	Verification Verification `json:"verification"`
+	Trash        Trash        `json:"trash"`
+	Mail         Mail         `json:"mail"`
+	OAuth        OAuth        `json:"oauth"`
+}
+
+// Cookie controls the attributes of the cookies the server sets
+type Cookie struct {
+	Secure   bool   `json:"secure"`
+	SameSite string `json:"same_site"` // "lax", "strict" or "none"
+	Domain   string `json:"domain"`
+}
+
+// SameSiteMode converts the configured SameSite value for http.Cookie
+func (c Cookie) SameSiteMode() http.SameSite {
+	switch strings.ToLower(c.SameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// Session controls how long logins last. A session ends after IdleTimeout
+// without activity, and after MaxLifetime no matter what. RememberMe is how
+// long the "remember me" login option keeps a browser logged in; zero turns
+// the option off.
+type Session struct {
+	IdleTimeout Duration `json:"idle_timeout"`
+	MaxLifetime Duration `json:"max_lifetime"`
+	RememberMe  Duration `json:"remember_me"`
+}
+
+// Password controls how passwords are hashed and which ones are accepted.
+// Hasher is used for new hashes; hashes made by the other one are still
+// verified and replaced the next time their user logs in.
+type Password struct {
+	Hasher     string `json:"hasher"` // "argon2id" or "bcrypt"
+	Argon2     Argon2 `json:"argon2"`
+	BcryptCost int    `json:"bcrypt_cost"`
+	MinLength  int    `json:"min_length"`
+	// BlocklistFile lists common or breached passwords, one per line, that
+	// can't be chosen. A missing file only disables the check.
+	BlocklistFile string `json:"blocklist_file"`
+}
+
+// MagicLink controls logging in with a single-use link sent by email
+// instead of a password. TTL is how long each link stays valid.
+type MagicLink struct {
+	Enabled bool     `json:"enabled"`
+	TTL     Duration `json:"ttl"`
+}
+
+// Registration decides who can create an account, with a password or
+// through an OAuth provider. Mode is one of:
+//
+//   - "open": anyone can sign up
+//   - "invite": signing up needs an invite code made by an admin
+//   - "domain": only emails at one of AllowedDomains can sign up
+//   - "closed": nobody can sign up; existing accounts still log in
+type Registration struct {
+	Mode           string   `json:"mode"`
+	AllowedDomains []string `json:"allowed_domains"`
+}
+
+// Verification decides what accounts whose email isn't verified yet may do.
+// They can always browse and read.
+type Verification struct {
+	RequireForPosts    bool `json:"require_for_posts"`
+	RequireForComments bool `json:"require_for_comments"`
+}
+
+// Trash controls deleted posts and comments. They can be restored for
+// Retention, after which they are erased for good.
+type Trash struct {
+	Retention Duration `json:"retention"`
+}
+
+// Argon2 holds the Argon2id parameters. Memory is in KiB.
+type Argon2 struct {
+	Memory      uint32 `json:"memory"`
+	Iterations  uint32 `json:"iterations"`
+	Parallelism uint8  `json:"parallelism"`
+}
+
+// Duration is a time.Duration written as a string such as "24h" or "30m"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("durations must be strings like \"24h\": %v", err)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Mail configures outgoing email
+type Mail struct {
+	SMTPAddr string `json:"smtp_addr"`
+	From     string `json:"from"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// OAuth holds the credentials for each login provider. Google, GitHub and
+// Facebook are built in; Providers adds any other OAuth2 or OpenID Connect
+// provider by name.
+type OAuth struct {
+	Google    Provider   `json:"google"`
+	GitHub    Provider   `json:"github"`
+	Facebook  Provider   `json:"facebook"`
+	Providers []Provider `json:"providers"`
+}
+
+// Provider holds one OAuth application's credentials. RedirectURI defaults
+// to the provider's callback under BaseURL.
+//
+// The remaining fields only apply to entries in OAuth.Providers. Either
+// Issuer is set, and the endpoints come from its OpenID Connect discovery
+// document, or AuthURL, TokenURL and UserInfoURL are all given. Fields maps
+// the user info response onto "subject", "email", "email_verified", "name"
+// and "username"; missing entries use the standard OpenID Connect claim names.
+type Provider struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RedirectURI  string `json:"redirect_uri"`
+
+	Name        string            `json:"name"`
+	DisplayName string            `json:"display_name"`
+	Issuer      string            `json:"issuer"`
+	AuthURL     string            `json:"auth_url"`
+	TokenURL    string            `json:"token_url"`
+	UserInfoURL string            `json:"userinfo_url"`
+	Scopes      []string          `json:"scopes"`
+	Fields      map[string]string `json:"fields"`
+}
+
+// builtinProviders are the provider names with their own settings block
+var builtinProviders = []string{"google", "github", "facebook"}
+
+var providerName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Enabled reports whether the provider has credentials. Providers without
+// them are left out of the login page and their routes return 404.
+func (p Provider) Enabled() bool {
+	return p.ClientID != "" && p.ClientSecret != ""
+}
+
+// Default returns the configuration used for anything not set elsewhere,
+// which is suitable for local development
+func Default() *Config {
+	return &Config{
+		ListenAddr: ":8080",
+		DBPath:     "./forum.db",
+		UploadDir:  "static/images",
+		BaseURL:    "http://localhost:8080",
+		Cookie:     Cookie{SameSite: "lax"},
+		Session: Session{
+			IdleTimeout: Duration{24 * time.Hour},
+			MaxLifetime: Duration{7 * 24 * time.Hour},
+			RememberMe:  Duration{30 * 24 * time.Hour},
+		},
+		Password: Password{
+			Hasher:        "argon2id",
+			Argon2:        Argon2{Memory: 19 * 1024, Iterations: 2, Parallelism: 1},
+			BcryptCost:    10,
+			MinLength:     8,
+			BlocklistFile: "data/common-passwords.txt",
+		},
+		MagicLink:    MagicLink{Enabled: true, TTL: Duration{15 * time.Minute}},
+		Registration: Registration{Mode: "open"},
+		Verification: Verification{RequireForPosts: true, RequireForComments: true},
+		Trash:        Trash{Retention: Duration{30 * 24 * time.Hour}},
+		Mail: Mail{
+			SMTPAddr: "localhost:1025",
+			From:     "no-reply@forum.local",
+		},
+	}
+}
+
+// Load reads the config file at path over the defaults and then applies
+// environment overrides. A missing file is only an error when required is set.
+func Load(path string, required bool) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(data, cfg); err != nil {
+				return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+			}
+		case errors.Is(err, os.ErrNotExist) && !required:
+		default:
+			return nil, fmt.Errorf("failed to read config file: %v", err)
+		}
+	}
+
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	for name, provider := range map[string]*Provider{
+		"google":   &cfg.OAuth.Google,
+		"github":   &cfg.OAuth.GitHub,
+		"facebook": &cfg.OAuth.Facebook,
+	} {
+		if provider.RedirectURI == "" {
+			provider.RedirectURI = cfg.BaseURL + "/auth/" + name + "/callback"
+		}
+	}
+	for i := range cfg.OAuth.Providers {
+		provider := &cfg.OAuth.Providers[i]
+		if provider.RedirectURI == "" {
+			provider.RedirectURI = cfg.BaseURL + "/auth/" + provider.Name + "/callback"
+		}
+		if provider.DisplayName == "" {
+			provider.DisplayName = provider.Name
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides settings from FORUM_* environment variables
+func (cfg *Config) applyEnv(lookup func(string) (string, bool)) error {
+	fields := map[string]*string{
+		"FORUM_LISTEN_ADDR":            &cfg.ListenAddr,
+		"FORUM_DB_PATH":                &cfg.DBPath,
+		"FORUM_UPLOAD_DIR":             &cfg.UploadDir,
+		"FORUM_BASE_URL":               &cfg.BaseURL,
+		"FORUM_COOKIE_SAMESITE":        &cfg.Cookie.SameSite,
+		"FORUM_COOKIE_DOMAIN":          &cfg.Cookie.Domain,
+		"FORUM_PASSWORD_HASHER":        &cfg.Password.Hasher,
+		"FORUM_PASSWORD_BLOCKLIST":     &cfg.Password.BlocklistFile,
+		"FORUM_SMTP_ADDR":              &cfg.Mail.SMTPAddr,
+		"FORUM_MAIL_FROM":              &cfg.Mail.From,
+		"FORUM_SMTP_USERNAME":          &cfg.Mail.Username,
+		"FORUM_SMTP_PASSWORD":          &cfg.Mail.Password,
+		"FORUM_GOOGLE_CLIENT_ID":       &cfg.OAuth.Google.ClientID,
+		"FORUM_GOOGLE_CLIENT_SECRET":   &cfg.OAuth.Google.ClientSecret,
+		"FORUM_GOOGLE_REDIRECT_URI":    &cfg.OAuth.Google.RedirectURI,
+		"FORUM_GITHUB_CLIENT_ID":       &cfg.OAuth.GitHub.ClientID,
+		"FORUM_GITHUB_CLIENT_SECRET":   &cfg.OAuth.GitHub.ClientSecret,
+		"FORUM_GITHUB_REDIRECT_URI":    &cfg.OAuth.GitHub.RedirectURI,
+		"FORUM_FACEBOOK_CLIENT_ID":     &cfg.OAuth.Facebook.ClientID,
+		"FORUM_FACEBOOK_CLIENT_SECRET": &cfg.OAuth.Facebook.ClientSecret,
+		"FORUM_FACEBOOK_REDIRECT_URI":  &cfg.OAuth.Facebook.RedirectURI,
+		"FORUM_REGISTRATION_MODE":      &cfg.Registration.Mode,
+	}
+	for name, field := range fields {
+		if value, ok := lookup(name); ok {
+			*field = value
+		}
+	}
+
+	durations := map[string]*Duration{
+		"FORUM_SESSION_IDLE_TIMEOUT": &cfg.Session.IdleTimeout,
+		"FORUM_SESSION_MAX_LIFETIME": &cfg.Session.MaxLifetime,
+		"FORUM_SESSION_REMEMBER_ME":  &cfg.Session.RememberMe,
+		"FORUM_MAGIC_LINK_TTL":       &cfg.MagicLink.TTL,
+		"FORUM_TRASH_RETENTION":      &cfg.Trash.Retention,
+	}
+	for name, field := range durations {
+		if value, ok := lookup(name); ok {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %v", name, value, err)
+			}
+			field.Duration = parsed
+		}
+	}
+
+	// A comma-separated list, such as "example.com,example.org"
+	if value, ok := lookup("FORUM_REGISTRATION_DOMAINS"); ok {
+		cfg.Registration.AllowedDomains = nil
+		for _, domain := range strings.Split(value, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				cfg.Registration.AllowedDomains = append(cfg.Registration.AllowedDomains, domain)
+			}
+		}
+	}
+
+	if value, ok := lookup("FORUM_PASSWORD_MIN_LENGTH"); ok {
+		minLength, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid FORUM_PASSWORD_MIN_LENGTH %q: %v", value, err)
+		}
+		cfg.Password.MinLength = minLength
+	}
+
+	bools := map[string]*bool{
+		"FORUM_COOKIE_SECURE":       &cfg.Cookie.Secure,
+		"FORUM_MAGIC_LINK_ENABLED":  &cfg.MagicLink.Enabled,
+		"FORUM_VERIFY_FOR_POSTS":    &cfg.Verification.RequireForPosts,
+		"FORUM_VERIFY_FOR_COMMENTS": &cfg.Verification.RequireForComments,
+	}
+	for name, field := range bools {
+		if value, ok := lookup(name); ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %v", name, value, err)
+			}
+			*field = parsed
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) validate() error {
+	if cfg.ListenAddr == "" {
+		return errors.New("listen_addr must not be empty")
+	}
+	if cfg.DBPath == "" {
+		return errors.New("db_path must not be empty")
+	}
+	if cfg.UploadDir == "" {
+		return errors.New("upload_dir must not be empty")
+	}
+
+	base, err := url.Parse(cfg.BaseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return fmt.Errorf("base_url must be an absolute http(s) URL, got %q", cfg.BaseURL)
+	}
+
+	switch strings.ToLower(cfg.Cookie.SameSite) {
+	case "lax", "strict":
+	case "none":
+		// Browsers reject SameSite=None cookies that aren't also Secure
+		if !cfg.Cookie.Secure {
+			return errors.New("cookie.same_site \"none\" requires cookie.secure")
+		}
+	default:
+		return fmt.Errorf("cookie.same_site must be lax, strict or none, got %q", cfg.Cookie.SameSite)
+	}
+
+	if cfg.Session.IdleTimeout.Duration <= 0 || cfg.Session.MaxLifetime.Duration <= 0 {
+		return errors.New("session.idle_timeout and session.max_lifetime must be positive")
+	}
+	if cfg.Session.IdleTimeout.Duration > cfg.Session.MaxLifetime.Duration {
+		return errors.New("session.idle_timeout must not be longer than session.max_lifetime")
+	}
+	if cfg.Session.RememberMe.Duration < 0 {
+		return errors.New("session.remember_me must not be negative")
+	}
+
+	if cfg.MagicLink.Enabled && (cfg.MagicLink.TTL.Duration <= 0 || cfg.MagicLink.TTL.Duration > time.Hour) {
+		return errors.New("magic_link.ttl must be positive and at most an hour")
+	}
+
+	if cfg.Trash.Retention.Duration <= 0 {
+		return errors.New("trash.retention must be positive")
+	}
+
+	if err := cfg.Password.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Registration.validate(); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, provider := range cfg.OAuth.Providers {
+		if err := provider.validate(); err != nil {
+			return err
+		}
+		if seen[provider.Name] || slices.Contains(builtinProviders, provider.Name) {
+			return fmt.Errorf("oauth provider %q is defined more than once", provider.Name)
+		}
+		seen[provider.Name] = true
+	}
+	return nil
+}
+
+func (p Password) validate() error {
+	switch p.Hasher {
+	case "argon2id":
+		if p.Argon2.Iterations < 1 || p.Argon2.Parallelism < 1 {
+			return errors.New("password.argon2 iterations and parallelism must be at least 1")
+		}
+		// Argon2 needs at least 8 KiB per thread
+		if p.Argon2.Memory < 8*uint32(p.Argon2.Parallelism) {
+			return errors.New("password.argon2.memory must be at least 8 KiB per thread")
+		}
+	case "bcrypt":
+		if p.BcryptCost < 4 || p.BcryptCost > 31 {
+			return fmt.Errorf("password.bcrypt_cost must be between 4 and 31, got %d", p.BcryptCost)
+		}
+	default:
+		return fmt.Errorf("password.hasher must be argon2id or bcrypt, got %q", p.Hasher)
+	}
+	if p.MinLength < 1 || p.MinLength > 128 {
+		return fmt.Errorf("password.min_length must be between 1 and 128, got %d", p.MinLength)
+	}
+	return nil
+}
+
+func (r Registration) validate() error {
+	switch r.Mode {
+	case "open", "invite", "closed":
+	case "domain":
+		if len(r.AllowedDomains) == 0 {
+			return errors.New("registration mode \"domain\" needs registration.allowed_domains")
+		}
+	default:
+		return fmt.Errorf("registration.mode must be open, invite, domain or closed, got %q", r.Mode)
+	}
+	for _, domain := range r.AllowedDomains {
+		if domain == "" || strings.ContainsAny(domain, "@ ") {
+			return fmt.Errorf("registration.allowed_domains must be bare domains like example.com, got %q", domain)
+		}
+	}
+	return nil
+}
+
+func (p Provider) validate() error {
+	if !providerName.MatchString(p.Name) {
+		return fmt.Errorf("oauth provider name must be lowercase letters, digits and dashes, got %q", p.Name)
+	}
+	if p.Issuer != "" {
+		if _, err := url.ParseRequestURI(p.Issuer); err != nil {
+			return fmt.Errorf("oauth provider %q has an invalid issuer: %v", p.Name, err)
+		}
+		return nil
+	}
+	if p.AuthURL == "" || p.TokenURL == "" || p.UserInfoURL == "" {
+		return fmt.Errorf("oauth provider %q needs either an issuer or auth_url, token_url and userinfo_url", p.Name)
+	}
+	return nil
+}
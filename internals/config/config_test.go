@@ -0,0 +1,164 @@
+package config
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(filepath.Join(t.TempDir(), "missing.json"), false)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.ListenAddr != ":8080" || cfg.DBPath != "./forum.db" || cfg.UploadDir != "static/images" {
+		t.Errorf("Unexpected defaults: %+v", cfg)
+	}
+	if cfg.OAuth.Google.Enabled() || cfg.OAuth.GitHub.Enabled() || cfg.OAuth.Facebook.Enabled() {
+		t.Error("Providers without credentials should be disabled")
+	}
+	if cfg.OAuth.GitHub.RedirectURI != "http://localhost:8080/auth/github/callback" {
+		t.Errorf("Unexpected default redirect URI %q", cfg.OAuth.GitHub.RedirectURI)
+	}
+}
+
+func TestLoadRequiredFileMissing(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json"), true); err == nil {
+		t.Error("Expected an error for a missing required config file")
+	}
+}
+
+func TestLoadFileAndEnv(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"listen_addr": ":9000",
+		"base_url": "https://forum.example.com/",
+		"cookie": {"secure": true, "same_site": "strict"},
+		"session": {"idle_timeout": "2h"},
+		"oauth": {"google": {"client_id": "file-id", "client_secret": "file-secret"}}
+	}`)
+	t.Setenv("FORUM_LISTEN_ADDR", ":9100")
+	t.Setenv("FORUM_GOOGLE_CLIENT_SECRET", "env-secret")
+	t.Setenv("FORUM_SESSION_REMEMBER_ME", "0s")
+	t.Setenv("FORUM_REGISTRATION_MODE", "domain")
+	t.Setenv("FORUM_REGISTRATION_DOMAINS", "example.com, example.org")
+	t.Setenv("FORUM_TRASH_RETENTION", "168h")
+	t.Setenv("FORUM_VERIFY_FOR_COMMENTS", "false")
+
+	cfg, err := Load(path, true)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	if cfg.ListenAddr != ":9100" {
+		t.Errorf("Environment should override the file, got listen_addr %q", cfg.ListenAddr)
+	}
+	if cfg.DBPath != "./forum.db" {
+		t.Errorf("Settings missing from the file should keep their defaults, got %q", cfg.DBPath)
+	}
+	if cfg.BaseURL != "https://forum.example.com" {
+		t.Errorf("Expected trailing slash to be trimmed, got %q", cfg.BaseURL)
+	}
+	if cfg.Cookie.SameSiteMode() != http.SameSiteStrictMode || !cfg.Cookie.Secure {
+		t.Errorf("Unexpected cookie settings %+v", cfg.Cookie)
+	}
+
+	if cfg.Session.IdleTimeout.Duration != 2*time.Hour || cfg.Session.MaxLifetime.Duration != 7*24*time.Hour || cfg.Session.RememberMe.Duration != 0 {
+		t.Errorf("Unexpected session settings %+v", cfg.Session)
+	}
+
+	if cfg.Trash.Retention.Duration != 7*24*time.Hour {
+		t.Errorf("Unexpected trash retention %v", cfg.Trash.Retention)
+	}
+	if !cfg.Verification.RequireForPosts || cfg.Verification.RequireForComments {
+		t.Errorf("Unexpected verification settings %+v", cfg.Verification)
+	}
+
+	if domains := cfg.Registration.AllowedDomains; cfg.Registration.Mode != "domain" || len(domains) != 2 || domains[1] != "example.org" {
+		t.Errorf("Unexpected registration settings %+v", cfg.Registration)
+	}
+
+	google := cfg.OAuth.Google
+	if !google.Enabled() || google.ClientID != "file-id" || google.ClientSecret != "env-secret" {
+		t.Errorf("Unexpected Google settings %+v", google)
+	}
+	if google.RedirectURI != "https://forum.example.com/auth/google/callback" {
+		t.Errorf("Expected redirect URI under the base URL, got %q", google.RedirectURI)
+	}
+}
+
+func TestLoadExtraProviders(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"base_url": "https://forum.example.com",
+		"oauth": {"providers": [
+			{"name": "gitlab", "display_name": "GitLab", "issuer": "https://gitlab.com", "client_id": "id", "client_secret": "secret"},
+			{"name": "discord", "auth_url": "https://a", "token_url": "https://t", "userinfo_url": "https://u"}
+		]}
+	}`)
+
+	cfg, err := Load(path, true)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	gitlab, discord := cfg.OAuth.Providers[0], cfg.OAuth.Providers[1]
+	if !gitlab.Enabled() || gitlab.RedirectURI != "https://forum.example.com/auth/gitlab/callback" {
+		t.Errorf("Unexpected gitlab provider: %+v", gitlab)
+	}
+	if discord.DisplayName != "discord" {
+		t.Errorf("Display name should default to the provider name, got %q", discord.DisplayName)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		env     map[string]string
+		wantErr string
+	}{
+		{"Bad JSON", `{"listen_addr":`, nil, "parse"},
+		{"Relative base URL", `{"base_url": "forum.example.com"}`, nil, "base_url"},
+		{"Unknown SameSite", `{"cookie": {"same_site": "sometimes"}}`, nil, "same_site"},
+		{"SameSite none without Secure", `{"cookie": {"same_site": "none"}}`, nil, "secure"},
+		{"Bad boolean", `{}`, map[string]string{"FORUM_COOKIE_SECURE": "maybe"}, "FORUM_COOKIE_SECURE"},
+		{"Bad duration", `{"session": {"idle_timeout": "a day"}}`, nil, "duration"},
+		{"Idle longer than lifetime", `{"session": {"idle_timeout": "48h", "max_lifetime": "24h"}}`, nil, "idle_timeout"},
+		{"Bad duration env", `{}`, map[string]string{"FORUM_SESSION_REMEMBER_ME": "forever"}, "FORUM_SESSION_REMEMBER_ME"},
+		{"Magic link TTL too long", `{"magic_link": {"enabled": true, "ttl": "24h"}}`, nil, "magic_link.ttl"},
+		{"Bad magic link env", `{}`, map[string]string{"FORUM_MAGIC_LINK_ENABLED": "sometimes"}, "FORUM_MAGIC_LINK_ENABLED"},
+		{"Bad verification env", `{}`, map[string]string{"FORUM_VERIFY_FOR_POSTS": "maybe"}, "FORUM_VERIFY_FOR_POSTS"},
+		{"Trash never kept", `{"trash": {"retention": "0s"}}`, nil, "trash.retention"},
+		{"Unknown registration mode", `{"registration": {"mode": "friends"}}`, nil, "registration.mode"},
+		{"Domain mode without domains", `{"registration": {"mode": "domain"}}`, nil, "allowed_domains"},
+		{"Email as allowed domain", `{"registration": {"mode": "domain", "allowed_domains": ["me@example.com"]}}`, nil, "allowed_domains"},
+		{"Unknown hasher", `{"password": {"hasher": "md5"}}`, nil, "password.hasher"},
+		{"Bcrypt cost too low", `{"password": {"hasher": "bcrypt", "bcrypt_cost": 2}}`, nil, "bcrypt_cost"},
+		{"Argon2 memory too low", `{"password": {"argon2": {"memory": 4, "iterations": 1, "parallelism": 1}}}`, nil, "memory"},
+		{"Bad min length env", `{}`, map[string]string{"FORUM_PASSWORD_MIN_LENGTH": "eight"}, "FORUM_PASSWORD_MIN_LENGTH"},
+		{"Provider without endpoints", `{"oauth": {"providers": [{"name": "gitlab"}]}}`, nil, "issuer"},
+		{"Bad provider name", `{"oauth": {"providers": [{"name": "Git Lab", "issuer": "https://gitlab.com"}]}}`, nil, "name"},
+		{"Provider shadows built-in", `{"oauth": {"providers": [{"name": "github", "issuer": "https://github.com"}]}}`, nil, "more than once"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			_, err := Load(writeConfigFile(t, tt.content), true)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error mentioning %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
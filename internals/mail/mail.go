@@ -0,0 +1,104 @@
+package mail
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an outbound plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers outbound email
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Default is the mailer used by the application. It targets a local SMTP
+// stand-in such as MailHog or Mailpit unless configured otherwise.
+var Default Mailer = &SMTPMailer{
+	Addr: "localhost:1025",
+	From: "no-reply@forum.local",
+}
+
+// Send delivers the message through the default mailer
+func Send(msg Message) error {
+	return Default.Send(msg)
+}
+
+// SMTPMailer sends email through an SMTP server
+type SMTPMailer struct {
+	Addr     string // host:port of the SMTP server
+	From     string
+	Username string // leave empty for servers that don't require auth
+	Password string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := m.Addr
+		if i := strings.LastIndex(host, ":"); i != -1 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	if err := smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, format(m.From, msg)); err != nil {
+		return fmt.Errorf("failed to send mail to %s: %v", msg.To, err)
+	}
+	return nil
+}
+
+// FileMailer writes each message to its own file in Dir instead of sending it.
+// It is meant for tests and local development without an SMTP server.
+type FileMailer struct {
+	Dir  string
+	From string
+
+	mu    sync.Mutex
+	count int
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %v", err)
+	}
+
+	m.count++
+	name := fmt.Sprintf("%d-%03d.eml", time.Now().UnixNano(), m.count)
+	if err := os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0o644); err != nil {
+		return fmt.Errorf("failed to write mail file: %v", err)
+	}
+	return nil
+}
+
+// format renders the message with the minimal headers mail clients expect
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// headerValue strips line breaks so a value can't inject extra headers
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
@@ -0,0 +1,126 @@
+package mail
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSMTPServer accepts a single message and hands its DATA section to the returned channel
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost fake SMTP")
+
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					reply("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(command, "DATA"):
+				inData = true
+				reply("354 End data with <CR><LF>.<CR><LF>")
+			case strings.HasPrefix(command, "QUIT"):
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+
+	mailer := &SMTPMailer{Addr: addr, From: "no-reply@forum.local"}
+	err := mailer.Send(Message{
+		To:      "user@example.com",
+		Subject: "Hello",
+		Body:    "First line\nSecond line",
+	})
+	if err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+
+	data := <-received
+	for _, want := range []string{"To: user@example.com", "Subject: Hello", "First line\r\nSecond line"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("Expected message to contain %q, got:\n%s", want, data)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer := &FileMailer{Dir: dir, From: "no-reply@forum.local"}
+
+	for _, subject := range []string{"One", "Two"} {
+		if err := mailer.Send(Message{To: "user@example.com", Subject: subject, Body: "Body"}); err != nil {
+			t.Fatalf("Send returned error: %v", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatalf("Failed to list mail files: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 mail files, got %d", len(files))
+	}
+
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("Failed to read mail file: %v", err)
+	}
+	if !strings.Contains(string(content), "Subject: One") {
+		t.Errorf("Expected first file to hold the first message, got:\n%s", content)
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	msg := format("no-reply@forum.local", Message{
+		To:      "user@example.com",
+		Subject: "Hi\r\nBcc: attacker@example.com",
+		Body:    "Body",
+	})
+	if strings.Contains(string(msg), "\r\nBcc:") {
+		t.Error("Subject line breaks should be stripped")
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"forum/db"
 	"forum/internals/auth"
@@ -25,16 +27,43 @@ func ServeCreatePostForm(w http.ResponseWriter, r *http.Request) {
 		pageData = PageData{
 			IsLoggedIn: true,
 			UserName:   session.UserName,
+			CSRFToken:  session.CSRFToken,
+			Role:       session.Role,
 		}
 	}
 
-	// Parse and execute the template
+	data := struct {
+		PageData
+		Edit  *postRecord
+		Draft *Draft
+	}{PageData: pageData}
+
+	// Carry on writing a saved draft
+	if id := r.URL.Query().Get("draft"); id != "" && session != nil {
+		draftID, err := strconv.Atoi(id)
+		if err != nil {
+			fails.ErrorPageHandler(w, r, http.StatusBadRequest)
+			return
+		}
+		data.Draft, err = loadDraft(session.UserID, draftID)
+		if err == sql.ErrNoRows {
+			fails.ErrorPageHandler(w, r, http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error loading draft %d: %v", draftID, err)
+			fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Parse and execute the template. The form is shared with editing, which
+	// fills in Edit.
 	t, err := template.ParseFiles("./templates/post.html")
 	if err != nil {
 		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
 		return
 	}
-	if err := t.Execute(w, pageData); err != nil {
+	if err := t.Execute(w, data); err != nil {
 		log.Println(err)
 		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
 	}
@@ -109,6 +138,9 @@ func ServeHomePage(w http.ResponseWriter, r *http.Request) {
 		pageData = PageData{
 			IsLoggedIn: true,
 			UserName:   session.UserName,
+			CSRFToken:  session.CSRFToken,
+			Role:       session.Role,
+			Unverified: !auth.IsEmailVerified(session.UserID),
 		}
 		userID = int64(session.UserID)
 	}
@@ -135,6 +167,8 @@ func ServePosts(w http.ResponseWriter, r *http.Request) {
 	pageData := PageData{
 		IsLoggedIn: true,
 		UserName:   session.UserName,
+		CSRFToken:  session.CSRFToken,
+		Role:       session.Role,
 	}
 
 	posts, err := FetchPosts(int64(session.UserID))
@@ -180,6 +214,12 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// A post with a publish time stays hidden until the scheduler publishes it
+	publishAt, err := parsePublishAt(r.FormValue("publish_at"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Begin transaction
 	tx, err := db.DB.Begin()
@@ -189,7 +229,25 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	// Get the image filename if one was uploaded
+	// Publishing a draft uses it up
+	var draftImage *string
+	if id := r.FormValue("draft_id"); id != "" {
+		draftID, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, "Invalid draft ID", http.StatusBadRequest)
+			return
+		}
+		draftImage, err = takeDraft(tx, session.UserID, draftID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Draft not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Error publishing draft", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Get the image filename if one was uploaded, otherwise keep the draft's
 	var imageFilename string
 	uploadMutex.Lock()
 	if upload, exists := currentUpload[int64(session.UserID)]; exists {
@@ -198,9 +256,12 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 		delete(currentUpload, int64(session.UserID))
 	}
 	uploadMutex.Unlock()
+	if imageFilename == "" && draftImage != nil && r.FormValue("remove_image") == "" {
+		imageFilename = *draftImage
+	}
 
 	// Insert post
-	postID, err := insertPost(tx, session.UserID, title, content, imageFilename)
+	postID, err := insertPost(tx, session.UserID, title, content, imageFilename, publishAt)
 	if err != nil {
 		http.Error(w, "Error creating post", http.StatusInternalServerError)
 		return
@@ -216,7 +277,15 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error saving post", http.StatusInternalServerError)
 		return
 	}
+	if draftImage != nil && *draftImage != imageFilename {
+		removeUnusedImage(*draftImage)
+	}
 
+	// Scheduled posts aren't on the home page yet, but are listed with the drafts
+	if publishAt != nil {
+		http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+		return
+	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
@@ -236,22 +305,17 @@ func validatePostInput(title, content string, categoryIDs []string) error {
 	return nil
 }
 
-func insertPost(tx *sql.Tx, userID int, title, content, imageFilename string) (int64, error) {
-	var result sql.Result
-	var err error
-
+func insertPost(tx *sql.Tx, userID int, title, content, imageFilename string, publishAt *time.Time) (int64, error) {
+	var image *string
 	if imageFilename != "" {
-		result, err = tx.Exec(
-			`INSERT INTO posts (user_id, title, content, image) VALUES (?, ?, ?, ?)`,
-			userID, title, content, imageFilename,
-		)
-	} else {
-		result, err = tx.Exec(
-			`INSERT INTO posts (user_id, title, content) VALUES (?, ?, ?)`,
-			userID, title, content,
-		)
+		image = &imageFilename
 	}
 
+	result, err := tx.Exec(
+		`INSERT INTO posts (user_id, title, content, image, publish_at) VALUES (?, ?, ?, ?, ?)`,
+		userID, title, content, image, publishAt,
+	)
+
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,60 @@
+package post
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestPostFormScripts checks that post.js submits and autosaves the post
+// form rather than the logout form in the header, which comes first on the page
+func TestPostFormScripts(t *testing.T) {
+	setupPostTestDB(t)
+
+	// The form renders templates relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir("internals/post")
+
+	for _, target := range []string{"/create-post-form", "/edit-post-form?id=1"} {
+		rec := httptest.NewRecorder()
+		req := withSession(httptest.NewRequest(http.MethodGet, target, nil), 1, "author", "member")
+		if strings.HasPrefix(target, "/edit") {
+			ServeEditPostForm(rec, req)
+		} else {
+			ServeCreatePostForm(rec, req)
+		}
+		page := rec.Body.String()
+
+		start := strings.Index(page, `<form id="post-form"`)
+		if start < 0 {
+			t.Fatalf("%s: expected a form with id post-form", target)
+		}
+		form := page[start : start+strings.Index(page[start:], "</form>")]
+		if !strings.Contains(form, `id="title"`) || !strings.Contains(form, `id="content"`) {
+			t.Errorf("%s: expected the title and content fields inside the post form", target)
+		}
+		if logout := strings.Index(page, `action="/logout"`); logout < 0 || logout > start {
+			t.Errorf("%s: expected the logout form before the post form", target)
+		}
+	}
+
+	script, err := os.ReadFile("static/js/post.js")
+	if err != nil {
+		t.Fatalf("Could not read post.js: %v", err)
+	}
+	if !strings.Contains(string(script), `document.getElementById("post-form").addEventListener("submit"`) {
+		t.Error("Expected post.js to handle submitting the post form by id")
+	}
+	// Autosave listens for edits on the form holding #title
+	if !strings.Contains(string(script), `const form = document.getElementById("post-form");
+  form.addEventListener("input", scheduleAutosave);`) {
+		t.Error("Expected post.js to autosave edits made in the post form")
+	}
+	if strings.Contains(string(script), `querySelector("form")`) {
+		t.Error("Expected post.js not to pick the first form on the page")
+	}
+}
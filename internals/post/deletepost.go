@@ -1,18 +1,155 @@
 package post
 
 import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
 	"forum/db"
+	"forum/internals/auth"
 )
 
-// delete a post by the post id
-func DeletePost(postID int64) error {
-	_, err := db.DB.Exec("DELETE FROM posts WHERE id = ?", postID)
+// DeletePost moves a post to the trash, hiding it with its comments until
+// it is restored or the trash is purged. The author can delete their post,
+// and so can moderators.
+func DeletePost(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := r.Context().Value(auth.UserSessionKey).(*auth.Session)
+	if !ok || session == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	var authorID int
+	err = db.DB.QueryRow(`SELECT user_id FROM posts WHERE id = ? AND deleted_at IS NULL`, postID).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error loading post %d for deletion: %v", postID, err)
+		http.Error(w, "Error deleting post", http.StatusInternalServerError)
+		return
+	}
+	if authorID != session.UserID && !session.Can(auth.PermModeratePosts) {
+		http.Error(w, "You can only delete your own posts", http.StatusForbidden)
+		return
+	}
+
+	_, err = db.DB.Exec(
+		`UPDATE posts SET deleted_at = ?, deleted_by = ? WHERE id = ? AND deleted_at IS NULL`,
+		time.Now().UTC(), session.UserID, postID,
+	)
+	if err != nil {
+		log.Printf("Error deleting post %d: %v", postID, err)
+		http.Error(w, "Error deleting post", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// erasePost deletes a post from the database for good, then the images
+// nothing else uses any more
+func erasePost(postID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	images, err := deletePostRows(tx, postID)
 	if err != nil {
 		return err
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The rows are gone for good now, so their files can go too
+	for _, image := range images {
+		removeUnusedImage(image)
+	}
 	return nil
 }
 
+// deletePostRows deletes the post and every row that belongs to it, and
+// returns the images the post and its revisions used. The foreign keys
+// cascade as well; deleting each table here keeps the order explicit and
+// works on databases whose tables predate the cascades.
+func deletePostRows(tx *sql.Tx, postID int) ([]string, error) {
+	rows, err := tx.Query(
+		`SELECT image FROM posts WHERE id = ? AND image IS NOT NULL
+		UNION SELECT image FROM post_revisions WHERE post_id = ? AND image IS NOT NULL`,
+		postID, postID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var images []string
+	for rows.Next() {
+		var image string
+		if err := rows.Scan(&image); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statements := []string{
+		`DELETE FROM comment_reactions WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)`,
+		`DELETE FROM comments WHERE post_id = ?`,
+		`DELETE FROM post_reactions WHERE post_id = ?`,
+		`DELETE FROM post_categories WHERE post_id = ?`,
+		`DELETE FROM post_revisions WHERE post_id = ?`,
+		`DELETE FROM posts WHERE id = ?`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, postID); err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+// removeUnusedImage deletes an uploaded image from UploadDir unless a post,
+// revision or draft still uses it
+func removeUnusedImage(image string) {
+	if !strings.HasPrefix(image, "/static/images/") {
+		return
+	}
+	var inUse bool
+	err := db.DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM posts WHERE image = ?) OR EXISTS (SELECT 1 FROM post_revisions WHERE image = ?)
+		OR EXISTS (SELECT 1 FROM drafts WHERE image = ?)`,
+		image, image, image,
+	).Scan(&inUse)
+	if err != nil || inUse {
+		return
+	}
+	if err := os.Remove(filepath.Join(UploadDir, path.Base(image))); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing image %s: %v", image, err)
+	}
+}
+
 // update post image by post id
 func UpdatePostImage(postID int64, image string) {
 	_, err := db.DB.Exec("UPDATE posts SET image = ? WHERE id = ?", image, postID)
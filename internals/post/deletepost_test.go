@@ -0,0 +1,106 @@
+package post
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeletePost(t *testing.T) {
+	testDB := setupPostTestDB(t)
+
+	originalDir := UploadDir
+	UploadDir = t.TempDir()
+	t.Cleanup(func() { UploadDir = originalDir })
+	for _, name := range []string{"fox.jpg", "old.jpg", "shared.jpg"} {
+		if err := os.WriteFile(filepath.Join(UploadDir, name), []byte("image"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Post 1 had another image before an edit; post 2 shares one with post 3
+	_, err := testDB.Exec(`
+		INSERT INTO comments (id, post_id, user_id, content) VALUES (1, 1, 2, 'Nice'), (2, 1, 1, 'Thanks');
+		INSERT INTO comment_reactions (comment_id, user_id, reaction_type) VALUES (1, 1, 'LIKE');
+		INSERT INTO post_reactions (post_id, user_id, reaction_type) VALUES (1, 2, 'LIKE');
+		INSERT INTO post_revisions (post_id, revision, title, content, image, edited_by, created_at)
+			VALUES (1, 1, 'First title', 'The quick brown fox', '/static/images/old.jpg', 1, CURRENT_TIMESTAMP);
+		INSERT INTO posts (id, user_id, title, content, image) VALUES
+			(2, 2, 'Second', 'Another post', '/static/images/shared.jpg'),
+			(3, 2, 'Third', 'Yet another post', '/static/images/shared.jpg');
+		INSERT INTO comments (id, post_id, user_id, content) VALUES (3, 2, 1, 'Keep me');
+	`)
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+
+	form := func(postID string) url.Values { return url.Values{"post_id": {postID}} }
+	tests := []struct {
+		name     string
+		userID   int
+		username string
+		role     string
+		postID   string
+		want     int
+	}{
+		{"Someone else", 2, "stranger", "member", "1", http.StatusForbidden},
+		{"Unknown post", 1, "author", "member", "9", http.StatusNotFound},
+		{"Author", 1, "author", "member", "1", http.StatusSeeOther},
+		{"Moderator", 3, "moderator", "moderator", "2", http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postAs(DeletePost, tt.userID, tt.username, tt.role, form(tt.postID)); rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// Deleted posts are hidden but can still be restored
+	posts, err := FetchPosts(0)
+	if err != nil || len(posts) != 1 || posts[0].ID != 3 {
+		t.Errorf("Expected only post 3 to be listed, got %+v (%v)", posts, err)
+	}
+	if _, err := fetchPostFromDB("1", 0); err == nil {
+		t.Error("Expected the deleted post to be hidden")
+	}
+	if rec := postAs(DeletePost, 1, "author", "member", form("1")); rec.Code != http.StatusNotFound {
+		t.Errorf("Deleting a post twice: expected status 404, got %d", rec.Code)
+	}
+
+	// Until the retention period is over
+	if _, err := testDB.Exec(`UPDATE posts SET deleted_at = ? WHERE deleted_at IS NOT NULL`,
+		time.Now().UTC().Add(-TrashRetention-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	purgedPosts, _, err := purgeTrash(TrashRetention)
+	if err != nil || purgedPosts != 2 {
+		t.Fatalf("Expected 2 posts purged, got %d (%v)", purgedPosts, err)
+	}
+
+	counts := map[string]int{
+		"SELECT COUNT(*) FROM posts":             1,
+		"SELECT COUNT(*) FROM comments":          0,
+		"SELECT COUNT(*) FROM comment_reactions": 0,
+		"SELECT COUNT(*) FROM post_reactions":    0,
+		"SELECT COUNT(*) FROM post_categories":   0,
+		"SELECT COUNT(*) FROM post_revisions":    0,
+	}
+	for query, want := range counts {
+		var got int
+		if err := testDB.QueryRow(query).Scan(&got); err != nil || got != want {
+			t.Errorf("%s: expected %d, got %d (%v)", query, want, got, err)
+		}
+	}
+
+	// Images go with the last post showing them
+	for name, kept := range map[string]bool{"fox.jpg": false, "old.jpg": false, "shared.jpg": true} {
+		_, err := os.Stat(filepath.Join(UploadDir, name))
+		if exists := err == nil; exists != kept {
+			t.Errorf("%s: expected kept=%v, got %v", name, kept, exists)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package post
+
+import "regexp"
+
+// Kinds of DiffPart
+const (
+	diffSame    = "same"
+	diffAdded   = "added"
+	diffRemoved = "removed"
+)
+
+// DiffPart is a run of text that is in both versions, or only in one of them
+type DiffPart struct {
+	Op   string
+	Text string
+}
+
+var diffTokens = regexp.MustCompile(`\s+|[^\s]+`)
+
+// diffWords compares two texts word by word, keeping the whitespace between
+// words, so the parts joined together give back either text. Posts are at
+// most 500 characters, which keeps the quadratic table small.
+func diffWords(before, after string) []DiffPart {
+	a := diffTokens.FindAllString(before, -1)
+	b := diffTokens.FindAllString(after, -1)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var parts []DiffPart
+	add := func(op, text string) {
+		if n := len(parts); n > 0 && parts[n-1].Op == op {
+			parts[n-1].Text += text
+			return
+		}
+		parts = append(parts, DiffPart{Op: op, Text: text})
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			add(diffSame, a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add(diffRemoved, a[i])
+			i++
+		default:
+			add(diffAdded, b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		add(diffRemoved, a[i])
+	}
+	for ; j < len(b); j++ {
+		add(diffAdded, b[j])
+	}
+	return parts
+}
+
+// diffLists reports which names were dropped from before and which were added
+func diffLists(before, after []string) (removed, added []string) {
+	inBefore := make(map[string]bool, len(before))
+	for _, name := range before {
+		inBefore[name] = true
+	}
+	inAfter := make(map[string]bool, len(after))
+	for _, name := range after {
+		inAfter[name] = true
+		if !inBefore[name] {
+			added = append(added, name)
+		}
+	}
+	for _, name := range before {
+		if !inAfter[name] {
+			removed = append(removed, name)
+		}
+	}
+	return removed, added
+}
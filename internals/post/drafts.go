@@ -0,0 +1,246 @@
+package post
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/db"
+	"forum/internals/auth"
+	"forum/internals/fails"
+)
+
+// maxDraftLength caps the title and content of a draft. Drafts may run past
+// the post limits while being written, but not without bound.
+const maxDraftLength = 10000
+
+// Draft is a post its author is still writing
+type Draft struct {
+	ID          int
+	Title       string
+	Content     string
+	Image       *string
+	CategoryIDs []int
+	UpdatedAt   time.Time
+}
+
+// Excerpt is the start of the draft's content, for listing it
+func (d Draft) Excerpt() string {
+	const length = 120
+	runes := []rune(d.Content)
+	if len(runes) <= length {
+		return d.Content
+	}
+	return string(runes[:length]) + "…"
+}
+
+// loadDraft returns the user's draft, or sql.ErrNoRows if they have no such draft
+func loadDraft(userID, draftID int) (*Draft, error) {
+	var draft Draft
+	var categories string
+	err := db.DB.QueryRow(
+		`SELECT id, title, content, image, categories, updated_at FROM drafts WHERE id = ? AND user_id = ?`,
+		draftID, userID,
+	).Scan(&draft.ID, &draft.Title, &draft.Content, &draft.Image, &categories, &draft.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(categories), &draft.CategoryIDs); err != nil {
+		log.Printf("Error reading the categories of draft %d: %v", draftID, err)
+	}
+	return &draft, nil
+}
+
+// userDrafts lists the user's drafts, most recently saved first
+func userDrafts(userID int) ([]Draft, error) {
+	rows, err := db.DB.Query(
+		`SELECT id, title, content, updated_at FROM drafts WHERE user_id = ? ORDER BY updated_at DESC, id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var drafts []Draft
+	for rows.Next() {
+		var draft Draft
+		if err := rows.Scan(&draft.ID, &draft.Title, &draft.Content, &draft.UpdatedAt); err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, draft)
+	}
+	return drafts, rows.Err()
+}
+
+// SaveDraft autosaves the post form. Without a draft_id it starts a new
+// draft; the response carries the id to save to from then on.
+func SaveDraft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fails.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	session, ok := r.Context().Value(auth.UserSessionKey).(*auth.Session)
+	if !ok || session == nil {
+		fails.JSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		fails.JSONError(w, http.StatusBadRequest, "Invalid form data")
+		return
+	}
+
+	title := r.FormValue("title")
+	content := r.FormValue("content")
+	if len(title) > maxDraftLength || len(content) > maxDraftLength {
+		fails.JSONError(w, http.StatusBadRequest, "Draft is too long")
+		return
+	}
+	categoryIDs := []int{}
+	for _, id := range r.Form["categories[]"] {
+		if n, err := strconv.Atoi(id); err == nil {
+			categoryIDs = append(categoryIDs, n)
+		}
+	}
+	categories, _ := json.Marshal(categoryIDs)
+	now := time.Now().UTC()
+
+	var draftID int64
+	if r.FormValue("draft_id") == "" {
+		result, err := db.DB.Exec(
+			`INSERT INTO drafts (user_id, title, content, categories, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			session.UserID, title, content, string(categories), now, now,
+		)
+		if err == nil {
+			draftID, err = result.LastInsertId()
+		}
+		if err != nil {
+			log.Printf("Error creating draft: %v", err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to save draft")
+			return
+		}
+	} else {
+		id, err := strconv.Atoi(r.FormValue("draft_id"))
+		if err != nil {
+			fails.JSONError(w, http.StatusBadRequest, "Invalid draft ID")
+			return
+		}
+		result, err := db.DB.Exec(
+			`UPDATE drafts SET title = ?, content = ?, categories = ?, updated_at = ? WHERE id = ? AND user_id = ?`,
+			title, content, string(categories), now, id, session.UserID,
+		)
+		if err != nil {
+			log.Printf("Error saving draft %d: %v", id, err)
+			fails.JSONError(w, http.StatusInternalServerError, "Failed to save draft")
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			// Published or deleted in another tab, or someone else's
+			fails.JSONError(w, http.StatusNotFound, "Draft not found")
+			return
+		}
+		draftID = int64(id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":       draftID,
+		"saved_at": now,
+	})
+}
+
+// ServeDrafts lists the user's drafts and the posts they have scheduled
+func ServeDrafts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := r.Context().Value(auth.UserSessionKey).(*auth.Session)
+	if !ok || session == nil {
+		fails.ErrorPageHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	drafts, err := userDrafts(session.UserID)
+	if err != nil {
+		log.Printf("Error listing drafts: %v", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	scheduled, err := scheduledPosts(session.UserID)
+	if err != nil {
+		log.Printf("Error listing scheduled posts: %v", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		PageData  PageData
+		Drafts    []Draft
+		Scheduled []ScheduledPost
+	}{
+		PageData: PageData{
+			IsLoggedIn: true,
+			UserName:   session.UserName,
+			CSRFToken:  session.CSRFToken,
+			Role:       session.Role,
+		},
+		Drafts:    drafts,
+		Scheduled: scheduled,
+	}
+
+	tmpl, err := template.ParseFiles("templates/drafts.html")
+	if err != nil {
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Println("Template execution error:", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// DeleteDraft throws away one of the user's drafts
+func DeleteDraft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := r.Context().Value(auth.UserSessionKey).(*auth.Session)
+	if !ok || session == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	draftID, err := strconv.Atoi(r.FormValue("draft_id"))
+	if err != nil {
+		http.Error(w, "Invalid draft ID", http.StatusBadRequest)
+		return
+	}
+	image, err := takeDraft(db.DB, session.UserID, draftID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Draft not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error deleting draft %d: %v", draftID, err)
+		http.Error(w, "Error deleting draft", http.StatusInternalServerError)
+		return
+	}
+	if image != nil {
+		removeUnusedImage(*image)
+	}
+
+	http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+}
+
+// takeDraft removes the user's draft and returns its image, or
+// sql.ErrNoRows if they have no such draft
+func takeDraft(q querier, userID, draftID int) (*string, error) {
+	var image *string
+	err := q.QueryRow(`DELETE FROM drafts WHERE id = ? AND user_id = ? RETURNING image`, draftID, userID).Scan(&image)
+	return image, err
+}
@@ -0,0 +1,153 @@
+package post
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// saveDraftAs autosaves the form as the user and returns the draft id
+func saveDraftAs(t *testing.T, userID int, username string, form url.Values) (int, *httptest.ResponseRecorder) {
+	t.Helper()
+	rec := postAs(SaveDraft, userID, username, "member", form)
+	var saved struct {
+		ID int `json:"id"`
+	}
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(strings.NewReader(rec.Body.String())).Decode(&saved); err != nil {
+			t.Fatalf("Could not decode the saved draft: %v", err)
+		}
+	}
+	return saved.ID, rec
+}
+
+func TestSaveDraft(t *testing.T) {
+	testDB := setupPostTestDB(t)
+
+	// Drafts don't have to be valid posts yet
+	id, rec := saveDraftAs(t, 1, "author", url.Values{"title": {"Half"}, "content": {"Wr"}})
+	if rec.Code != http.StatusOK || id == 0 {
+		t.Fatalf("Expected a new draft, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	form := url.Values{"draft_id": {"1"}, "title": {"Half a thought"}, "content": {"Writing it down"}, "categories[]": {"2", "x", "3"}}
+	if again, rec := saveDraftAs(t, 1, "author", form); rec.Code != http.StatusOK || again != id {
+		t.Fatalf("Expected draft %d to be updated, got %d (%d): %s", id, again, rec.Code, rec.Body.String())
+	}
+	if _, rec := saveDraftAs(t, 2, "stranger", form); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected someone else's draft to be not found, got %d", rec.Code)
+	}
+	long := url.Values{"content": {strings.Repeat("a", maxDraftLength+1)}}
+	if _, rec := saveDraftAs(t, 1, "author", long); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an overlong draft to be refused, got %d", rec.Code)
+	}
+
+	draft, err := loadDraft(1, id)
+	if err != nil {
+		t.Fatalf("loadDraft returned error: %v", err)
+	}
+	if draft.Title != "Half a thought" || draft.Content != "Writing it down" || len(draft.CategoryIDs) != 2 || draft.CategoryIDs[1] != 3 {
+		t.Errorf("Unexpected draft %+v", draft)
+	}
+	var count int
+	testDB.QueryRow(`SELECT COUNT(*) FROM drafts`).Scan(&count)
+	if count != 1 {
+		t.Errorf("Expected 1 draft, got %d", count)
+	}
+}
+
+func TestPublishDraft(t *testing.T) {
+	testDB := setupPostTestDB(t)
+	id, _ := saveDraftAs(t, 1, "author", url.Values{"title": {"Draft"}, "content": {"Hi"}})
+	draftID := strconv.Itoa(id)
+
+	publish := func(title, content string) url.Values {
+		return url.Values{"draft_id": {draftID}, "title": {title}, "content": {content}, "categories[]": {"3"}}
+	}
+	tests := []struct {
+		name     string
+		userID   int
+		username string
+		form     url.Values
+		want     int
+	}{
+		{"Invalid", 1, "author", publish("Draft", "Hi"), http.StatusBadRequest},
+		{"Someone else's", 2, "stranger", publish("Draft", "Hello there"), http.StatusNotFound},
+		{"Valid", 1, "author", publish("Draft", "Hello there"), http.StatusSeeOther},
+		{"Already published", 1, "author", publish("Draft", "Hello there"), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postAs(CreatePost, tt.userID, tt.username, "member", tt.form); rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	var posts, drafts int
+	testDB.QueryRow(`SELECT COUNT(*) FROM posts p JOIN post_categories pc ON pc.post_id = p.id
+		WHERE p.title = 'Draft' AND pc.category_id = 3`).Scan(&posts)
+	testDB.QueryRow(`SELECT COUNT(*) FROM drafts`).Scan(&drafts)
+	if posts != 1 || drafts != 0 {
+		t.Errorf("Expected the draft to become one post, got %d posts and %d drafts", posts, drafts)
+	}
+}
+
+func TestDraftPages(t *testing.T) {
+	setupPostTestDB(t)
+	saveDraftAs(t, 1, "author", url.Values{"title": {"Unfinished business"}, "content": {"To be continued"}, "categories[]": {"2"}})
+	postAs(CreatePost, 1, "author", "member", schedulePost("Version 2", time.Now().Add(time.Hour)))
+
+	// The pages render templates relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir("internals/post")
+
+	get := func(handler http.HandlerFunc, target string, userID int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, withSession(httptest.NewRequest(http.MethodGet, target, nil), userID, "author", "member"))
+		return rec
+	}
+
+	rec := get(ServeDrafts, "/drafts", 1)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Unfinished business") {
+		t.Errorf("Expected the draft to be listed, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `<a href="/view-post?id=2">Version 2</a>`) {
+		t.Error("Expected the scheduled post to be listed")
+	}
+	if rec := get(ServeDrafts, "/drafts", 2); strings.Contains(rec.Body.String(), "Unfinished business") ||
+		strings.Contains(rec.Body.String(), "Version 2") {
+		t.Error("Expected drafts and scheduled posts to be private")
+	}
+	if rec := get(ViewPost, "/view-post?id=2", 1); !strings.Contains(rec.Body.String(), "Only you can see this post") {
+		t.Errorf("Expected the author to see when the post goes out, got %d", rec.Code)
+	}
+
+	rec = get(ServeCreatePostForm, "/create-post-form?draft=1", 1)
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, `name="draft_id" value="1"`) ||
+		!strings.Contains(body, "To be continued</textarea>") || !strings.Contains(body, `data-selected="2 "`) {
+		t.Errorf("Expected the form filled in from the draft, got %d", rec.Code)
+	}
+	if rec := get(ServeCreatePostForm, "/create-post-form?draft=1", 2); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected someone else's draft to be not found, got %d", rec.Code)
+	}
+
+	if rec := postAs(DeleteDraft, 2, "stranger", "member", url.Values{"draft_id": {"1"}}); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected someone else's draft to be not found, got %d", rec.Code)
+	}
+	if rec := postAs(DeleteDraft, 1, "author", "member", url.Values{"draft_id": {"1"}}); rec.Code != http.StatusSeeOther {
+		t.Errorf("Expected the draft to be deleted, got %d", rec.Code)
+	}
+	if rec := get(ServeDrafts, "/drafts", 1); !strings.Contains(rec.Body.String(), "No drafts") {
+		t.Error("Expected no drafts left")
+	}
+}
@@ -0,0 +1,287 @@
+package post
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/db"
+	"forum/internals/auth"
+	"forum/internals/fails"
+)
+
+// querier is what loading a post needs, so it works inside a transaction too
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// postRecord is a post as stored, for editing it and keeping its revisions
+type postRecord struct {
+	ID          int
+	UserID      int
+	Title       string
+	Content     string
+	Image       *string
+	CategoryIDs []int
+	Categories  []string
+	// EditedBy wrote this version: the author, or whoever edited it last
+	EditedBy int
+	// VersionAt is when this version was written
+	VersionAt time.Time
+	// PublishAt is set while the post is scheduled and hidden from others
+	PublishAt *time.Time
+}
+
+// loadPostRecord returns the post with its categories, or sql.ErrNoRows if
+// there is no such post or it is in the trash
+func loadPostRecord(q querier, postID int) (*postRecord, error) {
+	var post postRecord
+	var updatedAt sql.NullTime
+	var updatedBy sql.NullInt64
+	err := q.QueryRow(
+		`SELECT id, user_id, title, content, image, created_at, updated_at, updated_by, publish_at
+		FROM posts WHERE id = ? AND deleted_at IS NULL`, postID,
+	).Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.Image, &post.VersionAt, &updatedAt, &updatedBy, &post.PublishAt)
+	if err != nil {
+		return nil, err
+	}
+	post.EditedBy = post.UserID
+	if updatedAt.Valid {
+		post.VersionAt = updatedAt.Time
+	}
+	if updatedBy.Valid {
+		post.EditedBy = int(updatedBy.Int64)
+	}
+
+	rows, err := q.Query(
+		`SELECT c.id, c.name FROM post_categories pc JOIN categories c ON pc.category_id = c.id
+		WHERE pc.post_id = ? ORDER BY c.name`, postID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		post.CategoryIDs = append(post.CategoryIDs, id)
+		post.Categories = append(post.Categories, name)
+	}
+	return &post, rows.Err()
+}
+
+// canEditPost reports whether the user may edit a post: its author can, and
+// so can moderators
+func canEditPost(session *auth.Session, authorID int) bool {
+	return session.UserID == authorID || session.Can(auth.PermModeratePosts)
+}
+
+// ServeEditPostForm shows the post form filled in with the post to edit
+func ServeEditPostForm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := r.Context().Value(auth.UserSessionKey).(*auth.Session)
+	if !ok || session == nil {
+		fails.ErrorPageHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		fails.ErrorPageHandler(w, r, http.StatusBadRequest)
+		return
+	}
+	post, err := loadPostRecord(db.DB, postID)
+	if err == sql.ErrNoRows {
+		fails.ErrorPageHandler(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error loading post %d for editing: %v", postID, err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if !canEditPost(session, post.UserID) {
+		fails.ErrorPageHandler(w, r, http.StatusForbidden)
+		return
+	}
+
+	data := struct {
+		PageData
+		Edit *postRecord
+	}{
+		PageData: PageData{
+			IsLoggedIn: true,
+			UserName:   session.UserName,
+			CSRFToken:  session.CSRFToken,
+			Role:       session.Role,
+		},
+		Edit: post,
+	}
+
+	t, err := template.ParseFiles("./templates/post.html")
+	if err != nil {
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if err := t.Execute(w, data); err != nil {
+		log.Println(err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// EditPost saves changes to a post, keeping the version it replaces as a
+// revision. A new image comes from /upload-image as with CreatePost;
+// remove_image drops the current one.
+func EditPost(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := r.Context().Value(auth.UserSessionKey).(*auth.Session)
+	if !ok || session == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	title := strings.TrimSpace(r.FormValue("title"))
+	content := strings.TrimSpace(r.FormValue("content"))
+	categoryIDs := r.Form["categories[]"]
+	if err := validatePostInput(title, content, categoryIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Look up the user's role before the transaction takes the connection
+	moderator := session.Can(auth.PermModeratePosts)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	current, err := loadPostRecord(tx, postID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error loading post %d for editing: %v", postID, err)
+		http.Error(w, "Error editing post", http.StatusInternalServerError)
+		return
+	}
+	if current.UserID != session.UserID && !moderator {
+		http.Error(w, "You can only edit your own posts", http.StatusForbidden)
+		return
+	}
+
+	image := current.Image
+	uploadMutex.Lock()
+	if upload, exists := currentUpload[int64(session.UserID)]; exists {
+		image = &upload.Filename
+		delete(currentUpload, int64(session.UserID))
+	} else if r.FormValue("remove_image") != "" {
+		image = nil
+	}
+	uploadMutex.Unlock()
+
+	if !postChanged(current, title, content, image, categoryIDs) {
+		http.Redirect(w, r, fmt.Sprintf("/view-post?id=%d", postID), http.StatusSeeOther)
+		return
+	}
+
+	if err := savePostRevision(tx, current); err != nil {
+		log.Printf("Error saving revision of post %d: %v", postID, err)
+		http.Error(w, "Error editing post", http.StatusInternalServerError)
+		return
+	}
+	_, err = tx.Exec(
+		`UPDATE posts SET title = ?, content = ?, image = ?, updated_at = ?, updated_by = ? WHERE id = ?`,
+		title, content, image, time.Now().UTC(), session.UserID, postID,
+	)
+	if err != nil {
+		log.Printf("Error updating post %d: %v", postID, err)
+		http.Error(w, "Error editing post", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM post_categories WHERE post_id = ?`, postID); err != nil {
+		http.Error(w, "Error assigning categories", http.StatusInternalServerError)
+		return
+	}
+	if err := insertPostCategories(tx, int64(postID), categoryIDs); err != nil {
+		http.Error(w, "Error assigning categories", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Error saving post", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/view-post?id=%d", postID), http.StatusSeeOther)
+}
+
+// postChanged reports whether the submitted form differs from the stored post
+func postChanged(current *postRecord, title, content string, image *string, categoryIDs []string) bool {
+	if current.Title != title || current.Content != content {
+		return true
+	}
+	if (current.Image == nil) != (image == nil) || (image != nil && *current.Image != *image) {
+		return true
+	}
+
+	var submitted []int
+	for _, id := range categoryIDs {
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return true
+		}
+		if !slices.Contains(submitted, n) {
+			submitted = append(submitted, n)
+		}
+	}
+	stored := slices.Clone(current.CategoryIDs)
+	slices.Sort(submitted)
+	slices.Sort(stored)
+	return !slices.Equal(submitted, stored)
+}
+
+// savePostRevision stores the post's current version as its next revision
+func savePostRevision(tx *sql.Tx, post *postRecord) error {
+	categories, err := json.Marshal(post.Categories)
+	if err != nil {
+		return err
+	}
+	if post.Categories == nil {
+		categories = []byte("[]")
+	}
+	_, err = tx.Exec(
+		`INSERT INTO post_revisions (post_id, revision, title, content, image, categories, edited_by, created_at)
+		SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ?, ?, ?, ? FROM post_revisions WHERE post_id = ?`,
+		post.ID, post.Title, post.Content, post.Image, string(categories), post.EditedBy, post.VersionAt.UTC(), post.ID,
+	)
+	return err
+}
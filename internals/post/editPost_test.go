@@ -0,0 +1,258 @@
+package post
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"forum/db"
+	"forum/internals/auth"
+)
+
+// setupPostTestDB creates the tables posts are stored in, with two members,
+// a moderator and a post by the first member in Technology
+func setupPostTestDB(t *testing.T) *sql.DB {
+	testDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	testDB.SetMaxOpenConns(1)
+
+	_, err = testDB.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			username TEXT NOT NULL
+		);
+
+		CREATE TABLE posts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			content TEXT NOT NULL,
+			image TEXT DEFAULT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT NULL,
+			updated_by INTEGER DEFAULT NULL,
+			deleted_at DATETIME DEFAULT NULL,
+			deleted_by INTEGER DEFAULT NULL,
+			publish_at DATETIME DEFAULT NULL
+		);
+
+		CREATE TABLE comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			post_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			parent_id INTEGER DEFAULT NULL,
+			content TEXT NOT NULL,
+			deleted_at DATETIME DEFAULT NULL,
+			deleted_by INTEGER DEFAULT NULL
+		);
+
+		CREATE TABLE comment_reactions (
+			comment_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			reaction_type TEXT NOT NULL
+		);
+
+		CREATE TABLE categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL,
+			description TEXT
+		);
+
+		CREATE TABLE post_categories (
+			post_id INTEGER NOT NULL,
+			category_id INTEGER NOT NULL,
+			PRIMARY KEY (post_id, category_id)
+		);
+
+		CREATE TABLE post_reactions (
+			post_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			reaction_type TEXT NOT NULL,
+			UNIQUE (post_id, user_id)
+		);
+
+		CREATE TABLE post_revisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			post_id INTEGER NOT NULL,
+			revision INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			content TEXT NOT NULL,
+			image TEXT DEFAULT NULL,
+			categories TEXT NOT NULL DEFAULT '[]',
+			edited_by INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			UNIQUE (post_id, revision)
+		);
+
+		CREATE TABLE drafts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			content TEXT NOT NULL DEFAULT '',
+			image TEXT DEFAULT NULL,
+			categories TEXT NOT NULL DEFAULT '[]',
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		);
+
+		CREATE TABLE role_permissions (
+			role TEXT NOT NULL,
+			permission TEXT NOT NULL
+		);
+
+		INSERT INTO users (id, username) VALUES (1, 'author'), (2, 'stranger'), (3, 'moderator');
+		INSERT INTO categories (id, name) VALUES (1, 'Technology'), (2, 'Health'), (3, 'Travel');
+		INSERT INTO role_permissions (role, permission) VALUES ('moderator', 'moderate_posts');
+		INSERT INTO posts (id, user_id, title, content, image) VALUES (1, 1, 'First title', 'The quick brown fox', '/static/images/fox.jpg');
+		INSERT INTO post_categories (post_id, category_id) VALUES (1, 1);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	originalDB := db.DB
+	db.DB = testDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		testDB.Close()
+	})
+	return testDB
+}
+
+// withSession attaches the user's session to the request, the way
+// auth.Middleware would after checking it
+func withSession(req *http.Request, userID int, username, role string) *http.Request {
+	session := &auth.Session{UserID: userID, UserName: username, Role: role}
+	return req.WithContext(context.WithValue(req.Context(), auth.UserSessionKey, session))
+}
+
+// postAs sends a form to the handler as the user
+func postAs(handler http.HandlerFunc, userID int, username, role string, values url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler(rec, withSession(req, userID, username, role))
+	return rec
+}
+
+func editForm(title, content string, categories ...string) url.Values {
+	return url.Values{"post_id": {"1"}, "title": {title}, "content": {content}, "categories[]": categories}
+}
+
+func TestEditPost(t *testing.T) {
+	testDB := setupPostTestDB(t)
+
+	tests := []struct {
+		name     string
+		userID   int
+		username string
+		role     string
+		form     url.Values
+		want     int
+	}{
+		{"Someone else", 2, "stranger", "member", editForm("Hijacked", "Not my post at all", "1"), http.StatusForbidden},
+		{"Invalid", 1, "author", "member", editForm("", "The quick brown fox", "1"), http.StatusBadRequest},
+		{"Unchanged", 1, "author", "member", editForm("First title", "The quick brown fox", "1"), http.StatusSeeOther},
+		{"Author", 1, "author", "member", editForm("First title", "The quick red fox", "1", "2"), http.StatusSeeOther},
+		{"Moderator", 3, "moderator", "moderator", editForm("Better title", "The quick red fox", "2"), http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postAs(EditPost, tt.userID, tt.username, tt.role, tt.form); rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// Only the two real edits left revisions behind
+	versions, err := postVersions(1, 0)
+	if err != nil {
+		t.Fatalf("postVersions returned error: %v", err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("Expected 3 versions, got %d", len(versions))
+	}
+	first, second, current := versions[0], versions[1], versions[2]
+	if first.Content != "The quick brown fox" || first.EditedBy != "author" || strings.Join(first.Categories, ",") != "Technology" {
+		t.Errorf("Unexpected first version %+v", first)
+	}
+	if second.Content != "The quick red fox" || strings.Join(second.Categories, ",") != "Health,Technology" {
+		t.Errorf("Unexpected second version %+v", second)
+	}
+	if !current.Current || current.Title != "Better title" || current.EditedBy != "moderator" || current.Image == nil {
+		t.Errorf("Unexpected current version %+v", current)
+	}
+
+	post, err := fetchPostFromDB("1", 0)
+	if err != nil || post.EditedAt == nil {
+		t.Errorf("Expected the post to be marked as edited, got %+v, %v", post, err)
+	}
+
+	// Removing the image is an edit of its own
+	form := editForm("Better title", "The quick red fox", "2")
+	form.Set("remove_image", "1")
+	postAs(EditPost, 1, "author", "member", form)
+	var image sql.NullString
+	testDB.QueryRow("SELECT image FROM posts WHERE id = 1").Scan(&image)
+	if image.Valid {
+		t.Errorf("Expected the image to be removed, got %q", image.String)
+	}
+}
+
+func TestPostHistoryPage(t *testing.T) {
+	setupPostTestDB(t)
+	postAs(EditPost, 1, "author", "member", editForm("First title", "The quick red fox", "1"))
+
+	// The page renders templates relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir("internals/post")
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   string
+	}{
+		{"Latest edit", "id=1", http.StatusOK, `<span class="diff-removed">brown</span><span class="diff-added">red</span>`},
+		{"Same version", "id=1&from=2&to=2", http.StatusOK, "Version 2"},
+		{"Unknown version", "id=1&from=9", http.StatusBadRequest, ""},
+		{"Unknown post", "id=7", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ServePostHistory(rec, httptest.NewRequest("GET", "/post/history?"+tt.query, nil))
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("Expected %d containing %q, got %d", tt.status, tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestDiffWords(t *testing.T) {
+	parts := diffWords("The quick brown fox", "The quick red fox jumps")
+	want := []DiffPart{
+		{diffSame, "The quick "},
+		{diffRemoved, "brown"},
+		{diffAdded, "red"},
+		{diffSame, " fox"},
+		{diffAdded, " jumps"},
+	}
+	if len(parts) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, parts)
+	}
+	for i := range want {
+		if parts[i] != want[i] {
+			t.Errorf("Part %d: expected %v, got %v", i, want[i], parts[i])
+		}
+	}
+}
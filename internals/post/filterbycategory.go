@@ -34,6 +34,7 @@ func FetchPostsByCategory(category string, userID int64) ([]Post, error) {
 			&imgPtr, // Scan the image column
 			&post.UserName,
 			&post.CreatedAt,
+			&post.EditedAt,
 			&post.CommentCount,
 			&post.Likes,
 			&post.Dislikes,
@@ -83,6 +84,8 @@ func ViewPostsByCategory(w http.ResponseWriter, r *http.Request) {
 		pageData = PageData{
 			IsLoggedIn: true,
 			UserName:   session.UserName,
+			CSRFToken:  session.CSRFToken,
+			Role:       session.Role,
 		}
 		userID = int64(session.UserID)
 	}
@@ -20,6 +20,10 @@ var (
 	uploadMutex   sync.Mutex
 )
 
+// UploadDir is where uploaded images are saved. They are served under
+// /static/images/ wherever this points.
+var UploadDir = "static/images"
+
 func UploadImage(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodPost {
@@ -56,14 +60,13 @@ func UploadImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Ensure upload directory exists
-	uploadDir := "static/images"
-	if err := os.MkdirAll(uploadDir, 0o755); err != nil {
+	if err := os.MkdirAll(UploadDir, 0o755); err != nil {
 		http.Error(w, "Error processing upload", http.StatusInternalServerError)
 		return
 	}
 
 	// Create the file
-	filepath := filepath.Join(uploadDir, filename)
+	filepath := filepath.Join(UploadDir, filename)
 	dst, err := os.Create(filepath)
 	if err != nil {
 		http.Error(w, "Error saving file", http.StatusInternalServerError)
@@ -4,16 +4,20 @@ import "time"
 
 // Post represents a post structure
 type Post struct {
-	ID           int
-	Title        string
-	Content      string
-	Image        *string
-	UserName     string
-	CreatedAt    time.Time
+	ID        int
+	Title     string
+	Content   string
+	Image     *string
+	UserName  string
+	CreatedAt time.Time
+	// EditedAt is when the post was last edited, nil if it never was
+	EditedAt     *time.Time
 	CommentCount int
 	Likes        int
 	Dislikes     int
 	UserReaction string `json:"user_reaction,omitempty"`
+	// PublishAt is when a scheduled post goes out, nil once it is published
+	PublishAt *time.Time `json:"publish_at,omitempty"`
 }
 
 type reactToPost struct {
@@ -32,6 +36,12 @@ type Category struct {
 type PageData struct {
 	IsLoggedIn bool
 	UserName   string
+	// Unverified is set for logged in users who haven't confirmed their email
+	Unverified bool
+	// CSRFToken is sent back with requests that change anything
+	CSRFToken string
+	// Role is the signed in user's role, for showing moderation controls
+	Role string
 }
 
 type ImageUploadResult struct {
@@ -0,0 +1,199 @@
+package post
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/db"
+	"forum/internals/auth"
+	"forum/internals/fails"
+)
+
+// Revision is one version of a post. Versions are numbered from 1, the
+// original post; the highest number is the post as it is now.
+type Revision struct {
+	Number     int
+	Title      string
+	Content    string
+	Image      *string
+	Categories []string
+	// EditedBy is who wrote this version
+	EditedBy  string
+	CreatedAt time.Time
+	Current   bool
+}
+
+// Previous is the number of the version this one replaced
+func (r Revision) Previous() int {
+	return r.Number - 1
+}
+
+// RevisionDiff is what changed between two versions of a post
+type RevisionDiff struct {
+	From, To          *Revision
+	Title             []DiffPart
+	Content           []DiffPart
+	RemovedCategories []string
+	AddedCategories   []string
+	ImageChanged      bool
+}
+
+// postVersions returns every version of the post, oldest first, or
+// sql.ErrNoRows if there is no such post or the viewer may not see it yet
+func postVersions(postID, viewerID int) ([]Revision, error) {
+	current, err := loadPostRecord(db.DB, postID)
+	if err != nil {
+		return nil, err
+	}
+	// Scheduled posts are only their author's until published
+	if current.PublishAt != nil && current.UserID != viewerID {
+		return nil, sql.ErrNoRows
+	}
+
+	rows, err := db.DB.Query(
+		`SELECT r.revision, r.title, r.content, r.image, r.categories, u.username, r.created_at
+		FROM post_revisions r JOIN users u ON r.edited_by = u.id
+		WHERE r.post_id = ? ORDER BY r.revision`, postID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []Revision
+	for rows.Next() {
+		var version Revision
+		var categories string
+		if err := rows.Scan(&version.Number, &version.Title, &version.Content, &version.Image,
+			&categories, &version.EditedBy, &version.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(categories), &version.Categories); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	latest := Revision{
+		Number:     len(versions) + 1,
+		Title:      current.Title,
+		Content:    current.Content,
+		Image:      current.Image,
+		Categories: current.Categories,
+		CreatedAt:  current.VersionAt,
+		Current:    true,
+	}
+	if err := db.DB.QueryRow(`SELECT username FROM users WHERE id = ?`, current.EditedBy).Scan(&latest.EditedBy); err != nil {
+		return nil, err
+	}
+	return append(versions, latest), nil
+}
+
+// diffRevisions compares two versions of a post
+func diffRevisions(from, to *Revision) *RevisionDiff {
+	removed, added := diffLists(from.Categories, to.Categories)
+	return &RevisionDiff{
+		From:              from,
+		To:                to,
+		Title:             diffWords(from.Title, to.Title),
+		Content:           diffWords(from.Content, to.Content),
+		RemovedCategories: removed,
+		AddedCategories:   added,
+		ImageChanged:      (from.Image == nil) != (to.Image == nil) || (from.Image != nil && *from.Image != *to.Image),
+	}
+}
+
+// ServePostHistory lists the versions of a post and shows what changed
+// between two of them, by default the latest edit. ?from= and ?to= pick
+// the versions to compare.
+func ServePostHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	postID, err := strconv.Atoi(query.Get("id"))
+	if err != nil {
+		fails.ErrorPageHandler(w, r, http.StatusBadRequest)
+		return
+	}
+
+	session := auth.CheckIfLoggedIn(w, r)
+	var viewerID int
+	if session != nil {
+		viewerID = session.UserID
+	}
+	versions, err := postVersions(postID, viewerID)
+	if err == sql.ErrNoRows {
+		fails.ErrorPageHandler(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error loading history of post %d: %v", postID, err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	// Compare the latest version with the one before it unless asked otherwise
+	to := len(versions)
+	from := max(to-1, 1)
+	for name, field := range map[string]*int{"from": &from, "to": &to} {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > len(versions) {
+				fails.ErrorPageHandler(w, r, http.StatusBadRequest)
+				return
+			}
+			*field = n
+		}
+	}
+
+	var pageData PageData
+	if session != nil {
+		pageData = PageData{
+			IsLoggedIn: true,
+			UserName:   session.UserName,
+			CSRFToken:  session.CSRFToken,
+			Role:       session.Role,
+		}
+	}
+
+	var diff *RevisionDiff
+	if from != to {
+		diff = diffRevisions(&versions[from-1], &versions[to-1])
+	}
+	data := struct {
+		PageData PageData
+		PostID   int
+		Title    string
+		Versions []Revision
+		From, To int
+		Diff     *RevisionDiff
+	}{
+		PageData: pageData,
+		PostID:   postID,
+		Title:    versions[len(versions)-1].Title,
+		Versions: versions,
+		From:     from,
+		To:       to,
+		Diff:     diff,
+	}
+
+	tmpl, err := template.ParseFiles("templates/postHistory.html")
+	if err != nil {
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Println("Template execution error:", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+	}
+}
@@ -38,6 +38,22 @@ func ReactToPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only posts everyone can see take reactions, not scheduled or trashed ones
+	var visible bool
+	err := db.DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM posts WHERE id = ? AND deleted_at IS NULL AND publish_at IS NULL)`,
+		input.PostID,
+	).Scan(&visible)
+	if err != nil {
+		fmt.Println(err.Error())
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if !visible {
+		fails.ErrorPageHandler(w, r, http.StatusNotFound)
+		return
+	}
+
 	// Check the current reaction for the user and post.
 	var currentReaction string
 	queryCheck := `
@@ -45,7 +61,7 @@ func ReactToPost(w http.ResponseWriter, r *http.Request) {
         FROM post_reactions
         WHERE post_id = ? AND user_id = ?`
 
-	err := db.DB.QueryRow(queryCheck, input.PostID, session.UserID).Scan(&currentReaction)
+	err = db.DB.QueryRow(queryCheck, input.PostID, session.UserID).Scan(&currentReaction)
 	if err != nil && err != sql.ErrNoRows {
 		fmt.Println(err.Error())
 		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
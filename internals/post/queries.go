@@ -10,6 +10,7 @@ const (
 			p.image,
 			u.username, 
 			p.created_at,
+			p.updated_at,
 			COALESCE(c.comment_count, 0) AS comment_count,
 			COALESCE(r.likes, 0) AS likes,
 			COALESCE(r.dislikes, 0) AS dislikes,
@@ -19,6 +20,7 @@ const (
 		LEFT JOIN (
 			SELECT post_id, COUNT(*) AS comment_count 
 			FROM comments 
+			WHERE deleted_at IS NULL
 			GROUP BY post_id
 		) c ON p.id = c.post_id
 		LEFT JOIN (
@@ -34,10 +36,12 @@ const (
 			FROM post_reactions
 			WHERE user_id = ?
 		) pr ON p.id = pr.post_id
+		WHERE p.deleted_at IS NULL AND p.publish_at IS NULL
 		ORDER BY p.created_at DESC;
 	`
 
 	// SQL query to fetch the post with additional fields, including the user's reaction.
+	// Posts waiting to be published are only found for their author.
 	FetchPostWithUserReaction = `
 		SELECT 
 			p.id, 
@@ -46,15 +50,18 @@ const (
 			p.image,
 			u.username, 
 			p.created_at,
+			p.updated_at,
 			COALESCE(c.comment_count, 0) AS comment_count,
 			COALESCE(r.likes, 0) AS likes,
 			COALESCE(r.dislikes, 0) AS dislikes,
-			COALESCE(pr.reaction_type, '') AS user_reaction -- Fetch user's reaction or default to empty string
+			COALESCE(pr.reaction_type, '') AS user_reaction, -- Fetch user's reaction or default to empty string
+			p.publish_at
 		FROM posts p
 		JOIN users u ON p.user_id = u.id
 		LEFT JOIN (
 			SELECT post_id, COUNT(*) AS comment_count 
 			FROM comments 
+			WHERE deleted_at IS NULL
 			GROUP BY post_id
 		) c ON p.id = c.post_id
 		LEFT JOIN (
@@ -70,7 +77,7 @@ const (
 			FROM post_reactions
 			WHERE user_id = ?
 		) pr ON p.id = pr.post_id
-		WHERE p.id = ?;
+		WHERE p.id = ? AND p.deleted_at IS NULL AND (p.publish_at IS NULL OR p.user_id = ?);
 	`
 
 	// SQL query to fetch posts for a specific category with additional fields, including the user's reaction.
@@ -82,6 +89,7 @@ const (
 		p.image, -- Add the image column here
 		u.username, 
 		p.created_at,
+		p.updated_at,
 		COALESCE(c.comment_count, 0) AS comment_count,
 		COALESCE(r.likes, 0) AS likes,
 		COALESCE(r.dislikes, 0) AS dislikes,
@@ -93,6 +101,7 @@ const (
 	LEFT JOIN (
 		SELECT post_id, COUNT(*) AS comment_count 
 		FROM comments 
+		WHERE deleted_at IS NULL
 		GROUP BY post_id
 	) c ON p.id = c.post_id
 	LEFT JOIN (
@@ -108,14 +117,15 @@ const (
 		FROM post_reactions
 		WHERE user_id = ?
 	) pr ON p.id = pr.post_id
-	WHERE LOWER(cat.name) = ?
+	WHERE LOWER(cat.name) = ? AND p.deleted_at IS NULL AND p.publish_at IS NULL
 	ORDER BY p.id DESC;
 `
 
 	// The query filters `post_reactions` based on the user's ID and reaction type ('LIKE').
 	FetchLikedPostsByUser = `
-		SELECT post_id
-		FROM post_reactions
-		WHERE user_id = ? AND reaction_type = 'LIKE';
+		SELECT pr.post_id
+		FROM post_reactions pr
+		JOIN posts p ON pr.post_id = p.id
+		WHERE pr.user_id = ? AND pr.reaction_type = 'LIKE' AND p.deleted_at IS NULL AND p.publish_at IS NULL;
 	`
 )
@@ -0,0 +1,223 @@
+package post
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/db"
+	"forum/internals/auth"
+)
+
+// ScheduledPost is a post waiting for its publish time
+type ScheduledPost struct {
+	ID        int
+	Title     string
+	PublishAt time.Time
+}
+
+// parsePublishAt reads a publish time sent as RFC 3339, which the post form
+// converts the author's local time to. An empty value means publish now.
+func parsePublishAt(value string, now time.Time) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	publishAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errors.New("invalid publish time")
+	}
+	if !publishAt.After(now) {
+		return nil, errors.New("publish time must be in the future")
+	}
+	publishAt = publishAt.UTC().Truncate(time.Second)
+	return &publishAt, nil
+}
+
+// scheduledPosts lists the user's posts waiting to be published, soonest first
+func scheduledPosts(userID int) ([]ScheduledPost, error) {
+	rows, err := db.DB.Query(
+		`SELECT id, title, publish_at FROM posts
+		WHERE user_id = ? AND publish_at IS NOT NULL AND deleted_at IS NULL
+		ORDER BY publish_at, id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []ScheduledPost
+	for rows.Next() {
+		var post ScheduledPost
+		if err := rows.Scan(&post.ID, &post.Title, &post.PublishAt); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// ManageScheduledPost lets the author of a scheduled post move it to
+// another time, publish it straight away, or cancel it, which turns it back
+// into a draft. The action form field says which.
+func ManageScheduledPost(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := r.Context().Value(auth.UserSessionKey).(*auth.Session)
+	if !ok || session == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	// Only the author's own posts that are still waiting can be changed
+	const scheduled = `id = ? AND user_id = ? AND publish_at IS NOT NULL AND deleted_at IS NULL`
+	var result sql.Result
+	redirect := "/drafts"
+	switch r.FormValue("action") {
+	case "reschedule":
+		publishAt, err := parsePublishAt(r.FormValue("publish_at"), time.Now())
+		if err != nil || publishAt == nil {
+			http.Error(w, "Choose a publish time in the future", http.StatusBadRequest)
+			return
+		}
+		result, err = db.DB.Exec(`UPDATE posts SET publish_at = ? WHERE `+scheduled, *publishAt, postID, session.UserID)
+		if err != nil {
+			log.Printf("Error rescheduling post %d: %v", postID, err)
+			http.Error(w, "Error rescheduling post", http.StatusInternalServerError)
+			return
+		}
+	case "publish":
+		result, err = db.DB.Exec(`UPDATE posts SET created_at = ?, publish_at = NULL WHERE `+scheduled,
+			time.Now().UTC(), postID, session.UserID)
+		if err != nil {
+			log.Printf("Error publishing post %d: %v", postID, err)
+			http.Error(w, "Error publishing post", http.StatusInternalServerError)
+			return
+		}
+		redirect = fmt.Sprintf("/view-post?id=%d", postID)
+	case "cancel":
+		draftID, err := cancelScheduledPost(session.UserID, postID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Scheduled post not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error cancelling post %d: %v", postID, err)
+			http.Error(w, "Error cancelling post", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/create-post-form?draft=%d", draftID), http.StatusSeeOther)
+		return
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// Published already, or not the user's
+		http.Error(w, "Scheduled post not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// cancelScheduledPost turns the user's scheduled post back into a draft,
+// keeping its image, and returns the draft's id. It returns sql.ErrNoRows
+// if the user has no such scheduled post.
+func cancelScheduledPost(userID, postID int) (int64, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var waiting bool
+	err = tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM posts WHERE id = ? AND user_id = ? AND publish_at IS NOT NULL AND deleted_at IS NULL)`,
+		postID, userID,
+	).Scan(&waiting)
+	if err != nil {
+		return 0, err
+	}
+	if !waiting {
+		return 0, sql.ErrNoRows
+	}
+	post, err := loadPostRecord(tx, postID)
+	if err != nil {
+		return 0, err
+	}
+
+	categories, err := json.Marshal(post.CategoryIDs)
+	if err != nil || post.CategoryIDs == nil {
+		categories = []byte("[]")
+	}
+	now := time.Now().UTC()
+	result, err := tx.Exec(
+		`INSERT INTO drafts (user_id, title, content, image, categories, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, post.Title, post.Content, post.Image, string(categories), now, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	draftID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	images, err := deletePostRows(tx, postID)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// The draft keeps the post's image; older ones from edits can go
+	for _, image := range images {
+		removeUnusedImage(image)
+	}
+	return draftID, nil
+}
+
+// publishDuePosts publishes the scheduled posts whose time has come. They
+// take their publish time as their creation time, so they sort as if posted
+// then.
+func publishDuePosts(now time.Time) (int64, error) {
+	result, err := db.DB.Exec(
+		`UPDATE posts SET created_at = publish_at, publish_at = NULL WHERE publish_at IS NOT NULL AND publish_at <= ?`,
+		now.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// StartScheduledPublishing publishes due posts in the background every interval
+func StartScheduledPublishing(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			n, err := publishDuePosts(time.Now())
+			if err != nil {
+				log.Printf("Error publishing scheduled posts: %v", err)
+			}
+			if n > 0 {
+				log.Printf("Published %d scheduled posts", n)
+			}
+		}
+	}()
+}
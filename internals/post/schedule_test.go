@@ -0,0 +1,213 @@
+package post
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePublishAt(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"Empty", "", "", false},
+		{"Future", "2026-10-18T09:30:00.250+02:00", "2026-10-18T07:30:00Z", false},
+		{"Now", "2026-10-17T12:00:00Z", "", true},
+		{"Past", "2026-10-16T12:00:00Z", "", true},
+		{"Without a time zone", "2026-10-18T09:30", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePublishAt(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.want == "" && got != nil || tt.want != "" && (got == nil || got.Format(time.RFC3339) != tt.want) {
+				t.Errorf("Expected %q, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func schedulePost(title string, at time.Time) url.Values {
+	return url.Values{"title": {title}, "content": {"Release notes"}, "categories[]": {"1"}, "publish_at": {at.Format(time.RFC3339)}}
+}
+
+func TestScheduledPostVisibility(t *testing.T) {
+	setupPostTestDB(t)
+	at := time.Now().Add(time.Hour)
+
+	rec := postAs(CreatePost, 1, "author", "member", schedulePost("Version 2", at))
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/drafts" {
+		t.Fatalf("Expected the post to be scheduled, got %d: %s", rec.Code, rec.Body.String())
+	}
+	past := schedulePost("Too late", time.Now().Add(-time.Minute))
+	if rec := postAs(CreatePost, 1, "author", "member", past); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a past publish time to be refused, got %d", rec.Code)
+	}
+
+	hidden := func() {
+		t.Helper()
+		if posts, _ := FetchPosts(0); len(posts) != 1 {
+			t.Errorf("Expected only the published post on the home page, got %d", len(posts))
+		}
+		if posts, _ := FetchPostsByCategory("technology", 2); len(posts) != 1 {
+			t.Errorf("Expected only the published post in the category, got %d", len(posts))
+		}
+		if _, err := fetchPostFromDB("2", 2); err == nil {
+			t.Error("Expected the scheduled post to be hidden from others")
+		}
+	}
+	hidden()
+	post, err := fetchPostFromDB("2", 1)
+	if err != nil || post.PublishAt == nil || !post.PublishAt.Equal(at.Truncate(time.Second)) {
+		t.Fatalf("Expected the author to see the scheduled post, got %+v, %v", post, err)
+	}
+
+	// Nothing is due yet
+	if n, err := publishDuePosts(time.Now()); err != nil || n != 0 {
+		t.Fatalf("Expected nothing published, got %d, %v", n, err)
+	}
+	hidden()
+
+	if n, err := publishDuePosts(at.Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("Expected one post published, got %d, %v", n, err)
+	}
+	posts, _ := FetchPosts(0)
+	if len(posts) != 2 || posts[0].Title != "Version 2" || !posts[0].CreatedAt.Equal(at.Truncate(time.Second)) {
+		t.Errorf("Expected the post published at its time, newest first, got %+v", posts)
+	}
+	if post, err := fetchPostFromDB("2", 2); err != nil || post.PublishAt != nil {
+		t.Errorf("Expected the post to be visible to everyone, got %+v, %v", post, err)
+	}
+}
+
+func TestManageScheduledPost(t *testing.T) {
+	testDB := setupPostTestDB(t)
+	at := time.Now().Add(time.Hour)
+	postAs(CreatePost, 1, "author", "member", schedulePost("Version 2", at))
+	postAs(CreatePost, 1, "author", "member", schedulePost("Version 3", at))
+	testDB.Exec(`UPDATE posts SET image = '/static/images/release.png' WHERE id = 3`)
+
+	manage := func(postID, action string, publishAt time.Time) url.Values {
+		return url.Values{"post_id": {postID}, "action": {action}, "publish_at": {publishAt.Format(time.RFC3339)}}
+	}
+	later := at.Add(24 * time.Hour)
+	tests := []struct {
+		name     string
+		userID   int
+		username string
+		form     url.Values
+		want     int
+		location string
+	}{
+		{"Someone else's", 2, "stranger", manage("2", "reschedule", later), http.StatusNotFound, ""},
+		{"Into the past", 1, "author", manage("2", "reschedule", time.Now().Add(-time.Hour)), http.StatusBadRequest, ""},
+		{"Unknown action", 1, "author", manage("2", "postpone", later), http.StatusBadRequest, ""},
+		{"Reschedule", 1, "author", manage("2", "reschedule", later), http.StatusSeeOther, "/drafts"},
+		{"Publish now", 1, "author", manage("2", "publish", later), http.StatusSeeOther, "/view-post?id=2"},
+		{"Published already", 1, "author", manage("2", "reschedule", later), http.StatusNotFound, ""},
+		{"Cancel someone else's", 2, "stranger", manage("3", "cancel", later), http.StatusNotFound, ""},
+		{"Cancel", 1, "author", manage("3", "cancel", later), http.StatusSeeOther, "/create-post-form?draft=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postAs(ManageScheduledPost, tt.userID, tt.username, "member", tt.form)
+			if rec.Code != tt.want || rec.Header().Get("Location") != tt.location {
+				t.Errorf("Expected status %d to %q, got %d to %q: %s",
+					tt.want, tt.location, rec.Code, rec.Header().Get("Location"), rec.Body.String())
+			}
+		})
+	}
+
+	if posts, _ := FetchPosts(0); len(posts) != 2 || posts[0].Title != "Version 2" {
+		t.Errorf("Expected the post published now on top, got %+v", posts)
+	}
+
+	// The cancelled post is a draft now, image and all, and publishes from there
+	draft, err := loadDraft(1, 1)
+	if err != nil || draft.Title != "Version 3" || draft.Image == nil || len(draft.CategoryIDs) != 1 {
+		t.Fatalf("Expected the cancelled post as a draft, got %+v, %v", draft, err)
+	}
+	form := url.Values{"draft_id": {"1"}, "title": {"Version 3"}, "content": {"Release notes"}, "categories[]": {"1"}}
+	if rec := postAs(CreatePost, 1, "author", "member", form); rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the draft to be published, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var image string
+	testDB.QueryRow(`SELECT image FROM posts WHERE title = 'Version 3' AND publish_at IS NULL`).Scan(&image)
+	if image != "/static/images/release.png" {
+		t.Errorf("Expected the draft's image on the post, got %q", image)
+	}
+}
+
+func TestScheduledPostHistoryAndReactions(t *testing.T) {
+	testDB := setupPostTestDB(t)
+	postAs(CreatePost, 1, "author", "member", schedulePost("Version 2", time.Now().Add(time.Hour)))
+
+	// The page renders templates relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir("internals/post")
+
+	history := []struct {
+		name   string
+		userID int
+		status int
+	}{
+		{"Anonymous", 0, http.StatusNotFound},
+		{"Stranger", 2, http.StatusNotFound},
+		{"Author", 1, http.StatusOK},
+	}
+	for _, tt := range history {
+		t.Run("History/"+tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/post/history?id=2", nil)
+			if tt.userID != 0 {
+				req = withSession(req, tt.userID, "user", "member")
+			}
+			ServePostHistory(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+
+	// Post 3 is in the trash
+	if _, err := testDB.Exec(`INSERT INTO posts (id, user_id, title, content, deleted_at) VALUES (3, 1, 'Gone', 'Old news', CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatalf("Failed to insert trashed post: %v", err)
+	}
+	reactions := []struct {
+		name   string
+		postID int
+		status int
+	}{
+		{"Published", 1, http.StatusOK},
+		{"Scheduled", 2, http.StatusNotFound},
+		{"Trashed", 3, http.StatusNotFound},
+	}
+	for _, tt := range reactions {
+		t.Run("React/"+tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"post_id": %d, "reaction_type": "LIKE"}`, tt.postID)
+			rec := httptest.NewRecorder()
+			ReactToPost(rec, withSession(httptest.NewRequest(http.MethodPost, "/react", strings.NewReader(body)), 2, "stranger", "member"))
+			if rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+	var count int
+	testDB.QueryRow(`SELECT COUNT(*) FROM post_reactions WHERE post_id != 1`).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected no reactions on hidden posts, got %d", count)
+	}
+}
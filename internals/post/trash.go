@@ -0,0 +1,309 @@
+package post
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/db"
+	"forum/internals/auth"
+	"forum/internals/fails"
+)
+
+// TrashRetention is how long deleted posts and comments can be restored
+// before the purge erases them
+var TrashRetention = 30 * 24 * time.Hour
+
+// TrashItem is a deleted post or comment
+type TrashItem struct {
+	ID int
+	// PostID and PostTitle are the post itself, or the one a comment is on
+	PostID    int
+	PostTitle string
+	// Content is the comment, empty for posts
+	Content   string
+	Author    string
+	DeletedBy string
+	DeletedAt time.Time
+	PurgeAt   time.Time
+}
+
+// trashRights is what a user may see in the trash and restore: everything
+// of the kinds they moderate, otherwise only what they deleted themselves
+type trashRights struct {
+	userID          int
+	posts, comments bool
+}
+
+func rightsOf(session *auth.Session) trashRights {
+	return trashRights{
+		userID:   session.UserID,
+		posts:    session.Can(auth.PermModeratePosts),
+		comments: session.Can(auth.PermModerateComments),
+	}
+}
+
+// trashedPosts lists the deleted posts the user may restore, newest first
+func trashedPosts(rights trashRights) ([]TrashItem, error) {
+	rows, err := db.DB.Query(
+		`SELECT p.id, p.title, author.username, COALESCE(deleter.username, ''), p.deleted_at
+		FROM posts p
+		JOIN users author ON p.user_id = author.id
+		LEFT JOIN users deleter ON p.deleted_by = deleter.id
+		WHERE p.deleted_at IS NOT NULL AND (? OR (p.user_id = ? AND p.deleted_by = ?))
+		ORDER BY p.deleted_at DESC`,
+		rights.posts, rights.userID, rights.userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []TrashItem
+	for rows.Next() {
+		var item TrashItem
+		if err := rows.Scan(&item.ID, &item.PostTitle, &item.Author, &item.DeletedBy, &item.DeletedAt); err != nil {
+			return nil, err
+		}
+		item.PostID = item.ID
+		item.PurgeAt = item.DeletedAt.Add(TrashRetention)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// trashedComments lists the deleted comments the user may restore, newest
+// first. Purged comments have their content blanked and aren't listed.
+func trashedComments(rights trashRights) ([]TrashItem, error) {
+	rows, err := db.DB.Query(
+		`SELECT c.id, c.post_id, p.title, c.content, author.username, COALESCE(deleter.username, ''), c.deleted_at
+		FROM comments c
+		JOIN posts p ON c.post_id = p.id
+		JOIN users author ON c.user_id = author.id
+		LEFT JOIN users deleter ON c.deleted_by = deleter.id
+		WHERE c.deleted_at IS NOT NULL AND c.content != '' AND (? OR (c.user_id = ? AND c.deleted_by = ?))
+		ORDER BY c.deleted_at DESC`,
+		rights.comments, rights.userID, rights.userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []TrashItem
+	for rows.Next() {
+		var item TrashItem
+		if err := rows.Scan(&item.ID, &item.PostID, &item.PostTitle, &item.Content,
+			&item.Author, &item.DeletedBy, &item.DeletedAt); err != nil {
+			return nil, err
+		}
+		item.PurgeAt = item.DeletedAt.Add(TrashRetention)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// ServeTrash lists the deleted posts and comments the user can restore
+func ServeTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fails.ErrorPageHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := r.Context().Value(auth.UserSessionKey).(*auth.Session)
+	if !ok || session == nil {
+		fails.ErrorPageHandler(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	rights := rightsOf(session)
+	posts, err := trashedPosts(rights)
+	if err != nil {
+		log.Printf("Error listing deleted posts: %v", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	comments, err := trashedComments(rights)
+	if err != nil {
+		log.Printf("Error listing deleted comments: %v", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		PageData  PageData
+		Posts     []TrashItem
+		Comments  []TrashItem
+		Retention string
+	}{
+		PageData: PageData{
+			IsLoggedIn: true,
+			UserName:   session.UserName,
+			CSRFToken:  session.CSRFToken,
+			Role:       session.Role,
+		},
+		Posts:     posts,
+		Comments:  comments,
+		Retention: retentionText(TrashRetention),
+	}
+
+	tmpl, err := template.ParseFiles("templates/trash.html")
+	if err != nil {
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Println("Template execution error:", err)
+		fails.ErrorPageHandler(w, r, http.StatusInternalServerError)
+	}
+}
+
+// retentionText describes the retention period, in days when it is whole days
+func retentionText(retention time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case retention == day:
+		return "1 day"
+	case retention%day == 0:
+		return strconv.Itoa(int(retention/day)) + " days"
+	default:
+		return retention.String()
+	}
+}
+
+// RestoreFromTrash brings back a deleted post or comment, named by the
+// kind ("post" or "comment") and id form fields
+func RestoreFromTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := r.Context().Value(auth.UserSessionKey).(*auth.Session)
+	if !ok || session == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	rights := rightsOf(session)
+	var result sql.Result
+	switch r.FormValue("kind") {
+	case "post":
+		result, err = db.DB.Exec(
+			`UPDATE posts SET deleted_at = NULL, deleted_by = NULL
+			WHERE id = ? AND deleted_at IS NOT NULL AND (? OR (user_id = ? AND deleted_by = ?))`,
+			id, rights.posts, rights.userID, rights.userID,
+		)
+	case "comment":
+		result, err = db.DB.Exec(
+			`UPDATE comments SET deleted_at = NULL, deleted_by = NULL
+			WHERE id = ? AND deleted_at IS NOT NULL AND content != '' AND (? OR (user_id = ? AND deleted_by = ?))`,
+			id, rights.comments, rights.userID, rights.userID,
+		)
+	default:
+		http.Error(w, "Unknown kind", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error restoring %s %d: %v", r.FormValue("kind"), id, err)
+		http.Error(w, "Error restoring", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// Either not in the trash, or not the user's to restore
+		http.Error(w, "Nothing to restore", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// purgeTrash erases posts and comments deleted longer ago than the
+// retention period. A purged comment that still has replies keeps its row,
+// with the content blanked, so the replies keep their place.
+func purgeTrash(retention time.Duration) (posts, comments int64, err error) {
+	cutoff := time.Now().UTC().Add(-retention)
+
+	rows, err := db.DB.Query(`SELECT id FROM posts WHERE deleted_at IS NOT NULL AND deleted_at < ?`, cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	var postIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		postIDs = append(postIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	for _, id := range postIDs {
+		if err := erasePost(id); err != nil {
+			return posts, 0, err
+		}
+		posts++
+	}
+
+	// Removing a reply can leave the comment it answered without any, so
+	// repeat until nothing more goes
+	const expired = `deleted_at IS NOT NULL AND deleted_at < ?`
+	const childless = `NOT EXISTS (SELECT 1 FROM comments reply WHERE reply.parent_id = comments.id)`
+	for {
+		if _, err := db.DB.Exec(`DELETE FROM comment_reactions WHERE comment_id IN
+			(SELECT id FROM comments WHERE `+expired+` AND `+childless+`)`, cutoff); err != nil {
+			return posts, comments, err
+		}
+		result, err := db.DB.Exec(`DELETE FROM comments WHERE `+expired+` AND `+childless, cutoff)
+		if err != nil {
+			return posts, comments, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return posts, comments, err
+		}
+		if n == 0 {
+			break
+		}
+		comments += n
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM comment_reactions WHERE comment_id IN
+		(SELECT id FROM comments WHERE `+expired+`)`, cutoff); err != nil {
+		return posts, comments, err
+	}
+	result, err := db.DB.Exec(`UPDATE comments SET content = '' WHERE `+expired+` AND content != ''`, cutoff)
+	if err != nil {
+		return posts, comments, err
+	}
+	n, err := result.RowsAffected()
+	return posts, comments + n, err
+}
+
+// StartTrashPurge erases expired posts and comments from the trash in the
+// background every interval
+func StartTrashPurge(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			posts, comments, err := purgeTrash(TrashRetention)
+			if err != nil {
+				log.Printf("Error purging the trash: %v", err)
+			}
+			if posts > 0 || comments > 0 {
+				log.Printf("Purged %d posts and %d comments from the trash", posts, comments)
+			}
+		}
+	}()
+}
@@ -0,0 +1,144 @@
+package post
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreFromTrash(t *testing.T) {
+	testDB := setupPostTestDB(t)
+	_, err := testDB.Exec(`
+		INSERT INTO posts (id, user_id, title, content) VALUES (2, 1, 'Removed by a moderator', 'Against the rules');
+		INSERT INTO comments (id, post_id, user_id, content) VALUES (1, 1, 2, 'Regretted');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postAs(DeletePost, 1, "author", "member", url.Values{"post_id": {"1"}})
+	postAs(DeletePost, 3, "moderator", "moderator", url.Values{"post_id": {"2"}})
+	testDB.Exec(`UPDATE comments SET deleted_at = CURRENT_TIMESTAMP, deleted_by = 2 WHERE id = 1`)
+
+	restore := func(kind, id string) url.Values { return url.Values{"kind": {kind}, "id": {id}} }
+	tests := []struct {
+		name     string
+		userID   int
+		username string
+		role     string
+		form     url.Values
+		want     int
+	}{
+		{"Someone else's post", 2, "stranger", "member", restore("post", "1"), http.StatusNotFound},
+		{"Post a moderator deleted", 1, "author", "member", restore("post", "2"), http.StatusNotFound},
+		{"Unknown kind", 1, "author", "member", restore("user", "1"), http.StatusBadRequest},
+		{"Own post", 1, "author", "member", restore("post", "1"), http.StatusSeeOther},
+		{"Post that isn't deleted", 1, "author", "member", restore("post", "1"), http.StatusNotFound},
+		{"Comment as a post moderator", 3, "moderator", "moderator", restore("comment", "1"), http.StatusNotFound},
+		{"Own comment", 2, "stranger", "member", restore("comment", "1"), http.StatusSeeOther},
+		{"Moderator", 3, "moderator", "moderator", restore("post", "2"), http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postAs(RestoreFromTrash, tt.userID, tt.username, tt.role, tt.form); rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	posts, err := FetchPosts(0)
+	if err != nil || len(posts) != 2 || posts[0].CommentCount+posts[1].CommentCount != 1 {
+		t.Errorf("Expected both posts and the comment back, got %+v (%v)", posts, err)
+	}
+}
+
+func TestPurgeTrashComments(t *testing.T) {
+	testDB := setupPostTestDB(t)
+
+	// 1 <- 2 <- 3 are all deleted and long expired; 4 is deleted but still
+	// has a live reply, 5; 6 was deleted recently
+	old := time.Now().UTC().Add(-TrashRetention - time.Hour)
+	_, err := testDB.Exec(`
+		INSERT INTO comments (id, post_id, user_id, parent_id, content, deleted_at, deleted_by) VALUES
+			(1, 1, 2, NULL, 'Root', ?1, 2),
+			(2, 1, 2, 1, 'Reply', ?1, 2),
+			(3, 1, 2, 2, 'Reply to the reply', ?1, 2),
+			(4, 1, 2, NULL, 'Answered', ?1, 2),
+			(5, 1, 1, 4, 'The answer', NULL, NULL),
+			(6, 1, 2, NULL, 'Recent', CURRENT_TIMESTAMP, 2);
+		INSERT INTO comment_reactions (comment_id, user_id, reaction_type) VALUES (3, 1, 'LIKE'), (4, 1, 'LIKE'), (5, 2, 'LIKE');
+	`, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts, comments, err := purgeTrash(TrashRetention)
+	if err != nil || posts != 0 || comments != 4 {
+		t.Fatalf("Expected 4 comments purged, got %d posts and %d comments (%v)", posts, comments, err)
+	}
+
+	rows, err := testDB.Query(`SELECT id, content FROM comments ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var left []string
+	for rows.Next() {
+		var id, content string
+		rows.Scan(&id, &content)
+		left = append(left, id+":"+content)
+	}
+	if got := strings.Join(left, ","); got != "4:,5:The answer,6:Recent" {
+		t.Errorf("Unexpected comments left: %s", got)
+	}
+	var reactions int
+	testDB.QueryRow(`SELECT COUNT(*) FROM comment_reactions`).Scan(&reactions)
+	if reactions != 1 {
+		t.Errorf("Expected only the live reply's reaction to remain, got %d", reactions)
+	}
+}
+
+func TestServeTrash(t *testing.T) {
+	testDB := setupPostTestDB(t)
+	testDB.Exec(`INSERT INTO posts (id, user_id, title, content) VALUES (2, 2, 'Not mine', 'Somebody else')`)
+	postAs(DeletePost, 1, "author", "member", url.Values{"post_id": {"1"}})
+	postAs(DeletePost, 2, "stranger", "member", url.Values{"post_id": {"2"}})
+
+	// The page renders templates relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("Could not change directory: %v", err)
+	}
+	defer os.Chdir("internals/post")
+
+	for _, tt := range []struct {
+		userID   int
+		username string
+		role     string
+		see      []string
+		miss     []string
+	}{
+		{1, "author", "member", []string{"First title", "30 days"}, []string{"Not mine"}},
+		{3, "moderator", "moderator", []string{"First title", "Not mine"}, nil},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/trash", nil)
+		rec := httptest.NewRecorder()
+		ServeTrash(rec, withSession(req, tt.userID, tt.username, tt.role))
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", tt.username, rec.Code)
+		}
+		for _, want := range tt.see {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: expected the trash to show %q", tt.username, want)
+			}
+		}
+		for _, unwanted := range tt.miss {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("%s: expected the trash not to show %q", tt.username, unwanted)
+			}
+		}
+	}
+}
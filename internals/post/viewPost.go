@@ -33,6 +33,7 @@ func FetchPosts(userID int64) ([]Post, error) {
 			&imgPtr, // Scan into the temporary image pointer
 			&post.UserName,
 			&post.CreatedAt,
+			&post.EditedAt,
 			&post.CommentCount,
 			&post.Likes,
 			&post.Dislikes,
@@ -64,17 +65,19 @@ func fetchPostFromDB(postID string, userID int64) (*Post, error) {
 	var post Post
 
 	// Execute the query.
-	err := db.DB.QueryRow(FetchPostWithUserReaction, userID, postID).Scan(
+	err := db.DB.QueryRow(FetchPostWithUserReaction, userID, postID, userID).Scan(
 		&post.ID,
 		&post.Title,
 		&post.Content,
 		&post.Image,
 		&post.UserName,
 		&post.CreatedAt,
+		&post.EditedAt,
 		&post.CommentCount,
 		&post.Likes,
 		&post.Dislikes,
 		&post.UserReaction, // Populate the UserReaction field
+		&post.PublishAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -121,6 +124,8 @@ func ViewPost(w http.ResponseWriter, r *http.Request) {
 		pageData = PageData{
 			IsLoggedIn: true,
 			UserName:   session.UserName,
+			CSRFToken:  session.CSRFToken,
+			Role:       session.Role,
 		}
 		userID = int64(session.UserID)
 	}
@@ -144,12 +149,15 @@ func ViewPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Usernames are unique, so the name identifies the author
 	response := struct {
 		PageData
-		Post *Post
+		Post    *Post
+		CanEdit bool
 	}{
 		PageData: pageData,
 		Post:     post,
+		CanEdit:  session != nil && (session.UserName == post.UserName || session.Can(auth.PermModeratePosts)),
 	}
 
 	tmpl := template.Must(template.ParseFiles("templates/viewPost.html"))
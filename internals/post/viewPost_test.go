@@ -32,6 +32,11 @@ func TestFetchPostFromDB(t *testing.T) {
 			image TEXT,
 			user_id INTEGER NOT NULL,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT NULL,
+			updated_by INTEGER DEFAULT NULL,
+			deleted_at DATETIME DEFAULT NULL,
+			deleted_by INTEGER DEFAULT NULL,
+			publish_at DATETIME DEFAULT NULL,
 			FOREIGN KEY (user_id) REFERENCES users(id)
 		);
 
@@ -41,6 +46,8 @@ func TestFetchPostFromDB(t *testing.T) {
 			user_id INTEGER NOT NULL,
 			content TEXT NOT NULL,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			deleted_at DATETIME DEFAULT NULL,
+			deleted_by INTEGER DEFAULT NULL,
 			FOREIGN KEY (post_id) REFERENCES posts(id),
 			FOREIGN KEY (user_id) REFERENCES users(id)
 		);
@@ -187,6 +194,11 @@ func TestFetchPosts(t *testing.T) {
 			image TEXT,
 			user_id INTEGER NOT NULL,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT NULL,
+			updated_by INTEGER DEFAULT NULL,
+			deleted_at DATETIME DEFAULT NULL,
+			deleted_by INTEGER DEFAULT NULL,
+			publish_at DATETIME DEFAULT NULL,
 			FOREIGN KEY (user_id) REFERENCES users(id)
 		);
 
@@ -196,6 +208,8 @@ func TestFetchPosts(t *testing.T) {
 			user_id INTEGER NOT NULL,
 			content TEXT NOT NULL,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			deleted_at DATETIME DEFAULT NULL,
+			deleted_by INTEGER DEFAULT NULL,
 			FOREIGN KEY (post_id) REFERENCES posts(id),
 			FOREIGN KEY (user_id) REFERENCES users(id)
 		);
@@ -21,28 +21,66 @@ func RegisteringRoutes() *http.ServeMux {
 	// Post Routes.
 	mux.HandleFunc("/posts", post.ServePosts)
 	mux.HandleFunc("/view-post", post.ViewPost)
-	mux.HandleFunc("/create-post-form", auth.Middleware(http.HandlerFunc(post.ServeCreatePostForm)))
-	mux.HandleFunc("/upload-image", auth.Middleware(http.HandlerFunc(post.UploadImage)))
+	mux.HandleFunc("/create-post-form", auth.Middleware(auth.RequireVerified(auth.ActionPost, http.HandlerFunc(post.ServeCreatePostForm))))
+	mux.HandleFunc("/upload-image", auth.Middleware(auth.RequireVerified(auth.ActionPost, http.HandlerFunc(post.UploadImage))))
 	mux.HandleFunc("/categories", post.ServeCategories)
-	mux.HandleFunc("/create-post", auth.Middleware(http.HandlerFunc(post.CreatePost)))
+	mux.HandleFunc("/create-post", auth.Middleware(auth.RequireVerified(auth.ActionPost, http.HandlerFunc(post.CreatePost))))
 	mux.HandleFunc("/post/react", auth.Middleware(http.HandlerFunc(post.ReactToPost)))
+	mux.HandleFunc("/edit-post-form", auth.Middleware(auth.RequireVerified(auth.ActionPost, http.HandlerFunc(post.ServeEditPostForm))))
+	mux.HandleFunc("/edit-post", auth.Middleware(auth.RequireVerified(auth.ActionPost, http.HandlerFunc(post.EditPost))))
+	mux.HandleFunc("/post/history", post.ServePostHistory)
+	mux.HandleFunc("/delete-post", auth.Middleware(http.HandlerFunc(post.DeletePost)))
+	mux.HandleFunc("/drafts", auth.Middleware(http.HandlerFunc(post.ServeDrafts)))
+	mux.HandleFunc("/drafts/save", auth.Middleware(auth.RequireVerified(auth.ActionPost, http.HandlerFunc(post.SaveDraft))))
+	mux.HandleFunc("/drafts/delete", auth.Middleware(http.HandlerFunc(post.DeleteDraft)))
+	mux.HandleFunc("/post/schedule", auth.Middleware(auth.RequireVerified(auth.ActionPost, http.HandlerFunc(post.ManageScheduledPost))))
+	mux.HandleFunc("/trash", auth.Middleware(http.HandlerFunc(post.ServeTrash)))
+	mux.HandleFunc("/trash/restore", auth.Middleware(http.HandlerFunc(post.RestoreFromTrash)))
 
 	// Auth Routes.
 	mux.HandleFunc("/signup", auth.Signup)
 	mux.HandleFunc("/login", auth.Login)
-	mux.HandleFunc("/logout", auth.Logout)
+	mux.HandleFunc("/login/2fa", auth.LoginTwoFactor)
+	mux.HandleFunc("/login/magic", auth.MagicLinkLogin)
+	mux.HandleFunc("/login/magic/request", auth.RequestMagicLink)
+	mux.HandleFunc("/logout", auth.Middleware(auth.RequireBrowserSession(http.HandlerFunc(auth.Logout))))
+	mux.HandleFunc("/forgot-password", auth.ForgotPassword)
+	mux.HandleFunc("/reset-password", auth.ResetPassword)
+	mux.HandleFunc("/verify-email", auth.VerifyEmail)
+	mux.HandleFunc("/verify-email/resend", auth.Middleware(auth.RequireBrowserSession(http.HandlerFunc(auth.ResendVerification))))
 
-	// Google Auth Routes.
-	mux.HandleFunc("/auth/google", auth.InitiateGoogleAuth)
-	mux.HandleFunc("/auth/google/callback", auth.HandleGoogleCallback)
+	// Account Routes. API tokens can't be used to manage the account itself.
+	account := func(handler http.HandlerFunc) http.HandlerFunc {
+		return auth.Middleware(auth.RequireBrowserSession(handler))
+	}
+	mux.HandleFunc("/account/devices", account(auth.ServeDevices))
+	mux.HandleFunc("/account/devices/revoke", account(auth.RevokeSession))
+	mux.HandleFunc("/account/devices/revoke-all", account(auth.RevokeAllSessions))
+	mux.HandleFunc("/api/sessions", account(auth.ListSessions))
+	mux.HandleFunc("/account/security", account(auth.ServeSecurity))
+	mux.HandleFunc("/account/2fa/setup", account(auth.SetupTwoFactor))
+	mux.HandleFunc("/account/2fa/confirm", account(auth.ConfirmTwoFactor))
+	mux.HandleFunc("/account/2fa/recovery-codes", account(auth.RegenerateRecoveryCodes))
+	mux.HandleFunc("/account/2fa/disable", account(auth.DisableTwoFactor))
+	mux.HandleFunc("/account/identities/link", account(auth.LinkIdentity))
+	mux.HandleFunc("/account/identities/unlink", account(auth.UnlinkIdentity))
+	mux.HandleFunc("/account/tokens", account(auth.ListAPITokens))
+	mux.HandleFunc("/account/tokens/create", account(auth.CreateAPIToken))
+	mux.HandleFunc("/account/tokens/revoke", account(auth.RevokeAPIToken))
 
-	// GitHub Auth Routes.
-	mux.HandleFunc("/auth/github", auth.InitiateGitHubAuth)
-	mux.HandleFunc("/auth/github/callback", auth.HandleGitHubCallback)
+	// Admin Routes.
+	admin := func(handler http.HandlerFunc) http.HandlerFunc {
+		return auth.Middleware(auth.RequireBrowserSession(auth.RequirePermission(auth.PermManageUsers, handler)))
+	}
+	mux.HandleFunc("/admin/users/role", admin(auth.ChangeUserRole))
+	mux.HandleFunc("/admin/invites", admin(auth.ListInvites))
+	mux.HandleFunc("/admin/invites/create", admin(auth.CreateInvite))
+	mux.HandleFunc("/admin/invites/revoke", admin(auth.RevokeInvite))
+	mux.HandleFunc("/admin/auth-events", admin(auth.ListAuthEvents))
 
-	// Facebook Auth Routes
-	mux.HandleFunc("/auth/facebook/login", auth.InitiateFacebookAuth)
-	mux.HandleFunc("/auth/facebook/callback", auth.HandleFacebookCallback)
+	// OAuth login: /auth/<provider> and /auth/<provider>/callback for every
+	// configured provider
+	mux.HandleFunc("/auth/", auth.HandleOAuth)
 
 	// Filter Routes.
 	mux.HandleFunc("/category", post.ViewPostsByCategory)
@@ -51,8 +89,9 @@ func RegisteringRoutes() *http.ServeMux {
 
 	// Comment Routes
 	mux.HandleFunc("/comments", comments.GetComments)
-	mux.HandleFunc("/comments/create", auth.Middleware(http.HandlerFunc(comments.CreateComment)))
+	mux.HandleFunc("/comments/create", auth.Middleware(auth.RequireVerified(auth.ActionComment, http.HandlerFunc(comments.CreateComment))))
 	mux.HandleFunc("/comments/react", auth.Middleware(http.HandlerFunc(comments.ReactToComment)))
+	mux.HandleFunc("/comments/delete", auth.Middleware(http.HandlerFunc(comments.DeleteComment)))
 
 	// static
 	mux.HandleFunc("/static/", serveStatic)
@@ -70,6 +109,11 @@ func serveStatic(w http.ResponseWriter, r *http.Request) {
 
 	// Check if the file exists and is not a directory
 	info, err := os.Stat(filePath)
+	if err != nil && strings.HasPrefix(r.URL.Path, "/static/images/") {
+		// Uploaded images may live outside the static directory
+		filePath = filepath.Join(post.UploadDir, path.Base(r.URL.Path))
+		info, err = os.Stat(filePath)
+	}
 	if err != nil || info.IsDir() {
 		fails.ErrorPageHandler(w, r, http.StatusForbidden)
 		return
@@ -1,24 +1,92 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"forum/db"
+	"forum/internals/auth"
+	"forum/internals/config"
+	"forum/internals/mail"
+	"forum/internals/post"
 	"forum/internals/routes"
 )
 
 func main() {
+	configPath := flag.String("config", "config.json", "path to the JSON config file")
+	showLockouts := flag.Bool("lockouts", false, "print recent login lockouts and exit")
+	promote := flag.String("promote", "", "give the named user the -role role and exit")
+	role := flag.String("role", auth.RoleAdmin, "role given by -promote")
+	flag.Parse()
+
+	// The default config file is optional, but one named on the command line must exist
+	configSet := false
+	flag.Visit(func(f *flag.Flag) { configSet = configSet || f.Name == "config" })
+	cfg, err := config.Load(*configPath, configSet)
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
 	// Initialize the database
-	err := db.Initialize()
+	err = db.Initialize(cfg.DBPath)
 	if err != nil {
 		log.Fatalf("Error initializing database: %v", err)
 	}
 	defer db.Close()
 
+	if *showLockouts {
+		printLockouts()
+		return
+	}
+	if *promote != "" {
+		if err := auth.SetUserRole(*promote, *role); err != nil {
+			log.Fatalf("Error giving %s the %s role: %v", *promote, *role, err)
+		}
+		fmt.Printf("%s is now %s\n", *promote, *role)
+		return
+	}
+
+	auth.Configure(cfg)
+	post.UploadDir = cfg.UploadDir
+	post.TrashRetention = cfg.Trash.Retention.Duration
+	mail.Default = &mail.SMTPMailer{
+		Addr:     cfg.Mail.SMTPAddr,
+		From:     cfg.Mail.From,
+		Username: cfg.Mail.Username,
+		Password: cfg.Mail.Password,
+	}
+
+	// Purge expired sessions in the background
+	auth.StartSessionCleanup(time.Hour)
+	// Erase posts and comments once they've been in the trash too long
+	post.StartTrashPurge(time.Hour)
+	// Publish scheduled posts once their time comes
+	post.StartScheduledPublishing(time.Minute)
+
 	mux := routes.RegisteringRoutes()
 
-	fmt.Println("Server running http://localhost:8080/  and go to /login to login")
-	http.ListenAndServe(":8080", mux)
+	fmt.Printf("Server running %s/  and go to /login to login\n", cfg.BaseURL)
+	if err := http.ListenAndServe(cfg.ListenAddr, mux); err != nil {
+		log.Fatalf("Server stopped: %v", err)
+	}
+}
+
+// printLockouts lists the most recent login lockouts for administrators
+func printLockouts() {
+	lockouts, err := auth.RecentLockouts(50)
+	if err != nil {
+		log.Fatalf("Error reading lockouts: %v", err)
+	}
+	if len(lockouts) == 0 {
+		fmt.Println("No login lockouts recorded")
+		return
+	}
+	for _, l := range lockouts {
+		fmt.Printf("%s  %-7s  %-30s  %2d failures  locked until %s\n",
+			l.LockedAt.Local().Format(time.DateTime), l.Scope, l.Key, l.Failures,
+			l.LockedUntil.Local().Format(time.DateTime))
+	}
 }
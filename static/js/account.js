@@ -0,0 +1,26 @@
+// Shared behaviour for the account settings pages
+
+const accountUserBtn = document.querySelector(".user-dropdown .nav-btn");
+const accountUserMenu = document.querySelector(".user-menu");
+if (accountUserBtn && accountUserMenu) {
+    accountUserBtn.addEventListener("click", (e) => {
+        e.stopPropagation();
+        accountUserMenu.classList.toggle("show");
+    });
+    document.addEventListener("click", (e) => {
+        if (!accountUserMenu.contains(e.target)) {
+            accountUserMenu.classList.remove("show");
+        }
+    });
+}
+
+/**
+ * Display a status message in one of the page's message boxes
+ * @param {HTMLElement} element - The message element
+ * @param {string} message - The message to display
+ * @param {string} type - Type of message ('success' or 'error')
+ */
+function showAccountMessage(element, message, type = "error") {
+    element.textContent = message;
+    element.className = `account-message ${type}`;
+}
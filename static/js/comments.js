@@ -1,3 +1,4 @@
+// goToLogin comes from index.js, which every page with comments loads first
 
 const commentForm = document.getElementById("comment-form");
 
@@ -28,8 +29,12 @@ commentForm.addEventListener("submit", async (event) => {
             alert("Comment cannot be empty.");
             return;
         }
+        if (response.status === 403) {
+            alert(JSON.parse(text).message);
+            return;
+        }
         if (!response.ok || text.startsWith("<")) {
-            window.location.href = "/login";
+            goToLogin();
             return;
         }
 
@@ -75,6 +80,14 @@ document.addEventListener("DOMContentLoaded", () => {
         comment.classList.add("comment");
         comment.id = `comment-${commentData.id}`;
         comment.dataset.level = level;
+
+        // Deleted comments only hold the place of their replies
+        if (commentData.deleted) {
+            comment.classList.add("deleted");
+            comment.innerHTML = `<p class="comment-content">${escapeHTML(commentData.content)}</p>`;
+            return comment;
+        }
+
         comment.innerHTML = `
             <div class="comment-header">
                 <span class="comment-author">${commentData.username}</span>
@@ -92,6 +105,7 @@ document.addEventListener("DOMContentLoaded", () => {
         `;
 
         if (level < MAX_NESTING_LEVEL) addReplyButton(comment, commentData, postID, level);
+        if (commentData.can_delete) addDeleteButton(comment, commentData);
 
         // Add event listeners for thumbs-up and thumbs-down
         const thumbsUpButton = comment.querySelector(".thumbs-up");
@@ -115,9 +129,14 @@ document.addEventListener("DOMContentLoaded", () => {
             });
 
             const text = await response.text(); // Read the response as text first
+            if (response.status === 403 && !text.startsWith("<")) {
+                // Logged in, but not allowed (e.g. a stale CSRF token)
+                alert(JSON.parse(text).message);
+                return;
+            }
             if (!response.ok || text.startsWith("<")) {
                 // Redirect to login if the response is HTML or not OK
-                window.location.href = "/login";
+                goToLogin();
                 return;
             }
 
@@ -156,6 +175,40 @@ document.addEventListener("DOMContentLoaded", () => {
         comment.appendChild(replyButton);
     };
 
+    const addDeleteButton = (comment, commentData) => {
+        const deleteButton = document.createElement("button");
+        deleteButton.textContent = "Delete";
+        deleteButton.classList.add("reply-btn");
+        deleteButton.addEventListener("click", () => deleteComment(commentData.id));
+        comment.appendChild(deleteButton);
+    };
+
+    const deleteComment = async (commentID) => {
+        if (!confirm("Delete this comment? Replies to it stay.")) {
+            return;
+        }
+        try {
+            const response = await fetch("/comments/delete", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ comment_id: commentID }),
+            });
+            const text = await response.text();
+            if (text.startsWith("<")) {
+                goToLogin();
+                return;
+            }
+            if (!response.ok) {
+                alert(JSON.parse(text).message || "Failed to delete comment.");
+                return;
+            }
+            init();
+        } catch (error) {
+            console.error("Error deleting comment:", error);
+            alert("Failed to delete comment.");
+        }
+    };
+
     const toggleReplyForm = (comment, commentData, postID) => {
         const existingReplyForm = comment.querySelector(".reply-form");
         if (existingReplyForm) {
@@ -193,8 +246,12 @@ document.addEventListener("DOMContentLoaded", () => {
         })
             .then((response) => {
                 return response.text().then((text) => {
+                    if (response.status === 403) {
+                        alert(JSON.parse(text).message);
+                        return;
+                    }
                     if (!response.ok || text.startsWith("<")) {
-                        window.location.href = "/login";
+                        goToLogin();
                         return;
                     }
                     return JSON.parse(text);
@@ -211,7 +268,8 @@ document.addEventListener("DOMContentLoaded", () => {
                         created_at: data.created_at,
                         likes: 0,
                         dislikes: 0,
-                        user_reaction: null
+                        user_reaction: null,
+                        can_delete: true
                     }, replyLevel, postID);
                     let repliesContainer = comment.nextElementSibling;
                     if (!repliesContainer || !repliesContainer.classList.contains("replies-container")) {
@@ -305,7 +363,7 @@ document.addEventListener("DOMContentLoaded", () => {
 const countComments = (comments) => {
     let count = 0;
     comments.forEach(comment => {
-        count += 1; // Count the current comment
+        if (!comment.deleted) count += 1; // Count the current comment, unless it is only a placeholder
         if (comment.children && comment.children.length > 0) {
             count += countComments(comment.children); // Recursively count nested comments
         }
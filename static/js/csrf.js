@@ -0,0 +1,33 @@
+// Send the page's CSRF token with every same-origin request that changes
+// something. Load this before any script that calls fetch.
+(function () {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    if (!meta || !meta.content) {
+        return;
+    }
+
+    const originalFetch = window.fetch;
+    window.fetch = async function (input, init = {}) {
+        const request = input instanceof Request ? input : null;
+        const method = (init.method || (request ? request.method : "GET")).toUpperCase();
+        const url = new URL(request ? request.url : input, window.location.href);
+        const sameOrigin = url.origin === window.location.origin;
+
+        if (!["GET", "HEAD", "OPTIONS"].includes(method) && sameOrigin) {
+            const headers = new Headers(init.headers || (request ? request.headers : undefined));
+            headers.set("X-CSRF-Token", meta.content);
+            init = { ...init, headers };
+        }
+
+        const response = await originalFetch.call(this, input, init);
+        // The server rotates the token after security changes such as enabling 2FA
+        const rotated = sameOrigin && response.headers.get("X-CSRF-Token");
+        if (rotated) {
+            meta.content = rotated;
+            document.querySelectorAll('input[name="csrf_token"]').forEach((field) => {
+                field.value = rotated;
+            });
+        }
+        return response;
+    };
+})();
@@ -0,0 +1,49 @@
+const devicesMessage = document.getElementById("devices-message");
+
+const showDevicesError = (message) => showAccountMessage(devicesMessage, message);
+
+// Sign out a single device
+document.querySelectorAll(".revoke-session").forEach((button) => {
+    button.addEventListener("click", async () => {
+        const sessionID = button.dataset.sessionId;
+        try {
+            const response = await fetch("/account/devices/revoke", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ id: sessionID }),
+            });
+            const data = await response.json();
+            if (!response.ok) {
+                showDevicesError(data.message || "Failed to sign out device.");
+                return;
+            }
+            if (data.current) {
+                window.location.href = "/login";
+                return;
+            }
+            button.closest(".account-list-item").remove();
+        } catch (error) {
+            console.error("Error revoking session:", error);
+            showDevicesError("An error occurred. Please try again.");
+        }
+    });
+});
+
+// Sign out every device, including this one
+document.getElementById("revoke-all").addEventListener("click", async () => {
+    if (!confirm("Log out of every device, including this one?")) {
+        return;
+    }
+    try {
+        const response = await fetch("/account/devices/revoke-all", { method: "POST" });
+        if (!response.ok) {
+            const data = await response.json();
+            showDevicesError(data.message || "Failed to log out everywhere.");
+            return;
+        }
+        window.location.href = "/login";
+    } catch (error) {
+        console.error("Error revoking sessions:", error);
+        showDevicesError("An error occurred. Please try again.");
+    }
+});
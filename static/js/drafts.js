@@ -0,0 +1,24 @@
+// Scheduled times are stored in UTC. Show them in the reader's own time, and
+// send the time picked for a reschedule with its time zone.
+const pad = (n) => String(n).padStart(2, "0");
+
+document.querySelectorAll("time.local-time").forEach((time) => {
+  time.textContent = new Date(time.dateTime).toLocaleString([], { dateStyle: "medium", timeStyle: "short" });
+});
+
+document.querySelectorAll(".schedule-form").forEach((form) => {
+  const local = form.querySelector(".publish-at-local");
+  const publishAt = form.querySelector('input[name="publish_at"]');
+  const current = new Date(form.closest("li").querySelector("time.local-time").dateTime);
+  local.value = `${current.getFullYear()}-${pad(current.getMonth() + 1)}-${pad(current.getDate())}T${pad(current.getHours())}:${pad(current.getMinutes())}`;
+
+  form.addEventListener("submit", (event) => {
+    if (local.value) {
+      publishAt.value = new Date(local.value).toISOString();
+    }
+    if (event.submitter && event.submitter.value === "cancel" &&
+      !confirm("Cancel this post? It goes back to your drafts.")) {
+      event.preventDefault();
+    }
+  });
+});
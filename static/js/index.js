@@ -1,3 +1,8 @@
+// goToLogin sends the user to log in, coming back to this page afterwards
+function goToLogin() {
+    window.location.href = "/login?next=" + encodeURIComponent(window.location.pathname + window.location.search);
+}
+
 document.querySelectorAll(".sort-btn").forEach((btn) => {
     btn.addEventListener("click", function () {
         document.querySelector(".sort-btn.active").classList.remove("active");
@@ -71,9 +76,14 @@ document.addEventListener("DOMContentLoaded", () => {
             });
 
             const text = await response.text(); // Read the response as text first
+            if (response.status === 403 && !text.startsWith("<")) {
+                // Logged in, but not allowed (e.g. a stale CSRF token)
+                alert(JSON.parse(text).message);
+                return;
+            }
             if (!response.ok || text.startsWith("<")) {
                 // Redirect to login if the response is HTML or not OK
-                window.location.href = "/login";
+                goToLogin();
                 return;
             }
 
@@ -110,4 +120,36 @@ document.addEventListener("DOMContentLoaded", () => {
     };
 });
 
+// Resend the email verification link from the home page banner
+document.addEventListener("DOMContentLoaded", () => {
+    const resendButton = document.getElementById("resendVerification");
+    if (!resendButton) {
+        return;
+    }
 
+    resendButton.addEventListener("click", async () => {
+        resendButton.disabled = true;
+        try {
+            const response = await fetch("/verify-email/resend", { method: "POST" });
+            const data = await response.json();
+            document.getElementById("verifyBannerText").textContent = data.message;
+        } catch (error) {
+            console.error("Error resending verification email:", error);
+            resendButton.disabled = false;
+        }
+    });
+});
+
+// Ask before deleting a post from its page
+document.addEventListener("DOMContentLoaded", () => {
+    const deleteForm = document.querySelector(".delete-post-form");
+    if (!deleteForm) {
+        return;
+    }
+
+    deleteForm.addEventListener("submit", (event) => {
+        if (!confirm("Move this post to the trash? It can be restored from there for a while.")) {
+            event.preventDefault();
+        }
+    });
+});
@@ -4,6 +4,8 @@ fetch("/categories")
   .then((categories) => {
     const categoriesGrid = document.getElementById("categories-grid");
     categoriesGrid.className = "categories-grid";
+    // When editing, the post's current categories start out ticked
+    const selected = (categoriesGrid.dataset.selected || "").split(/\s+/).filter(Boolean);
 
     const icons = {
       'Technology': 'fas fa-microchip',
@@ -26,6 +28,7 @@ fetch("/categories")
       checkbox.type = "checkbox";
       checkbox.name = "categories[]";
       checkbox.value = category.ID;
+      checkbox.checked = selected.includes(String(category.ID));
 
       const icon = document.createElement("i");
       icon.className = icons[category.Name] || 'fas fa-tag';
@@ -100,9 +103,72 @@ class NotificationManager {
 // Initialize notification manager
 const notificationManager = new NotificationManager();
 
-// Handle form submission
-document.querySelector("form").addEventListener("submit", async function (event) {
+// Autosave new posts as drafts a moment after the author stops typing, so
+// leaving the page doesn't lose them. Edits of published posts aren't drafts.
+const draftID = document.getElementById("draft_id");
+const draftStatus = document.getElementById("draft-status");
+let autosaveTimer = null;
+let publishing = false;
+
+async function saveDraft() {
+  autosaveTimer = null;
+  const title = document.getElementById("title").value;
+  const content = document.getElementById("content").value;
+  if (publishing || (!draftID.value && !title.trim() && !content.trim())) {
+    return;
+  }
+
+  const draftData = new URLSearchParams();
+  draftData.append("draft_id", draftID.value);
+  draftData.append("title", title);
+  draftData.append("content", content);
+  document.querySelectorAll('input[name="categories[]"]:checked')
+    .forEach(checkbox => draftData.append("categories[]", checkbox.value));
+
+  try {
+    // keepalive lets the save on leaving the page finish after it unloads
+    const response = await fetch("/drafts/save", { method: "POST", body: draftData, keepalive: true });
+    const result = await response.json();
+    if (!response.ok) {
+      throw new Error(result.message || response.statusText);
+    }
+    draftID.value = result.id;
+    draftStatus.textContent = "Draft saved " + new Date(result.saved_at).toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
+  } catch (error) {
+    console.error("Error saving draft:", error);
+    draftStatus.textContent = "Draft not saved";
+  }
+}
+
+if (draftID) {
+  const scheduleAutosave = (event) => {
+    if (event.target.id === "image") {
+      return;
+    }
+    clearTimeout(autosaveTimer);
+    autosaveTimer = setTimeout(saveDraft, 2000);
+  };
+  // Watch the post form itself; the logout form in the header comes first
+  const form = document.getElementById("post-form");
+  form.addEventListener("input", scheduleAutosave);
+  form.addEventListener("change", scheduleAutosave);
+  // Save straight away rather than lose the last few words
+  window.addEventListener("beforeunload", () => {
+    if (autosaveTimer) {
+      clearTimeout(autosaveTimer);
+      saveDraft();
+    }
+  });
+}
+
+// Handle form submission. The same form creates posts and edits them; its
+// action says which. The header's logout form comes first on the page, so
+// the post form is picked by id.
+document.getElementById("post-form").addEventListener("submit", async function (event) {
   event.preventDefault();
+  const action = this.getAttribute("action");
+  const postID = document.getElementById("post_id");
+  const removeImage = document.getElementById("remove_image");
 
   // Validate form inputs
   const title = document.getElementById("title").value.trim();
@@ -111,6 +177,9 @@ document.querySelector("form").addEventListener("submit", async function (event)
     document.querySelectorAll('input[name="categories[]"]:checked')
   ).map(checkbox => checkbox.value);
   const image = document.getElementById("image").files[0];
+  // The picker gives the author's local time; the server wants it with its zone
+  const publishAtField = document.getElementById("publish_at");
+  const publishAt = publishAtField && publishAtField.value ? new Date(publishAtField.value) : null;
   //check if image size is above 20mbs
   const maxSizeInBytes = 20 * 1024 * 1024;
   if (image && image.size > maxSizeInBytes) {
@@ -139,6 +208,14 @@ document.querySelector("form").addEventListener("submit", async function (event)
     notificationManager.show("Please select at least one category.", "error");
     return;
   }
+  if (publishAt && publishAt <= new Date()) {
+    notificationManager.show("Choose a publish time in the future.", "error");
+    return;
+  }
+
+  // A pending autosave would otherwise keep the post as a draft too
+  clearTimeout(autosaveTimer);
+  autosaveTimer = null;
 
   try {
     // Handle image upload first if an image is selected
@@ -165,8 +242,21 @@ document.querySelector("form").addEventListener("submit", async function (event)
     postData.append("title", title);
     postData.append("content", content);
     categories.forEach(category => postData.append("categories[]", category));
+    if (postID) {
+      postData.append("post_id", postID.value);
+    }
+    if (draftID && draftID.value) {
+      // Publishing the draft removes it
+      postData.append("draft_id", draftID.value);
+    }
+    if (publishAt) {
+      postData.append("publish_at", publishAt.toISOString());
+    }
+    if (removeImage && removeImage.checked && !image) {
+      postData.append("remove_image", "1");
+    }
 
-    const postResponse = await fetch("/create-post", {
+    const postResponse = await fetch(action, {
       method: "POST",
       body: postData
     });
@@ -177,7 +267,11 @@ document.querySelector("form").addEventListener("submit", async function (event)
     }
 
     if (postResponse.redirected) {
-      notificationManager.show("Post created successfully!", "success");
+      publishing = true;
+      notificationManager.show(
+        postID ? "Post saved!" : publishAt ? "Post scheduled for " + publishAt.toLocaleString() : "Post created successfully!",
+        "success"
+      );
       // Add a small delay before redirect to show the success message
       setTimeout(() => {
         window.location.href = postResponse.url;
@@ -0,0 +1,166 @@
+const securityMessage = document.getElementById("security-message");
+
+const showSecurityError = (message) => showAccountMessage(securityMessage, message);
+
+/**
+ * Post a form to one of the 2FA endpoints and return the parsed response
+ * @param {string} url - The endpoint to call
+ * @param {HTMLFormElement} [form] - Optional form whose fields are sent
+ */
+async function postSecurityForm(url, form) {
+    const response = await fetch(url, {
+        method: "POST",
+        body: form ? new URLSearchParams(new FormData(form)) : undefined,
+    });
+    const data = await response.json();
+    if (!response.ok) {
+        throw new Error(data.message || "Something went wrong. Please try again.");
+    }
+    return data;
+}
+
+// Render recovery codes one per line
+function showRecoveryCodes(codes) {
+    const box = document.getElementById("recovery-codes");
+    box.innerHTML = "";
+    codes.forEach((code) => {
+        const line = document.createElement("div");
+        line.textContent = code;
+        box.appendChild(line);
+    });
+    box.hidden = false;
+}
+
+// Step 1: create a secret and show it as a QR code and as text
+const setupButton = document.getElementById("setup-2fa");
+if (setupButton) {
+    setupButton.addEventListener("click", async () => {
+        try {
+            const data = await postSecurityForm("/account/2fa/setup");
+            document.getElementById("totp-secret").textContent = data.secret;
+            const qr = document.getElementById("totp-qr");
+            qr.innerHTML = "";
+            if (typeof QRCode !== "undefined") {
+                new QRCode(qr, { text: data.uri, width: 180, height: 180 });
+            }
+            setupButton.hidden = true;
+            document.getElementById("setup-step").hidden = false;
+        } catch (error) {
+            showSecurityError(error.message);
+        }
+    });
+}
+
+// Step 2: confirm a code from the app, which turns 2FA on
+const confirmForm = document.getElementById("confirm-form");
+if (confirmForm) {
+    confirmForm.addEventListener("submit", async (e) => {
+        e.preventDefault();
+        try {
+            const data = await postSecurityForm("/account/2fa/confirm", confirmForm);
+            document.getElementById("setup-step").hidden = true;
+            document.getElementById("recovery-step").hidden = false;
+            showRecoveryCodes(data.recovery_codes);
+        } catch (error) {
+            showSecurityError(error.message);
+        }
+    });
+}
+
+const recoveryForm = document.getElementById("recovery-form");
+if (recoveryForm) {
+    recoveryForm.addEventListener("submit", async (e) => {
+        e.preventDefault();
+        try {
+            const data = await postSecurityForm("/account/2fa/recovery-codes", recoveryForm);
+            recoveryForm.reset();
+            showRecoveryCodes(data.recovery_codes);
+            showAccountMessage(securityMessage, "New recovery codes generated. Your old codes no longer work.", "success");
+        } catch (error) {
+            showSecurityError(error.message);
+        }
+    });
+}
+
+const disableForm = document.getElementById("disable-form");
+if (disableForm) {
+    disableForm.addEventListener("submit", async (e) => {
+        e.preventDefault();
+        if (!confirm("Turn off two-factor authentication?")) {
+            return;
+        }
+        try {
+            await postSecurityForm("/account/2fa/disable", disableForm);
+            window.location.reload();
+        } catch (error) {
+            showSecurityError(error.message);
+        }
+    });
+}
+
+// Remove a linked login
+const identityMessage = document.getElementById("identity-message");
+document.querySelectorAll(".unlink-identity").forEach((button) => {
+    button.addEventListener("click", async () => {
+        if (!confirm("Unlink this login from your account?")) {
+            return;
+        }
+        const form = new FormData();
+        form.append("provider", button.dataset.provider);
+        try {
+            const response = await fetch("/account/identities/unlink", {
+                method: "POST",
+                body: new URLSearchParams(form),
+            });
+            const data = await response.json();
+            if (!response.ok) {
+                throw new Error(data.message || "Something went wrong. Please try again.");
+            }
+            window.location.assign("/account/security");
+        } catch (error) {
+            showAccountMessage(identityMessage, error.message);
+        }
+    });
+});
+
+// Create a personal API token and show it once
+const tokenMessage = document.getElementById("token-message");
+const tokenForm = document.getElementById("token-form");
+if (tokenForm) {
+    tokenForm.addEventListener("submit", async (e) => {
+        e.preventDefault();
+        try {
+            const data = await postSecurityForm("/account/tokens/create", tokenForm);
+            tokenForm.reset();
+            document.getElementById("new-token-value").textContent = data.token;
+            document.getElementById("new-token").hidden = false;
+            showAccountMessage(tokenMessage, `Token "${data.api_token.name}" created. Reload the page to see it listed.`, "success");
+        } catch (error) {
+            showAccountMessage(tokenMessage, error.message);
+        }
+    });
+}
+
+// Revoke an API token
+document.querySelectorAll(".revoke-token").forEach((button) => {
+    button.addEventListener("click", async () => {
+        if (!confirm("Revoke this token? Scripts using it will stop working.")) {
+            return;
+        }
+        const form = new FormData();
+        form.append("id", button.dataset.tokenId);
+        try {
+            const response = await fetch("/account/tokens/revoke", {
+                method: "POST",
+                body: new URLSearchParams(form),
+            });
+            const data = await response.json();
+            if (!response.ok) {
+                throw new Error(data.message || "Something went wrong. Please try again.");
+            }
+            button.closest(".account-list-item").remove();
+        } catch (error) {
+            showAccountMessage(tokenMessage, error.message);
+        }
+    });
+});